@@ -41,6 +41,7 @@ var benchmarks = []struct {
 }{
 	// Some of those cases are not optimized yet but could potentially be
 	{"Prefix", "^xxy", strings.Repeat("x", N) + "y", false, NativeHasPrefix, "xxy*"},
+	{"PrefixMatch", "^xxy", "xxy" + strings.Repeat("x", N), true, NativeHasPrefix, "xxy*"},
 	{"Literal", "xx", "y" + strings.Repeat("x", N), true, NativeContains, "*xx*"},
 	{"LiteralN", "xxy", strings.Repeat("x", N), false, NativeContains, "*xxy*"},
 	{"Suffix", "xxy$", "xxy" + strings.Repeat("x", N) + "y", true, NativeHasSuffix, "*xxy"},
@@ -52,6 +53,8 @@ var benchmarks = []struct {
 	{"DotStarSuffix", ".*yxx$", strings.Repeat("x", N), false, nil, "\n*yxx"},
 	{"PrefixDotStarSuffix", "^xxxx.*yxx$", strings.Repeat("x", N), false, nil, "\nxxxx*yxx"},
 	{"PrefixDotStar", "^xxxy.*", strings.Repeat("x", N), false, nil, "\nxxxy*"},
+	{"PrefixDotStarEnd", "^xxxx.*$", strings.Repeat("x", N), true, nil, "\nxxxx*"},
+	{"PrefixDotStarEndN", "^xxxx.*$", strings.Repeat("x", N) + "\n", false, nil, "\nxxxx*"},
 	{"LateDot", "x.y", strings.Repeat("x", N) + "y", true, nil, "*x?y*"},
 	{"LateDotN", "x.y", strings.Repeat("x", N), false, nil, "*x?y*"},
 	{"LateDotHard", "x.y", strings.Repeat("xy", N/2) + "y", true, nil, "*x?y*"},
@@ -68,6 +71,8 @@ var benchmarks = []struct {
 	{"NegativeClassN", "[^b]", strings.Repeat("b", N), false, nil, "*[!b]*"},
 	{"NegativeClassSuffixN", "[^b]$", strings.Repeat("a", N) + "b", false, nil, "*[!b]"},
 	{"NegativeClass2", "[^a][^b]", strings.Repeat("b", N) + "a", true, nil, "*[!a][!b]*"},
+	{"NegativeClassPlus", `^[^/?#]+$`, strings.Repeat("x", N), true, nil, ""},
+	{"NegativeClassPlusN", `^[^/?#]+$`, strings.Repeat("x", N) + "/", false, nil, ""},
 	{"Unmatchable", "a$a$", strings.Repeat("a", N), false, nil, ""},
 	{"SimpleAltPrefix", "abc|abd", strings.Repeat("ab", N/2) + "abc", true, nil, "{*abc*,*abd*}"},
 	{"SimpleAlt", "png|jpg", strings.Repeat("a", N) + ".png", true, nil, "{*png*,*jpg*}"},
@@ -203,6 +208,148 @@ func BenchmarkRegexpBypass(b *testing.B) {
 	}
 }
 
+var routerBenchmarks = []struct {
+	name    string
+	pattern string
+	text    string
+	isMatch bool
+}{
+	{"Fast", `^([^/]*)/index\.[a-z]{3}$`, strings.Repeat("b", N) + "/index.htm", true},
+	{"FastN", `^([^/]*)/index\.[a-z]{3}$`, strings.Repeat("b", N) + "/index", false},
+	{"Slow", `^(.*)/index\.[a-z]{3}$`, strings.Repeat("b", N) + "/index.htm", true},
+}
+
+// BenchmarkRouter isolates the router patterns, both for a boolean match and for extracting the
+// captured path, which goes end-to-end through the bypass matcher. Those patterns aren't fixed-length
+// so they can't be compiled with CompileByteMode.
+//
+// Baseline to flag large regressions manually: the medians of 5 runs of `make benchrouter`
+// (-count=5) with go1.27.1 on linux/amd64, on a single core of an Intel Xeon. The stddfa, pcre
+// and rust engines (matloob.io/regexp, libpcre and librure) weren't available on that machine:
+//
+//	BenchmarkRouter/Fast/bypass             93.7 ns/op     0 B/op  0 allocs/op
+//	BenchmarkRouter/Fast/bypasssubmatch      207 ns/op    64 B/op  2 allocs/op
+//	BenchmarkRouter/Fast/std               14515 ns/op     0 B/op  0 allocs/op
+//	BenchmarkRouter/Fast/stdsubmatch       14536 ns/op    32 B/op  1 allocs/op
+//	BenchmarkRouter/Fast/stddfa           not recorded
+//	BenchmarkRouter/Fast/pcre             not recorded
+//	BenchmarkRouter/Fast/regexp2            3676 ns/op  4096 B/op  1 allocs/op
+//	BenchmarkRouter/Fast/rust             not recorded
+//	BenchmarkRouter/FastN/bypass            35.1 ns/op     0 B/op  0 allocs/op
+//	BenchmarkRouter/FastN/bypasssubmatch    38.2 ns/op     0 B/op  0 allocs/op
+//	BenchmarkRouter/FastN/std              13674 ns/op     0 B/op  0 allocs/op
+//	BenchmarkRouter/FastN/stdsubmatch      14300 ns/op     0 B/op  0 allocs/op
+//	BenchmarkRouter/FastN/stddfa          not recorded
+//	BenchmarkRouter/FastN/pcre            not recorded
+//	BenchmarkRouter/FastN/regexp2          33019 ns/op  4096 B/op  1 allocs/op
+//	BenchmarkRouter/FastN/rust            not recorded
+//	BenchmarkRouter/Slow/bypass             95.9 ns/op     0 B/op  0 allocs/op
+//	BenchmarkRouter/Slow/bypasssubmatch      206 ns/op    64 B/op  2 allocs/op
+//	BenchmarkRouter/Slow/std                7119 ns/op     0 B/op  0 allocs/op
+//	BenchmarkRouter/Slow/stdsubmatch        7242 ns/op    32 B/op  1 allocs/op
+//	BenchmarkRouter/Slow/stddfa           not recorded
+//	BenchmarkRouter/Slow/pcre             not recorded
+//	BenchmarkRouter/Slow/regexp2            3999 ns/op  4096 B/op  1 allocs/op
+//	BenchmarkRouter/Slow/rust             not recorded
+func BenchmarkRouter(b *testing.B) {
+
+	b.ReportAllocs()
+
+	for _, bm := range routerBenchmarks {
+
+		b.Run(bm.name+"/bypass", func(b *testing.B) {
+			re := regexpb.MustCompile(bm.pattern)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if re.MatchString(bm.text) != bm.isMatch {
+					b.Fatal("")
+				}
+			}
+		})
+
+		b.Run(bm.name+"/bypasssubmatch", func(b *testing.B) {
+			re := regexpb.MustCompile(bm.pattern)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if (re.FindStringSubmatch(bm.text) != nil) != bm.isMatch {
+					b.Fatal("")
+				}
+			}
+		})
+
+		b.Run(bm.name+"/std", func(b *testing.B) {
+			re := regexp.MustCompile(bm.pattern)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if re.MatchString(bm.text) != bm.isMatch {
+					b.Fatal("")
+				}
+			}
+		})
+
+		b.Run(bm.name+"/stdsubmatch", func(b *testing.B) {
+			re := regexp.MustCompile(bm.pattern)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if (re.FindStringSubmatch(bm.text) != nil) != bm.isMatch {
+					b.Fatal("")
+				}
+			}
+		})
+
+		b.Run(bm.name+"/stddfa", func(b *testing.B) {
+			re := regexpdfa.MustCompile(bm.pattern)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if re.MatchString(bm.text) != bm.isMatch {
+					b.Fatal("")
+				}
+			}
+		})
+
+		b.Run(bm.name+"/pcre", func(b *testing.B) {
+			re := pcre.MustCompile(bm.pattern, 0)
+			matcher := re.MatcherString("", 0)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				matcher.ResetString(re, bm.text, 0)
+				if matcher.Matches() != bm.isMatch {
+					b.Fatal("")
+				}
+			}
+		})
+
+		b.Run(bm.name+"/regexp2", func(b *testing.B) {
+			re := regexp2.MustCompile(bm.pattern, 0)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				isMatch, _ := re.MatchString(bm.text)
+				if isMatch != bm.isMatch {
+					b.Fatal("")
+				}
+			}
+		})
+
+		b.Run(bm.name+"/rust", func(b *testing.B) {
+			re := rust.MustCompile(bm.pattern)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if re.IsMatch(bm.text) != bm.isMatch {
+					b.Fatal("")
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkStringsSuffix(b *testing.B) {
 
 	println("")
@@ -0,0 +1,80 @@
+//go:build engines
+// +build engines
+
+// TestEngines needs the PCRE and Rust engines, which aren't installed by `go get`: install libpcre
+// and build the rure C library (see github.com/BurntSushi/rure-go), then run `make testengines`.
+
+package regexpbypass
+
+import (
+	rust "github.com/BurntSushi/rure-go"
+	"github.com/glenn-brown/golang-pkg-pcre/src/pkg/pcre"
+	regexpb "github.com/sylvinus/regexp-bypass/regexp"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var enginesAtoms = []string{"a", "b", "c", ".", "[ab]", "[^a]", "a?", "b+", "(a)", "(?:ab|cd)", "☺"}
+
+var enginesTexts = []string{"", "a", "b", "ab", "ba", "abc", "aab", "cab", "abcd", "bbb", "a☺b", "☺a", "a\nb", "ab\n"}
+
+// enginesPatterns generates all the concatenations of up to n atoms, optionally anchored
+func enginesPatterns(n int) (patterns []string) {
+	bodies := []string{""}
+	for i := 0; i < n; i++ {
+		var next []string
+		for _, body := range bodies {
+			for _, atom := range enginesAtoms {
+				next = append(next, body+atom)
+			}
+		}
+		bodies = next
+		for _, body := range bodies {
+			patterns = append(patterns, body, "^"+body, body+"$", "^"+body+"$")
+		}
+	}
+	return patterns
+}
+
+// TestEngines checks that the bypass matcher agrees with the standard library, PCRE and Rust
+// on the patterns it supports. Inputs hitting known semantic differences are skipped: PCRE's `$`
+// also matches before a final `\n`, and it isn't compiled in UTF-8 mode.
+func TestEngines(t *testing.T) {
+
+	for _, pattern := range enginesPatterns(3) {
+
+		re, err := regexpb.CompileStrict(pattern)
+		if err != nil {
+			continue
+		}
+		std := regexp.MustCompile(pattern)
+		rustre, err := rust.Compile(pattern)
+		if err != nil {
+			t.Errorf("pattern: %s doesn't compile in Rust: %s", pattern, err)
+			continue
+		}
+		var pcrere *pcre.Regexp
+		if !strings.Contains(pattern, "☺") {
+			compiled := pcre.MustCompile(pattern, 0)
+			pcrere = &compiled
+		}
+
+		for _, text := range enginesTexts {
+
+			matched := re.MatchString(text)
+
+			if want := std.MatchString(text); matched != want {
+				t.Errorf("pattern: %s text: %q got %t, stdlib says %t", pattern, text, matched, want)
+			}
+			if want := rustre.IsMatch(text); matched != want {
+				t.Errorf("pattern: %s text: %q got %t, Rust says %t", pattern, text, matched, want)
+			}
+			if pcrere != nil && !strings.Contains(text, "\n") && !strings.Contains(text, "☺") {
+				if want := pcrere.MatcherString(text, 0).Matches(); matched != want {
+					t.Errorf("pattern: %s text: %q got %t, PCRE says %t", pattern, text, matched, want)
+				}
+			}
+		}
+	}
+}
@@ -7,6 +7,7 @@ package regexp
 import (
 	"reflect"
 	"regexp/syntax"
+	"strconv"
 	"strings"
 	"testing"
 	"unicode/utf8"
@@ -692,6 +693,418 @@ func BenchmarkReplaceAll(b *testing.B) {
 	}
 }
 
+func BenchmarkReplaceAllLiteralString(b *testing.B) {
+	x := strings.Repeat("this is a long line that contains foo bar baz ", 100)
+	re := MustCompile("foo")
+	b.Run("regexp", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			re.ReplaceAllLiteralString(x, "qux")
+		}
+	})
+	b.Run("strings", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			strings.ReplaceAll(x, "foo", "qux")
+		}
+	})
+}
+
+// The bypass matcher compares literals with ==, which the runtime already does
+// word-at-a-time (with SIMD on most platforms), so it should run close to native.
+func BenchmarkLongLiteral(b *testing.B) {
+	literal := strings.Repeat("abcdefgh", 512)
+	x := literal
+	b.Run("exact", func(b *testing.B) {
+		re := MustCompile("^" + literal + "$")
+		b.SetBytes(int64(len(x)))
+		for i := 0; i < b.N; i++ {
+			if !re.MatchString(x) {
+				b.Fatal("no match")
+			}
+		}
+	})
+	b.Run("step", func(b *testing.B) {
+		re := MustCompile("^" + literal + ".$")
+		y := x + "z"
+		b.SetBytes(int64(len(y)))
+		for i := 0; i < b.N; i++ {
+			if !re.MatchString(y) {
+				b.Fatal("no match")
+			}
+		}
+	})
+	b.Run("native", func(b *testing.B) {
+		y := string([]byte(literal))
+		b.SetBytes(int64(len(x)))
+		for i := 0; i < b.N; i++ {
+			if x != y {
+				b.Fatal("no match")
+			}
+		}
+	})
+}
+
+func BenchmarkASCIIClass(b *testing.B) {
+	x := strings.Repeat("x", 1000) + "1"
+	b.Run("bitmap", func(b *testing.B) {
+		re := MustCompile(`[[:digit:]]`)
+		b.SetBytes(int64(len(x)))
+		for i := 0; i < b.N; i++ {
+			if !re.MatchString(x) {
+				b.Fatal("no match")
+			}
+		}
+	})
+	b.Run("ranges", func(b *testing.B) {
+		re := MustCompile(`[[:digit:]]`)
+		re.bypass.(*byPassProgUnanchored).steps[0].asciiOnly = false // always loop over the ranges
+		b.SetBytes(int64(len(x)))
+		for i := 0; i < b.N; i++ {
+			if !re.MatchString(x) {
+				b.Fatal("no match")
+			}
+		}
+	})
+}
+
+func BenchmarkExactWidthClass(b *testing.B) {
+	re := MustCompile(`^[a-z]{5}$`)
+	for _, x := range []string{"abcd", "abcdef", "abcde"} {
+		b.Run(strconv.Itoa(len(x)), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				re.MatchString(x)
+			}
+		})
+	}
+}
+
+func BenchmarkSuffixes(b *testing.B) {
+	suffixes := make([]string, 50)
+	for i := range suffixes {
+		suffixes[i] = ".ext" + strconv.Itoa(i)
+	}
+	x := strings.Repeat("a", 100) + ".ext49"
+	trie, err := CompileSuffixes(suffixes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, test := range []struct {
+		name string
+		re   *Regexp
+	}{
+		{"trie", trie},
+		{"alternate", MustCompile(trie.String())},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if !test.re.MatchString(x) {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDotStarRest(b *testing.B) {
+	x := "xxxx" + strings.Repeat("a", 1000) + "yxx"
+	re := MustCompile(`^xxxx(.*)(.*)yxx$`)
+	b.Run("bypass", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if !re.MatchString(x) {
+				b.Fatal("no match")
+			}
+		}
+	})
+	// The same prog, with the rest of the pattern executed by the other matchers
+	prog := *re.bypass.(*byPassProgFirstPass)
+	prog.dotStarRest = false
+	b.Run("residual", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if !prog.MatchString(x) {
+				b.Fatal("no match")
+			}
+		}
+	})
+}
+
+func BenchmarkAlternateByWidth(b *testing.B) {
+	re := MustCompile(anchoredWordsAlternation(1000))
+	// The same prog, trying all the parts of the alternation
+	prog := *re.bypass.(*byPassProgAlternate)
+	prog.byWidth = nil
+	for _, test := range []struct {
+		name string
+		prog byPassProg
+	}{
+		{"byWidth", re.bypass},
+		{"all", &prog},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if !test.prog.MatchString("www502") {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSmallClass(b *testing.B) {
+	x := strings.Repeat("xyz bcd fgh ", 100) + "a"
+	step := MustCompile(`[aeiou]`).bypass.(*byPassProgUnanchored).steps[0]
+	// The same step, checking the ASCII bitmap, then the ranges
+	bitmap := *step
+	bitmap.byteSet = nil
+	ranges := bitmap
+	ranges.asciiOnly = false
+	for _, test := range []struct {
+		name string
+		step *byPassStep
+	}{
+		{"byteSet", step},
+		{"asciiSet", &bitmap},
+		{"ranges", &ranges},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if idx, _ := findCharClass(x, test.step); idx != len(x)-1 {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkASCIIPrefix(b *testing.B) {
+	x := "abcdef" + strings.Repeat("x", 100)
+	class := MustCompile(`^[a-z]{3}`).bypass.(*byPassProgAnchored)
+	// The same prog, without its ASCII bitmaps
+	steps := *class
+	steps.asciiBytes = nil
+	for _, test := range []struct {
+		name string
+		prog byPassProg
+	}{
+		{"literal", MustCompile(`^abc`).bypass},
+		{"asciiBytes", class},
+		{"steps", &steps},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if !test.prog.MatchString(x) {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRepeatedClass(b *testing.B) {
+	x := strings.Repeat("ID-12 ID-123-Y ", 100) + "ID-1234-X"
+	prog := MustCompile(`ID-[0-9]{4}-X`).bypass.(*byPassProgUnanchored)
+	// The same prog, with one step for each digit
+	digit := *prog.steps[1]
+	digit.length, digit.minWidth, digit.maxWidth = 1, 1, 1
+	split := &byPassProgAnchored{steps: []*byPassStep{prog.steps[0]}}
+	for i := 0; i < 4; i++ {
+		step := digit
+		split.steps = append(split.steps, &step)
+	}
+	split.steps = append(split.steps, prog.steps[2])
+	split.computeWidth()
+	for _, test := range []struct {
+		name string
+		prog byPassProg
+	}{
+		{"repeated", prog},
+		{"split", &byPassProgUnanchored{steps: split.steps, length: split.length, minWidth: split.minWidth, maxWidth: split.maxWidth}},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if !test.prog.MatchString(x) {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMatchLines(b *testing.B) {
+	x := strings.Repeat("INFO request served in 12ms\nWARN slow request\n", 500) + "ERROR disk full\n"
+	bypass, std := MustCompile(`(?m)^ERROR`), MustCompile(`(?m)^ERROR`)
+	std.bypassLines = nil
+	for _, test := range []struct {
+		name string
+		re   *Regexp
+	}{
+		{"bypass", bypass},
+		{"std", std},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if matched := test.re.MatchLines(x); !matched[len(matched)-1] {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCompileSharedPrefix(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		MustCompile(`^/api/v[0-9]/users/[a-z]{3}-[0-9]{1,16}$`)
+	}
+}
+
+func BenchmarkExactLiteral(b *testing.B) {
+	re := MustCompile(`^xxxxy$`)
+	if re.ByPassKind() != ByPassLiteral {
+		b.Fatalf("pat: ^xxxxy$ got %s, want Literal", re.ByPassKind())
+	}
+	for _, text := range []string{strings.Repeat("x", 1000), "xxxxy"} {
+		want := text == "xxxxy"
+		b.Run("bypass/"+strconv.Itoa(len(text)), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if re.MatchString(text) != want {
+					b.Fatal("wrong result")
+				}
+			}
+		})
+		b.Run("native/"+strconv.Itoa(len(text)), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if (text == "xxxxy") != want {
+					b.Fatal("wrong result")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFixedCaptures(b *testing.B) {
+	re := MustCompile(`^(\d{4})-(\d{2})-(\d{2})$`)
+	if re.bypassSubmatch == nil {
+		b.Fatalf("pat: %s doesn't report submatches with the bypass matcher", re)
+	}
+	want := []int{0, 10, 0, 4, 5, 7, 8, 10}
+	for i := 0; i < b.N; i++ {
+		if !reflect.DeepEqual(re.FindStringSubmatchIndex("2024-02-29"), want) {
+			b.Fatal("wrong result")
+		}
+	}
+}
+
+func BenchmarkNumericClass(b *testing.B) {
+	re := MustCompile(`^\p{Nd}+$`)
+	for _, text := range []string{strings.Repeat("0123456789", 10), strings.Repeat("٠١٢٣٤٥٦٧٨٩", 10), strings.Repeat("０１２３４５６７８９", 10)} {
+		b.Run(strconv.Itoa(len(text)), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if !re.MatchString(text) {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkContainsLiteral(b *testing.B) {
+	re := MustCompile(`error`)
+	if re.ByPassKind() != ByPassLiteral {
+		b.Fatalf("pat: error got %s, want Literal", re.ByPassKind())
+	}
+	for _, text := range []string{"an error", "no problem", strings.Repeat("x", 1000) + "error"} {
+		want := strings.Contains(text, "error")
+		b.Run("bypass/"+strconv.Itoa(len(text)), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if re.MatchString(text) != want {
+					b.Fatal("wrong result")
+				}
+			}
+		})
+		b.Run("native/"+strconv.Itoa(len(text)), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if strings.Contains(text, "error") != want {
+					b.Fatal("wrong result")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAppendStringIndex(b *testing.B) {
+	re := MustCompile(`a.b`)
+	text := strings.Repeat("xxa☺bxaxb", 100)
+	b.Run("FindAllStringIndex", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if len(re.FindAllStringIndex(text, -1)) != 200 {
+				b.Fatal("wrong result")
+			}
+		}
+	})
+	b.Run("AppendStringIndex", func(b *testing.B) {
+		b.ReportAllocs()
+		var dst []int
+		for i := 0; i < b.N; i++ {
+			if dst = re.AppendStringIndex(dst[:0], text); len(dst) != 400 {
+				b.Fatal("wrong result")
+			}
+		}
+	})
+}
+
+func BenchmarkASCIISuffix(b *testing.B) {
+	x := strings.Repeat("x", 1000) + "abc123"
+	class := MustCompile(`[0-9]{3}$`).bypass.(*byPassProgAnchored)
+	// The same prog, counting the runes from the end with lastRunesWidth
+	steps := *class
+	steps.asciiBytes = nil
+	for _, test := range []struct {
+		name string
+		prog byPassProg
+	}{
+		{"asciiBytes", class},
+		{"steps", &steps},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if !test.prog.MatchString(x) {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWholeStringClass(b *testing.B) {
+	x := strings.Repeat("1234567890", 100)
+	re := MustCompile(`^\d+$`)
+	b.SetBytes(int64(len(x)))
+	for i := 0; i < b.N; i++ {
+		if !re.MatchString(x) {
+			b.Fatal("no match")
+		}
+	}
+}
+
+func BenchmarkFindAllString(b *testing.B) {
+	x := strings.Repeat("x", 1000)
+	b.Run("capacity", func(b *testing.B) {
+		re := MustCompile("x")
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			re.FindAllString(x, -1)
+		}
+	})
+	b.Run("append", func(b *testing.B) {
+		re := MustCompile("x")
+		re.minLength = 0 // always start with startSize
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			re.FindAllString(x, -1)
+		}
+	})
+}
+
 func BenchmarkAnchoredLiteralShortNonMatch(b *testing.B) {
 	b.StopTimer()
 	x := []byte("abcdefghijklmnopqrstuvwxyz")
@@ -821,6 +1234,45 @@ func BenchmarkMatchParallelCopied(b *testing.B) {
 	})
 }
 
+func BenchmarkMatchStrings(b *testing.B) {
+	inputs := make([]string, 100)
+	for i := range inputs {
+		inputs[i] = "this is a long line that contains foo bar baz" + strings.Repeat("x", i)
+	}
+	re := MustCompile("foo (ba+r)? baz")
+	b.Run("loop", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			matched := make([]bool, len(inputs))
+			for j, s := range inputs {
+				matched[j] = re.MatchString(s)
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			re.MatchStrings(inputs)
+		}
+	})
+}
+
+func BenchmarkMatchEachBytes(b *testing.B) {
+	lines := make([][]byte, 100)
+	for i := range lines {
+		lines[i] = []byte("GET /index.html HTTP/1.1 " + strings.Repeat("x", i))
+	}
+	for _, pat := range []string{`^GET /`, `HTTP/1\.[01]`} {
+		re := MustCompile(pat)
+		b.Run(pat, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				re.MatchEachBytes(lines)
+			}
+		})
+	}
+}
+
 var sink string
 
 func BenchmarkQuoteMetaAll(b *testing.B) {
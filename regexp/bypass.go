@@ -5,9 +5,17 @@
 package regexp
 
 import (
+	"context"
+	"errors"
+	"io"
 	"regexp/syntax"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"unicode"
 	"unicode/utf8"
+	"unsafe"
 )
 
 // byPassOp is a custom Op understood by the matchers
@@ -16,26 +24,33 @@ type byPassOp uint8
 const (
 	byPassOpLiteral           byPassOp = iota + 1 // `abc`
 	byPassOpCharClass                             // `[a-z]`
-	byPassOpNegativeCharClass                     // `[^a]` (supports only a single character)
+	byPassOpNegativeCharClass                     // `[^a]`, `[^a-cx]`
 	byPassOpAnyChar                               // [\w\W]
+	byPassOpLiteralSet                            // `(?:cd|ef)`, literals with the same number of runes
 )
 
 // byPassStep is a step in the matching algorithm
 type byPassStep struct {
 	op             byPassOp
-	classes        []rune // storage for byPassOpCharClass
-	literal        string // storage for byPassOpLiteral
-	char           rune   // storage for byPassOpNegativeCharClass
-	length         int    // number of Runes to match
-	previousLength int    // number of Runes in previous steps
-	minWidth       int    // minimum number of bytes
-	maxWidth       int    // maximum number of bytes, -1 if unknown
-	minNextWidth   int    // minimum number of bytes needed to match from this step to the end of the pattern
-	anchored       bool   // true if we are anchored from the beginning or from the end
-	anchorIndex    int    // number of runes, can be negative if starting from the end
+	classes        []rune     // storage for byPassOpCharClass, or excluded ranges for byPassOpNegativeCharClass, sorted
+	literal        string     // storage for byPassOpLiteral, or excluded characters for byPassOpNegativeCharClass if they are all ASCII
+	char           rune       // storage for byPassOpNegativeCharClass with a single excluded character
+	literals       []string   // storage for byPassOpLiteralSet
+	asciiSet       [2]uint64  // bitmap of the characters in classes, if they are all ASCII (`\d`, `[[:alpha:]]`...)
+	length         int        // number of Runes to match
+	previousLength int        // number of Runes in previous steps
+	minWidth       int        // minimum number of bytes
+	maxWidth       int        // maximum number of bytes, -1 if unknown
+	minNextWidth   int        // minimum number of bytes needed to match from this step to the end of the pattern
+	anchored       bool       // true if we are anchored from the beginning or from the end
+	asciiOnly      bool       // true if asciiSet can be used instead of classes
+	byteSet        *[256]bool // membership of each byte, for small ASCII classes (`[aeiou]`). nil otherwise.
+	anchorIndex    int        // number of runes, can be negative if starting from the end
 }
 
 // byPassProg is the main interface we expose to the rest of the package.
+// Progs are never modified once compiled: their methods must only keep state on the stack
+// (stats excepted, which are atomic), so that a Regexp can be used by many goroutines without locking.
 // TODO: add other methods
 type byPassProg interface {
 	MatchString(s string) (matched bool)
@@ -43,15 +58,76 @@ type byPassProg interface {
 
 var notByPass byPassProg = nil
 
+// byPassMaxExpansions is the default maximum number of fixed-length alternatives a pattern with `?`
+// or a bounded repeat can be expanded to. CompileMaxExpansions can change it.
+const byPassMaxExpansions = 16
+
+// byPassIndexProg is implemented by the byPassProgs that can also report the location of the leftmost match
+type byPassIndexProg interface {
+	// IndexString returns the byte offsets of the leftmost match starting at or after pos, or -1 if there is none
+	IndexString(s string, pos int) (matchBegin int, matchEnd int)
+}
+
+// byPassLastIndexProg is implemented by the byPassProgs that can locate their last match by searching backwards
+type byPassLastIndexProg interface {
+	// LastIndexString returns the byte offsets of the match beginning at the largest offset, or -1 if there is none
+	LastIndexString(s string) (matchBegin int, matchEnd int)
+}
+
+// byPassReaderProg is implemented by the byPassProgs that can locate a match in a stream of runes
+type byPassReaderProg interface {
+	// ReaderIndex returns the byte offsets of the leftmost match in the stream, or -1 if there is none
+	ReaderIndex(r io.RuneReader) (matchBegin int, matchEnd int)
+}
+
+// byPassSubmatchProg is implemented by the byPassProgs that can also report submatch offsets
+type byPassSubmatchProg interface {
+	FindStringSubmatchIndex(s string) (loc []int)
+	// NumSubexp returns the number of capturing groups reported, or -1 if some can't be reported
+	NumSubexp() int
+}
+
+// ByPassStats holds the match statistics counters of a Regexp.
+// They are only updated when the package is built with `-tags bypassstats`.
+type ByPassStats struct {
+	ByPass          uint64 // MatchString calls executed by the bypass matcher
+	Fallback        uint64 // MatchString calls executed by the standard matchers
+	EarlyRejections uint64 // bypass calls rejected because of minWidth/maxWidth
+}
+
+// byPassStats is the shared storage for ByPassStats, updated atomically
+type byPassStats struct {
+	byPass          uint64
+	fallback        uint64
+	earlyRejections uint64
+}
+
 // byPassProgAnchored is the main matcher for fixed-length anchored patterns
 type byPassProgAnchored struct {
 	steps         []*byPassStep // Steps to execute
 	anchoredBegin bool
 	anchoredEnd   bool
-	unmatchable   bool // if true, this pattern will never match (e.g. `a$a`)
-	length        int  // number of Runes
-	minWidth      int  // minimum number of bytes
-	maxWidth      int  // maximum number of bytes, -1 if unknown
+	unmatchable   bool         // if true, this pattern will never match (e.g. `a$a`)
+	length        int          // number of Runes
+	minWidth      int          // minimum number of bytes
+	maxWidth      int          // maximum number of bytes, -1 if unknown
+	stats         *byPassStats // nil unless byPassStatsEnabled
+
+	// If anchored with only ASCII literals and classes (`^[a-z]{3}`, `[0-9]{3}$`), the bitmap
+	// of the bytes allowed at each position, checked without decoding runes. nil otherwise.
+	asciiBytes [][2]uint64
+
+	// Capturing groups, at fixed rune offsets from the beginning of the match
+	// (`^(\d{4})-(\d{2})$`), in the order they were traversed
+	captures  []byPassCapture
+	numSubexp int // highest index of the capturing groups
+}
+
+// byPassCapture locates a fixed-length capturing group in a byPassProgAnchored
+type byPassCapture struct {
+	index int // index of the capturing group
+	begin int // number of runes before the group
+	end   int // number of runes before the end of the group
 }
 
 // byPassProgUnanchored is the main matcher for fixed-length unanchored patterns
@@ -60,11 +136,51 @@ type byPassProgUnanchored struct {
 	length   int           // number of Runes
 	minWidth int           // minimum number of bytes
 	maxWidth int           // maximum number of bytes, -1 if unknown
+	stats    *byPassStats  // nil unless byPassStatsEnabled
+}
+
+// byPassProgLiteral is a specialized matcher for literal-only patterns (e.g. `^abc`, `abc`, `abc$`).
+// It never decodes runes and runs like strings.HasPrefix, strings.Contains or strings.HasSuffix.
+type byPassProgLiteral struct {
+	literal         string
+	runes           []rune // literal as runes, only used by ReaderIndex
+	anchoredBegin   bool
+	anchoredEnd     bool
+	wordBoundaryEnd bool         // if true, the literal ends with a word character and must not be followed by one (`foo\b`)
+	stats           *byPassStats // nil unless byPassStatsEnabled
+
+	// The word characters of `\b`, set by CompileWordChars. nil for the ASCII ones of syntax.IsWordChar
+	isWordChar func(rune) bool
 }
 
 // byPassProgAlternate can match top-level alternations like `jpg|png`
 type byPassProgAlternate struct {
 	progs []byPassProg // one byPassProg for each part of the alternation
+
+	// If all the parts are anchored on both ends (`^[0-9]{4}$|^[a-z]{2}$|^a.$|...`), only those with the
+	// width of the string, in bytes, and those with a variable width are tried. nil otherwise.
+	byWidth  map[int][]byPassProg
+	anyWidth []byPassProg
+}
+
+// byPassMinWidthIndex is the minimum number of parts in an alternation for byWidth to be worth it
+const byPassMinWidthIndex = 8
+
+// byPassProgResidual executes a part of a byPassProgAlternate that can't be bypassed
+// with the other matchers (e.g. `(a+b+)` in `abc|(a+b+)`)
+type byPassProgResidual struct {
+	regexp *Regexp // A new Regexp that matches only this part of the alternation
+}
+
+// byPassProgPrefixAlternate can match anchored alternations sharing a fixed-length prefix (e.g. `^abc(?:1|22|333)$`)
+type byPassProgPrefixAlternate struct {
+	prefixProg *byPassProgAnchored   // shared prefix, matched only once
+	progs      []*byPassProgAnchored // one prog for each part of the alternation, anchored after the prefix
+
+	// If all the parts are anchored to the end and there are at least byPassMinWidthIndex of them, only
+	// those with the width of the rest of the string and those with a variable width are tried. nil otherwise.
+	byWidth  map[int][]*byPassProgAnchored
+	anyWidth []*byPassProgAnchored
 }
 
 // byPassProgFirstPass can match fixed-length prefixes and suffixes in a complex regexp (e.g. `^aa(c*)bb$`)
@@ -72,404 +188,2875 @@ type byPassProgFirstPass struct {
 	prefixProg *byPassProgAnchored
 	suffixProg *byPassProgAnchored
 	regexp     *Regexp // A new Regexp that matches the rest of the pattern after prefix & suffix were matched.
+
+	// If the rest of the pattern is only made of `.*` and `.+` (`^xx.*.+yy$`), it is checked without regexp
+	dotStarRest   bool
+	restMinLength int  // minimum number of runes in the rest, one for each `.+`
+	restMatchNL   bool // if true, the rest can contain `\n` (OpAnyChar)
+}
+
+// byPassProgPlus can match a single `class+` or `class*` spanning the rest of the string, after
+// optional fixed-length prefixes and suffixes (e.g. `^/users/([^/]+)$`, `^[a-z]*$`). The class
+// can also be a literal, repeated a whole number of times (e.g. `^foo(bar)*$`), or a single-rune
+// class with a bounded repeat too long to be expanded (e.g. `^abc[0-9]{1,40}$`, `^abc[0-9]{2,}$`).
+// Without `$`, a single-rune `class+` or `class*` after the prefix extends over as many runes of the
+// class as it can (e.g. `^[A-Z][a-z]+`).
+// A standalone unanchored `class+` (e.g. `[^\n]+`) only needs to find a single rune of the class.
+type byPassProgPlus struct {
+	prefixProg *byPassProgAnchored
+	suffixProg *byPassProgAnchored
+	step       *byPassStep           // step repeated between the prefix and the suffix, on every rune unless it's a literal
+	capture    int                   // index of the capturing group around the `class+`, 0 if none
+	star       bool                  // if true, the step can be repeated zero times (`class*`)
+	minCount   int                   // minimum number of runes matched by the step (`class{n,m}`), 0 or 1 for `class*` and `class+`
+	maxCount   int                   // maximum number of runes matched by the step (`class{n,m}`), 0 if unbounded
+	openEnd    bool                  // if true, there is no `$` and the match ends at the first rune out of the class
+	find       *byPassProgUnanchored // if not nil, the `class+` is unanchored and starts where this prog finds its first rune
+}
+
+// byPassProgDotStarSuffix can match a leading `.*` followed by a fixed-length suffix (e.g. `.*foo$`).
+// Without `^`, the `.*` can also be a single-rune `class*` or `class+` (e.g. `[^.]*\.txt$`).
+type byPassProgDotStarSuffix struct {
+	suffixProg    *byPassProgAnchored
+	step          *byPassStep // step of the `class+` or `class*` before the suffix, nil for `.*`
+	plus          bool        // if true, the step must be repeated at least once (`class+`)
+	anchoredBegin bool        // if true, the `.*` must span the whole beginning of the string (e.g. `^.*foo$`)
+	matchNL       bool        // if true, the `.*` can match `\n` (OpAnyChar)
+}
+
+// byPassProgPrefixDotStar can match an anchored fixed-length prefix followed by `.*` and
+// an optional fixed-length pattern (e.g. `^abc.*xyz`)
+type byPassProgPrefixDotStar struct {
+	prefixProg  *byPassProgAnchored
+	restProg    byPassIndexProg // byPassProgUnanchored, or byPassProgAnchored if anchored to the end. nil if empty.
+	matchNL     bool            // if true, the `.*` can match `\n` (OpAnyChar)
+	anchoredEnd bool            // if true, the `.*` must span the whole end of the string (e.g. `^abc.*$`)
+}
+
+// byPassProgContains can match a fixed-length pattern between two `.*` (e.g. `.*foo.*`). The `.*` can
+// always match an empty string, so the pattern only needs to be found anywhere in the string.
+type byPassProgContains struct {
+	coreProg byPassIndexProg // byPassProgLiteral or byPassProgUnanchored
+	matchNL  bool            // if true, the `.*` can match `\n` (OpAnyChar)
+}
+
+// byPassProgLengthRange can match patterns made only of `.` and anchored on both ends (e.g. `^.{3,5}$`),
+// which only constrain the number of runes in the string
+type byPassProgLengthRange struct {
+	minLength int          // minimum number of Runes
+	maxLength int          // maximum number of Runes
+	matchNL   bool         // if true, the `.` can match `\n` (OpAnyChar)
+	stats     *byPassStats // nil unless byPassStatsEnabled
 }
 
 // byPassProgUnmatchable never matches anything
 type byPassProgUnmatchable struct {
 }
 
-// nextRunesWidth returns the number of bytes that encode the next `n` runes
-func nextRunesWidth(s string, n int) (width int) {
-	for i := 0; i < n && width < len(s); i++ {
-		_, w := utf8.DecodeRuneInString(s[width:])
-		width += w
-	}
-	return width
+// byPassProgEmpty can match patterns that only match empty strings (the empty
+// pattern, `^`, `^$`...), where only the anchors need to be checked
+type byPassProgEmpty struct {
+	anchoredBegin bool
+	anchoredEnd   bool
 }
 
-// lastRunesWidth returns the number of bytes that encode the last `n` runes
-func lastRunesWidth(s string, n int) (width int) {
-	for i := 0; i < n; i++ {
-		if width >= len(s) {
-			return -1
+// CompileLiterals returns a Regexp matching any of the literal strings, without
+// parsing nor escaping them. If anchored is true, it behaves like
+// `^(?:lit1|lit2|...)$`, otherwise like `lit1|lit2|...`.
+//
+// Like MustCompile, it panics if a literal isn't valid UTF-8, which
+// Compile(QuoteMeta(literal)) would reject.
+func CompileLiterals(literals []string, anchored bool) *Regexp {
+
+	// Build the syntax tree directly, one part of the alternation for each literal (`^lit1$|^lit2$`)
+	tree := &syntax.Regexp{Op: syntax.OpAlternate, Flags: syntax.Perl}
+	quoted := make([]string, len(literals))
+	for i, literal := range literals {
+		if !utf8.ValidString(literal) {
+			panic(`regexp: CompileLiterals(` + quote(literal) + `): invalid UTF-8`)
 		}
-		_, w := utf8.DecodeLastRuneInString(s[:len(s)-width])
-		width += w
+		var sub *syntax.Regexp
+		if literal == "" {
+			sub = &syntax.Regexp{Op: syntax.OpEmptyMatch, Flags: syntax.Perl}
+		} else {
+			sub = &syntax.Regexp{Op: syntax.OpLiteral, Flags: syntax.Perl, Rune: []rune(literal)}
+		}
+		if anchored {
+			sub = &syntax.Regexp{Op: syntax.OpConcat, Flags: syntax.Perl, Sub: []*syntax.Regexp{
+				{Op: syntax.OpBeginText, Flags: syntax.Perl},
+				sub,
+				{Op: syntax.OpEndText, Flags: syntax.Perl},
+			}}
+		}
+		tree.Sub = append(tree.Sub, sub)
+		quoted[i] = QuoteMeta(literal)
 	}
-	return width
+
+	switch len(tree.Sub) {
+	case 0:
+		tree = &syntax.Regexp{Op: syntax.OpNoMatch, Flags: syntax.Perl}
+	case 1:
+		tree = tree.Sub[0]
+	}
+
+	expr := strings.Join(quoted, "|")
+	if len(literals) == 0 {
+		expr = tree.String()
+	} else if anchored {
+		expr = `^(?:` + expr + `)$`
+	}
+
+	// Error is safe to ignore because the tree only contains literals
+	re, err := compileParsed(tree, false)
+	if err != nil {
+		panic(err)
+	}
+	re.expr = expr
+	re.setByPass(compileByPass(tree))
+	return re
 }
 
-// hasOps returns true if the tree contains any of these operations
-func hasOps(trees []*syntax.Regexp, ops []syntax.Op) bool {
-	for _, child := range trees {
-		for _, op := range ops {
-			if child.Op == op {
-				return true
-			}
-		}
-		if hasOps(child.Sub, ops) {
-			return true
+// ByPassError is returned by CompileStrict for a pattern that can't be executed by
+// the bypass matcher. Its fields let tools aggregate the constructs that most often
+// prevent it across many patterns.
+type ByPassError struct {
+	Expr   string    // pattern passed to CompileStrict
+	Op     syntax.Op // operation of the unsupported node, 0 if there is none (too many expansions, unsupported combinations)
+	Node   string    // unsupported node of the simplified pattern (`a+`), or the whole pattern if there is none
+	Offset int       // byte offset of Node in Expr, -1 if it isn't written the same way there (`\d` is printed as `[0-9]`)
+	Reason string    // why the pattern can't be bypassed
+}
+
+func (e *ByPassError) Error() string {
+	return "regexp: " + quote(e.Expr) + " can't be bypassed: " + e.Reason
+}
+
+// CompileStrict is like Compile but returns an error if the pattern can't be
+// executed by the bypass matcher. It lets performance-critical code fail fast
+// instead of silently using the slower matchers. Alternations with some parts
+// executed by the slower matchers (`abc|(a+b+)`) are rejected too. The error is
+// a *ByPassError, unless the pattern can't be parsed.
+func CompileStrict(expr string) (*Regexp, error) {
+	re, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if re.bypass == notByPass || hasByPassResidual(re.bypass) {
+		tree, err := syntax.Parse(expr, syntax.Perl)
+		if err != nil {
+			return nil, err
 		}
+		return nil, newByPassError(expr, tree.Simplify(), byPassMaxExpansions)
 	}
-	return false
+	return re, nil
 }
 
-// compileByPass transforms a tree into a byPassProg if possible
-func compileByPass(tree *syntax.Regexp) byPassProg {
-
-	// In case the first level is an alternate, we compile multiple sub-progs.
-	if tree.Op == syntax.OpAlternate {
-		progalt := &byPassProgAlternate{}
-		for _, alt := range tree.Sub {
-			subprog := compileByPass(alt)
-			if subprog == notByPass {
-				return notByPass
-			}
-			progalt.progs = append(progalt.progs, subprog)
+// CompileMaxExpansions is like Compile, but patterns with `?` and bounded repeats
+// (`colou?r`, `a{1,3}`) can be expanded by the bypass matcher to up to maxExpansions
+// fixed-length alternatives instead of 16. Larger limits trade memory for a broader
+// coverage of the bypass matcher. Patterns with more expansions are executed by the
+// other matchers.
+func CompileMaxExpansions(expr string, maxExpansions int) (*Regexp, error) {
+	if maxExpansions < 1 {
+		return nil, errors.New("regexp: CompileMaxExpansions needs at least one expansion")
+	}
+	re, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if maxExpansions != byPassMaxExpansions {
+		tree, err := syntax.Parse(expr, syntax.Perl)
+		if err != nil {
+			return nil, err
 		}
-		return progalt
+		re.setByPass(compileByPassMaxExpansions(tree.Simplify(), maxExpansions))
 	}
+	return re, nil
+}
 
-	// Try to compile the regexp as a single fixed-length pattern
-	// we don't know yet if it will be anchored or not
-	prog := &byPassProgAnchored{}
+// CompileVerified is like Compile, but each MatchString call executed by the bypass
+// matcher is also executed by the other matchers, and panics if their results differ.
+// It is a canary mode to check the bypass matcher against real inputs: MatchString
+// is then slower than with the standard matchers alone.
+func CompileVerified(expr string) (*Regexp, error) {
+	re, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	re.verify = true
+	return re, nil
+}
 
-	bailout := prog.traverseTree(tree)
+// matchStringVerified runs MatchString with both the bypass and the other matchers,
+// and panics if they disagree
+func (re *Regexp) matchStringVerified(s string) bool {
+	matched := re.bypass.MatchString(s)
+	if want := re.doMatch(nil, nil, s); matched != want {
+		panic("regexp: bypass matcher of " + quote(re.expr) + " returned " + strconv.FormatBool(matched) +
+			" on " + strconv.Quote(s) + ", the other matchers returned " + strconv.FormatBool(want))
+	}
+	return matched
+}
 
-	// In some cases we can still extract a fixed-length anchored prefix & suffix to run as first pass
-	if bailout && tree.Op == syntax.OpConcat && len(tree.Sub) > 1 {
+// CanByPass reports whether the bypass matcher would execute expr, as for a
+// Regexp returned by Compile, without building the Regexp nor its program for
+// the other matchers. It lets tools audit large sets of patterns cheaply.
+// It returns an error if expr can't be parsed.
+func CanByPass(expr string) (bool, error) {
+	tree, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return false, err
+	}
+	return compileByPass(tree.Simplify()) != notByPass, nil
+}
 
-		firstpassprog := &byPassProgFirstPass{}
+// hasByPassResidual returns true if some parts of a top-level alternation are executed by the other matchers
+func hasByPassResidual(prog byPassProg) bool {
+	if progalt, ok := prog.(*byPassProgAlternate); ok {
+		for _, subprog := range progalt.progs {
+			if _, ok := subprog.(*byPassProgResidual); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-		compileByPassPartialPrefix(firstpassprog, tree)
-		compileByPassPartialSuffix(firstpassprog, tree)
+// newByPassError returns why the tree of expr can't be compiled to a byPassProg with maxExpansions
+func newByPassError(expr string, tree *syntax.Regexp, maxExpansions int) *ByPassError {
+	err := &ByPassError{Expr: expr, Node: tree.String(), Offset: -1}
+	if hasOps([]*syntax.Regexp{tree}, []syntax.Op{syntax.OpQuest}) && expandQuests(tree, maxExpansions) == nil {
+		err.Reason = "more than " + strconv.Itoa(maxExpansions) + " expansions of `?` and bounded repeats in `" + err.Node + "`"
+		return err
+	}
+	err.Reason = explainByPassBailout(tree)
+	if node := byPassBailoutNode(tree); node != nil {
+		err.Op = node.Op
+		err.Node = node.String()
+		err.Offset = strings.Index(expr, err.Node)
+	}
+	return err
+}
 
-		if firstpassprog.prefixProg != nil || firstpassprog.suffixProg != nil {
-			return firstpassprog
+// byPassBailoutNode returns the deepest node of the tree traverseTree bails out on, or nil
+// if it doesn't bail out on the tree itself
+func byPassBailoutNode(tree *syntax.Regexp) *syntax.Regexp {
+	for _, sub := range tree.Sub {
+		if (&byPassProgAnchored{}).traverseTree(sub) {
+			return byPassBailoutNode(sub)
 		}
-
 	}
+	if (&byPassProgAnchored{}).traverseTree(tree) {
+		return tree
+	}
+	return nil
+}
 
-	// None of the optimizations are available, bailout to the other matchers.
-	if bailout {
-		return notByPass
+// explainByPassBailout returns why traverseTree bails out on the tree, using the deepest unsupported node
+func explainByPassBailout(tree *syntax.Regexp) string {
+	node := byPassBailoutNode(tree)
+	if node == nil {
+		return "unsupported combination in `" + tree.String() + "`"
+	}
+	flags := node.Flags &^ (syntax.NonGreedy | syntax.DotNL | syntax.FoldCase)
+	if node.Op == syntax.OpEndText {
+		flags &^= syntax.WasDollar
+	}
+	if flags != syntax.Perl && flags != syntax.POSIX {
+		return "unsupported flags in `" + node.String() + "`"
 	}
+	return "unsupported " + node.Op.String() + " in `" + node.String() + "`"
+}
 
-	if prog.unmatchable {
-		return &byPassProgUnmatchable{}
+// setByPass sets the bypass program of re and the fields derived from it
+func (re *Regexp) setByPass(prog byPassProg) {
+	re.bypass = prog
+	re.bypassIndex = nil
+	if !re.longest && isByPassIndexable(prog) {
+		re.bypassIndex = prog.(byPassIndexProg)
+	}
+	re.bypassLastIndex = nil
+	if !re.longest && isByPassLastIndexable(prog) {
+		re.bypassLastIndex = prog.(byPassLastIndexProg)
+	}
+	re.bypassSubmatch = nil
+	if sp, ok := prog.(byPassSubmatchProg); ok && sp.NumSubexp() == re.numSubexp {
+		re.bypassSubmatch = sp
+	}
+	if byPassStatsEnabled {
+		re.stats = &byPassStats{}
+		setByPassStats(prog, re.stats)
+	}
+	re.minLength = 0
+	for i, length := range byPassFixedLengths(prog) {
+		if i == 0 || length < re.minLength {
+			re.minLength = length
+		}
 	}
+}
 
-	prog.computeWidth()
+// maxFindAllCapacity bounds the capacity preallocated by the 'All' routines: inputs
+// with only a few matches shouldn't pay for a large slice, and append grows it otherwise
+const maxFindAllCapacity = 64
 
-	if prog.anchoredBegin || prog.anchoredEnd {
-		return prog
+// findAllCapacity estimates how many matches the 'All' routines can find in size bytes, up to n.
+// Matches of a fixed-length bypass prog span at least minLength bytes, otherwise startSize is used.
+func (re *Regexp) findAllCapacity(size int, n int) int {
+	if re.minLength == 0 {
+		return startSize
 	}
-
-	// Use an unanchored prog
-	return &byPassProgUnanchored{
-		steps:    prog.steps,
-		length:   prog.length,
-		minWidth: prog.minWidth,
-		maxWidth: prog.maxWidth,
+	capacity := size / re.minLength
+	if n >= 0 && capacity > n {
+		capacity = n
+	}
+	if capacity > maxFindAllCapacity {
+		capacity = maxFindAllCapacity
 	}
+	return capacity
 }
 
-// compileByPassPartialPrefix finds out if a fixed-length prefix can be extracted from the tree
-func compileByPassPartialPrefix(firstpassprog *byPassProgFirstPass, tree *syntax.Regexp) {
+// FixedLengths returns the sorted set of lengths, in runes, that a match of re can have.
+// It returns nil if re isn't a fixed-length pattern handled by the bypass matcher.
+func (re *Regexp) FixedLengths() []int {
+	lengths := byPassFixedLengths(re.bypass)
+	if len(lengths) == 0 {
+		return nil
+	}
+	sort.Ints(lengths)
+	unique := lengths[:1]
+	for _, length := range lengths[1:] {
+		if length != unique[len(unique)-1] {
+			unique = append(unique, length)
+		}
+	}
+	return unique
+}
 
-	if tree.Sub[0].Op == syntax.OpBeginText {
+// RequiredLiterals returns the literal substrings that any match of re contains, like
+// "x" and "xy" for `x.xy$`. Texts that don't contain one of them can be rejected with
+// strings.Contains before running the matcher, e.g. to prefilter an inverted index.
+// It returns nil if there is none, or if re isn't handled by the bypass matcher.
+func (re *Regexp) RequiredLiterals() []string {
+	return byPassRequiredLiterals(re.bypass)
+}
 
-		prefixProg := &byPassProgAnchored{}
-		i := 0
-		validsteps := 0
+// FindAllStringIndexOverlapping is like FindAllStringIndex but also returns the
+// overlapping matches: after each match, the search restarts one rune after
+// the beginning of the match instead of at its end.
+// It returns nil if re isn't a non-empty fixed-length pattern handled by the
+// bypass matcher.
+func (re *Regexp) FindAllStringIndexOverlapping(s string) [][]int {
+	lengths := byPassFixedLengths(re.bypass)
+	if re.bypassIndex == nil || lengths == nil {
+		return nil
+	}
+	for _, length := range lengths {
+		if length == 0 {
+			return nil
+		}
+	}
 
-		// Find the longest fixed-length prefix supported by a byPassProgAnchored
-		for ; i < len(tree.Sub); i++ {
-			if prefixProg.traverseTree(tree.Sub[i]) {
-				break
-			}
-			validsteps = len(prefixProg.steps)
+	var result [][]int
+	cursor := newByPassCursor(re.bypassIndex)
+	for pos := 0; pos < len(s); {
+		matchBegin, matchEnd := cursor.IndexString(s, pos)
+		if matchBegin == -1 {
+			break
 		}
+		result = append(result, []int{matchBegin, matchEnd})
+		_, width := utf8.DecodeRuneInString(s[matchBegin:])
+		pos = matchBegin + width
+	}
+	return result
+}
 
-		if validsteps > 0 && i > 1 {
-			if !hasOps(tree.Sub[i:], []syntax.Op{syntax.OpBeginText, syntax.OpBeginLine, syntax.OpWordBoundary, syntax.OpNoWordBoundary}) {
+// MatchStringDetail reports whether s contains any match of re and, for its leftmost
+// match, whether it begins at the start of s and ends at the end of s. It helps to
+// debug routing decisions, e.g. `abc` matching "abcd" at its start only.
+func (re *Regexp) MatchStringDetail(s string) (matched bool, atStart bool, atEnd bool) {
+	loc := re.FindStringIndex(s)
+	if loc == nil {
+		return false, false, false
+	}
+	return true, loc[0] == 0, loc[1] == len(s)
+}
 
-				prefixProg.steps = prefixProg.steps[:validsteps]
-				prefixProg.computeWidth()
-				firstpassprog.prefixProg = prefixProg
+// FindLastStringIndex returns a two-element slice of integers defining the location
+// of the rightmost match of the regular expression in s, the one beginning at the
+// largest offset, like the last `.` in "a.b.c". Literals and fixed-length patterns
+// are searched backwards from the end of s by the bypass matcher. Other patterns are
+// matched once as `(?s:.*)(expr)`, whose greedy prefix ends where the last match begins.
+// A return value of nil indicates no match.
+func (re *Regexp) FindLastStringIndex(s string) (loc []int) {
+
+	if re.bypassLastIndex != nil {
+		matchBegin, matchEnd := re.bypassLastIndex.LastIndexString(s)
+		if matchBegin == -1 {
+			return nil
+		}
+		return []int{matchBegin, matchEnd}
+	}
 
-				// Build and compile a new Regexp for the rest of the pattern (`^aa(c*)` => `^(c*)`)
-				tree.Sub = append(tree.Sub[0:1], tree.Sub[i:]...)
-				// TODO longest?
-				// Error is safe to ignore because it was already compiled earlier
-				re, err := compileParsed(tree, false)
-				if err != nil {
-					panic(err)
-				}
-				firstpassprog.regexp = re
+	last := re.lastStart()
+	a := last.doExecute(nil, nil, s, 0, 2*(last.numSubexp+1), nil)
+	if a == nil {
+		return nil
+	}
+	loc = a[2*last.numSubexp:]
+	if re.longest {
+		// The submatch is leftmost-first, the longest match beginning there is matched again
+		return re.doExecute(nil, nil, s, loc[0], 2, nil)
+	}
+	return loc
+}
 
+// lastStart returns the Regexp matching `(?s:.*)(expr)` used by FindLastStringIndex, compiled
+// on first use. Its last capturing group is the match of re beginning at the largest offset.
+func (re *Regexp) lastStart() *Regexp {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	if re.last == nil {
+		// Error is safe to ignore because expr was already compiled with these flags
+		tree, _ := syntax.Parse(re.expr, re.mode)
+		tree = &syntax.Regexp{Op: syntax.OpConcat, Flags: syntax.Perl, Sub: []*syntax.Regexp{
+			{Op: syntax.OpStar, Flags: syntax.Perl, Sub: []*syntax.Regexp{{Op: syntax.OpAnyChar, Flags: syntax.Perl}}},
+			{Op: syntax.OpCapture, Flags: syntax.Perl, Cap: tree.MaxCap() + 1, Sub: []*syntax.Regexp{tree}},
+		}}
+		re.last, _ = compileParsed(tree, false)
+	}
+	return re.last
+}
+
+// byPassFixedLengths returns the lengths of the matches of prog, in runes, or nil if they are variable
+func byPassFixedLengths(prog byPassProg) (lengths []int) {
+	switch p := prog.(type) {
+	case *byPassProgAnchored:
+		return []int{p.length}
+	case *byPassProgUnanchored:
+		return []int{p.length}
+	case *byPassProgLiteral:
+		return []int{len(p.runes)}
+	case *byPassProgEmpty:
+		return []int{0}
+	case *byPassProgLengthRange:
+		for length := p.minLength; length <= p.maxLength; length++ {
+			lengths = append(lengths, length)
+		}
+		return lengths
+	case *byPassProgAlternate:
+		for _, subprog := range p.progs {
+			if _, ok := subprog.(*byPassProgUnmatchable); ok {
+				continue
 			}
+			sublengths := byPassFixedLengths(subprog)
+			if sublengths == nil {
+				return nil
+			}
+			lengths = append(lengths, sublengths...)
+		}
+		return lengths
+	case *byPassProgPrefixAlternate:
+		for _, subprog := range p.progs {
+			lengths = append(lengths, p.prefixProg.length+subprog.length)
 		}
+		return lengths
+	case *byPassProgPrefixTrie:
+		// The lengths are sorted by FixedLengths
+		return append(lengths, p.lengths...)
+	case *byPassProgValidUTF8:
+		return byPassFixedLengths(p.prog)
 	}
+	return nil
 }
 
-// compileByPassPartialPrefix finds out if a fixed-length suffix can be extracted from the tree
-func compileByPassPartialSuffix(firstpassprog *byPassProgFirstPass, tree *syntax.Regexp) {
+// byPassRequiredLiterals returns the literals of the byPassOpLiteral steps of prog, which all its matches contain
+func byPassRequiredLiterals(prog byPassProg) (literals []string) {
+	switch p := prog.(type) {
+	case *byPassProgAnchored:
+		if !p.unmatchable {
+			literals = appendStepLiterals(literals, p.steps)
+		}
+	case *byPassProgUnanchored:
+		literals = appendStepLiterals(literals, p.steps)
+	case *byPassProgLiteral:
+		if p.literal != "" {
+			literals = append(literals, p.literal)
+		}
+	case *byPassProgPrefixAlternate:
+		literals = appendStepLiterals(literals, p.prefixProg.steps)
+	case *byPassProgFirstPass:
+		if p.prefixProg != nil {
+			literals = appendStepLiterals(literals, p.prefixProg.steps)
+		}
+		if p.suffixProg != nil {
+			literals = appendStepLiterals(literals, p.suffixProg.steps)
+		}
+	case *byPassProgPlus:
+		if p.prefixProg != nil {
+			literals = appendStepLiterals(literals, p.prefixProg.steps)
+		}
+		if !p.star {
+			literals = appendStepLiterals(literals, []*byPassStep{p.step})
+		}
+		if p.suffixProg != nil {
+			literals = appendStepLiterals(literals, p.suffixProg.steps)
+		}
+	case *byPassProgDotStarSuffix:
+		literals = appendStepLiterals(literals, p.suffixProg.steps)
+	case *byPassProgPrefixDotStar:
+		literals = appendStepLiterals(literals, p.prefixProg.steps)
+		if restProg, ok := p.restProg.(byPassProg); ok {
+			literals = append(literals, byPassRequiredLiterals(restProg)...)
+		}
+	case *byPassProgContains:
+		if coreProg, ok := p.coreProg.(byPassProg); ok {
+			literals = byPassRequiredLiterals(coreProg)
+		}
+	case *byPassProgValidUTF8:
+		literals = byPassRequiredLiterals(p.prog)
+	}
+	return literals
+}
 
-	if len(tree.Sub) > 1 && tree.Sub[len(tree.Sub)-1].Op == syntax.OpEndText {
+// appendStepLiterals appends the literals of the byPassOpLiteral steps to literals
+func appendStepLiterals(literals []string, steps []*byPassStep) []string {
+	for _, step := range steps {
+		if step.op == byPassOpLiteral && step.literal != "" {
+			literals = append(literals, step.literal)
+		}
+	}
+	return literals
+}
 
-		suffixProg := &byPassProgAnchored{}
-		lastInvalid := 0
+// MaxMatchLen returns the maximum number of bytes a match of re can span, and whether
+// it is bounded. For unanchored patterns, it is the width of a match, not of the whole
+// string. It returns (-1, false) for patterns with unbounded repetitions (`^abc.*`), and
+// for all the patterns not executed by the bypass matcher.
+func (re *Regexp) MaxMatchLen() (n int, bounded bool) {
+	n = byPassMaxWidth(re.bypass)
+	return n, n != -1
+}
 
-		// Find the longest fixed-length suffix supported by a byPassProgAnchored
-		for i := 0; i < len(tree.Sub); i++ {
-			// Reset the prog until we have a full suffix
-			if suffixProg.traverseTree(tree.Sub[i]) {
-				suffixProg = &byPassProgAnchored{}
-				lastInvalid = i
+// byPassMaxWidth returns the maximum number of bytes in the matches of prog, or -1 if it is unbounded
+func byPassMaxWidth(prog byPassProg) (width int) {
+	switch p := prog.(type) {
+	case *byPassProgAnchored:
+		return stepsMaxWidth(p.steps)
+	case *byPassProgUnanchored:
+		return stepsMaxWidth(p.steps)
+	case *byPassProgLiteral:
+		return len(p.literal)
+	case *byPassProgLengthRange:
+		return p.maxLength * utf8.UTFMax
+	case *byPassProgBytes:
+		return len(p.sets)
+	case *byPassProgSuffixes:
+		return p.maxWidth
+	case *byPassProgPrefixTrie:
+		return p.maxWidth
+	case *byPassProgUnmatchable, *byPassProgEmpty:
+		return 0
+	case *byPassProgAlternate:
+		for _, subprog := range p.progs {
+			subwidth := byPassMaxWidth(subprog)
+			if subwidth == -1 {
+				return -1
+			}
+			if subwidth > width {
+				width = subwidth
 			}
 		}
-
-		if len(suffixProg.steps) > 0 && lastInvalid < len(tree.Sub)-1 {
-			if !hasOps(tree.Sub[:lastInvalid+1], []syntax.Op{syntax.OpEndText, syntax.OpEndLine, syntax.OpWordBoundary, syntax.OpNoWordBoundary}) {
-
-				suffixProg.computeWidth()
-				firstpassprog.suffixProg = suffixProg
-
-				// Build and compile a new Regexp for the rest of the pattern (`(c*)bb$` => `(c*)$`)
-				tree.Sub = append(tree.Sub[:lastInvalid+1], tree.Sub[len(tree.Sub)-1])
-				// TODO longest?
-				// Error is safe to ignore because it was already compiled earlier
-				re, err := compileParsed(tree, false)
-				if err != nil {
-					panic(err)
-				}
-				firstpassprog.regexp = re
+		return width
+	case *byPassProgPrefixAlternate:
+		for _, subprog := range p.progs {
+			if subwidth := stepsMaxWidth(subprog.steps); subwidth > width {
+				width = subwidth
 			}
 		}
+		return stepsMaxWidth(p.prefixProg.steps) + width
+	case *byPassProgValidUTF8:
+		return byPassMaxWidth(p.prog)
 	}
+	return -1
 }
 
-// compileParsed is a shorter version of compile() that takes a parsed tree as input
-// TODO: factorize this with the regular compile() function
-func compileParsed(re *syntax.Regexp, longest bool) (*Regexp, error) {
-
-	maxCap := re.MaxCap()
-	capNames := re.CapNames()
+// stepsMaxWidth returns the maximum number of bytes matched by the steps, using utf8.UTFMax for each rune of unknown width
+func stepsMaxWidth(steps []*byPassStep) (width int) {
+	for _, step := range steps {
+		if step.maxWidth == -1 {
+			width += step.length * utf8.UTFMax
+		} else {
+			width += step.maxWidth
+		}
+	}
+	return width
+}
+
+// Stats returns a snapshot of the match statistics counters of re.
+// All counters are zero unless the package is built with `-tags bypassstats`.
+func (re *Regexp) Stats() ByPassStats {
+	if re.stats == nil {
+		return ByPassStats{}
+	}
+	return ByPassStats{
+		ByPass:          atomic.LoadUint64(&re.stats.byPass),
+		Fallback:        atomic.LoadUint64(&re.stats.fallback),
+		EarlyRejections: atomic.LoadUint64(&re.stats.earlyRejections),
+	}
+}
+
+// setByPassStats shares the stats storage with all the sub-progs able to reject early
+func setByPassStats(prog byPassProg, stats *byPassStats) {
+	switch p := prog.(type) {
+	case *byPassProgAnchored:
+		p.stats = stats
+	case *byPassProgUnanchored:
+		p.stats = stats
+	case *byPassProgLiteral:
+		p.stats = stats
+	case *byPassProgLengthRange:
+		p.stats = stats
+	case *byPassProgAlternate:
+		for _, subprog := range p.progs {
+			setByPassStats(subprog, stats)
+		}
+	case *byPassProgPrefixAlternate:
+		p.prefixProg.stats = stats
+		for _, subprog := range p.progs {
+			subprog.stats = stats
+		}
+	case *byPassProgFirstPass:
+		if p.prefixProg != nil {
+			p.prefixProg.stats = stats
+		}
+		if p.suffixProg != nil {
+			p.suffixProg.stats = stats
+		}
+	case *byPassProgPlus:
+		if p.find != nil {
+			p.find.stats = stats
+		}
+		if p.prefixProg != nil {
+			p.prefixProg.stats = stats
+		}
+		if p.suffixProg != nil {
+			p.suffixProg.stats = stats
+		}
+	case *byPassProgDotStarSuffix:
+		p.suffixProg.stats = stats
+	case *byPassProgPrefixDotStar:
+		p.prefixProg.stats = stats
+		if restProg, ok := p.restProg.(byPassProg); ok {
+			setByPassStats(restProg, stats)
+		}
+	case *byPassProgContains:
+		if coreProg, ok := p.coreProg.(byPassProg); ok {
+			setByPassStats(coreProg, stats)
+		}
+	case *byPassProgValidUTF8:
+		setByPassStats(p.prog, stats)
+	}
+}
+
+// isByPassIndexable returns true if prog and all its sub-progs implement byPassIndexProg
+func isByPassIndexable(prog byPassProg) bool {
+	if progalt, ok := prog.(*byPassProgAlternate); ok {
+		for _, subprog := range progalt.progs {
+			if !isByPassIndexable(subprog) {
+				return false
+			}
+		}
+		return true
+	}
+	_, ok := prog.(byPassIndexProg)
+	return ok
+}
+
+// isByPassLastIndexable returns true if the prog, and all the parts of an alternation,
+// implement byPassLastIndexProg
+func isByPassLastIndexable(prog byPassProg) bool {
+	if progalt, ok := prog.(*byPassProgAlternate); ok && progalt.byWidth == nil {
+		for _, subprog := range progalt.progs {
+			if !isByPassLastIndexable(subprog) {
+				return false
+			}
+		}
+		return true
+	}
+	_, ok := prog.(byPassLastIndexProg)
+	return ok
+}
+
+// doExecuteByPass is like doExecute but locates the match with the bypass matcher.
+// Only the location of the whole match is returned.
+func (re *Regexp) doExecuteByPass(b []byte, s string, pos int, dstCap []int) []int {
+	if b != nil {
+		s = bytesToString(b)
+	}
+	matchBegin, matchEnd := re.bypassIndex.IndexString(s, pos)
+	if matchBegin == -1 {
+		return nil
+	}
+	return append(dstCap, matchBegin, matchEnd)
+}
+
+// byPassCursor locates the successive matches of a loop (ReplaceAll, FindAll) at increasing
+// positions in the same string. The leftmost match of each part of an alternation
+// (`[0-9]{3}|[0-9]{2}`) stays its leftmost match until the position passes its beginning, so
+// it is remembered instead of searching the whole rest of the string again for each part.
+type byPassCursor struct {
+	prog       byPassIndexProg
+	parts      []byPassCursor // one cursor for each part of a byPassProgAlternate, nil otherwise
+	searched   bool           // false until the first search
+	begin, end int            // last match found, -1 if none
+}
+
+func newByPassCursor(prog byPassIndexProg) byPassCursor {
+	cursor := byPassCursor{prog: prog}
+	if progalt, ok := prog.(*byPassProgAlternate); ok && progalt.byWidth == nil {
+		cursor.parts = make([]byPassCursor, len(progalt.progs))
+		for i, subprog := range progalt.progs {
+			cursor.parts[i] = newByPassCursor(subprog.(byPassIndexProg))
+		}
+	}
+	return cursor
+}
+
+// IndexString is like the IndexString of the prog, but pos must not decrease between calls
+func (cursor *byPassCursor) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+	if cursor.searched && (cursor.begin == -1 || cursor.begin >= pos) {
+		return cursor.begin, cursor.end
+	}
+	cursor.searched = true
+	if cursor.parts == nil {
+		cursor.begin, cursor.end = cursor.prog.IndexString(s, pos)
+		return cursor.begin, cursor.end
+	}
+	cursor.begin, cursor.end = -1, -1
+	for i := range cursor.parts {
+		// Leftmost-first: on a tie, the first part of the alternation wins
+		begin, end := cursor.parts[i].IndexString(s, pos)
+		if begin != -1 && (cursor.begin == -1 || begin < cursor.begin) {
+			cursor.begin, cursor.end = begin, end
+		}
+	}
+	return cursor.begin, cursor.end
+}
+
+// bytesToString returns a string sharing the memory of b, to run the bypass matchers on []byte
+// without copying. The string must not be retained after b is modified.
+func bytesToString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// nextRunesWidth returns the number of bytes that encode the next `n` runes
+func nextRunesWidth(s string, n int) (width int) {
+	for i := 0; i < n && width < len(s); i++ {
+		_, w := utf8.DecodeRuneInString(s[width:])
+		width += w
+	}
+	return width
+}
+
+// lastRunesWidth returns the number of bytes that encode the last `n` runes
+func lastRunesWidth(s string, n int) (width int) {
+	for i := 0; i < n; i++ {
+		if width >= len(s) {
+			return -1
+		}
+		_, w := utf8.DecodeLastRuneInString(s[:len(s)-width])
+		width += w
+	}
+	return width
+}
+
+// hasOps returns true if the tree contains any of these operations
+func hasOps(trees []*syntax.Regexp, ops []syntax.Op) bool {
+	for _, child := range trees {
+		for _, op := range ops {
+			if child.Op == op {
+				return true
+			}
+		}
+		if hasOps(child.Sub, ops) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileByPassLines compiles the bypass program used by MatchLines for a multi-line
+// pattern (`(?m)^ERROR`). Each line is matched on its own, so `^` and `$` become
+// anchors at the beginning and end of the text. Returns notByPass if the tree has no
+// multi-line anchors, or if it can't be bypassed.
+func compileByPassLines(tree *syntax.Regexp) byPassProg {
+	if !hasOps([]*syntax.Regexp{tree}, []syntax.Op{syntax.OpBeginLine, syntax.OpEndLine}) {
+		return notByPass
+	}
+	return compileByPass(textAnchors(tree))
+}
+
+// textAnchors returns a copy of tree where the multi-line anchors are replaced by
+// the anchors of the whole text, as if `(?m)` was never set
+func textAnchors(tree *syntax.Regexp) *syntax.Regexp {
+	copied := *tree
+	// Concatenations and alternations have no flags, the other nodes keep the parser's ones
+	if copied.Flags != 0 {
+		copied.Flags |= syntax.OneLine
+	}
+	switch tree.Op {
+	case syntax.OpBeginLine:
+		copied.Op = syntax.OpBeginText
+	case syntax.OpEndLine:
+		copied.Op = syntax.OpEndText
+		copied.Flags |= syntax.WasDollar
+	}
+	if tree.Sub != nil {
+		copied.Sub = make([]*syntax.Regexp, len(tree.Sub))
+		for i, sub := range tree.Sub {
+			copied.Sub[i] = textAnchors(sub)
+		}
+	}
+	return &copied
+}
+
+// compileByPass transforms a tree into a byPassProg if possible
+func compileByPass(tree *syntax.Regexp) byPassProg {
+	return compileByPassMaxExpansions(tree, byPassMaxExpansions)
+}
+
+// compileByPassMaxExpansions is like compileByPass, with a different limit of expansions of `?`
+func compileByPassMaxExpansions(tree *syntax.Regexp, maxExpansions int) byPassProg {
+
+	// In case the first level is an alternate, we compile multiple sub-progs.
+	if tree.Op == syntax.OpAlternate {
+		progalt := &byPassProgAlternate{}
+		bypassed := false
+		for _, alt := range tree.Sub {
+			subprog := compileByPassMaxExpansions(alt, maxExpansions)
+			if subprog == notByPass {
+				// Only this part of the alternation is executed by the other matchers (`(a+b+)` in `abc|(a+b+)`)
+				// Error is safe to ignore because it was already compiled earlier
+				re, err := compileParsed(alt, false)
+				if err != nil {
+					panic(err)
+				}
+				subprog = &byPassProgResidual{regexp: re}
+			} else {
+				bypassed = true
+			}
+			progalt.progs = append(progalt.progs, subprog)
+		}
+		if !bypassed {
+			return notByPass
+		}
+		if trieprog := compileByPassAlternateTrie(progalt); trieprog != nil {
+			return trieprog
+		}
+		if len(progalt.progs) >= byPassMinWidthIndex {
+			progalt.indexByWidth()
+		}
+		return progalt
+	}
+
+	// Try to compile the regexp as a single fixed-length pattern
+	// we don't know yet if it will be anchored or not
+	prog := &byPassProgAnchored{}
+
+	bailout := prog.traverseTree(tree)
+
+	// Patterns like `colou?r` can be expanded to an alternation of fixed-length patterns (`colour|color`)
+	if bailout && hasOps([]*syntax.Regexp{tree}, []syntax.Op{syntax.OpQuest}) {
+		if expandedprog := compileByPassExpanded(tree, maxExpansions); expandedprog != notByPass {
+			return expandedprog
+		}
+	}
+
+	// A literal followed by `\b` only needs to check the next byte (`foo\b`)
+	if bailout && tree.Op == syntax.OpConcat && len(tree.Sub) > 1 {
+		if boundaryprog := compileByPassWordBoundary(tree, syntax.IsWordChar); boundaryprog != notByPass {
+			return boundaryprog
+		}
+	}
+
+	// A leading `.*` followed by a fixed-length suffix is only a suffix check (`.*foo$`)
+	if bailout && tree.Op == syntax.OpConcat && len(tree.Sub) > 1 {
+		if dotstarprog := compileByPassDotStarSuffix(tree); dotstarprog != notByPass {
+			return dotstarprog
+		}
+	}
+
+	// `^abc.*xyz` is a prefix check followed by a search for the rest of the pattern
+	if bailout && tree.Op == syntax.OpConcat && len(tree.Sub) > 1 {
+		if prefixdotstarprog := compileByPassPrefixDotStar(tree); prefixdotstarprog != notByPass {
+			return prefixdotstarprog
+		}
+	}
+
+	// A fixed-length pattern between two `.*` only needs to be found anywhere in the string (`.*foo.*`)
+	if bailout && tree.Op == syntax.OpConcat && len(tree.Sub) > 2 {
+		if containsprog := compileByPassContains(tree); containsprog != notByPass {
+			return containsprog
+		}
+	}
+
+	// Anchored alternations of literals are walked at once in a trie (`^(?:GET|POST|PUT) `)
+	if bailout && tree.Op == syntax.OpConcat && len(tree.Sub) > 1 {
+		if trieprog := compileByPassPrefixTrie(tree); trieprog != notByPass {
+			return trieprog
+		}
+	}
+
+	// Anchored alternations with a shared prefix only need to match the prefix once (`^abc(?:1|22)$`)
+	if bailout && tree.Op == syntax.OpConcat && len(tree.Sub) > 1 {
+		if prefixaltprog := compileByPassPrefixAlternate(tree); prefixaltprog != notByPass {
+			return prefixaltprog
+		}
+	}
+
+	// In some cases we can still extract a fixed-length anchored prefix & suffix to run as first pass
+	if bailout && tree.Op == syntax.OpConcat && len(tree.Sub) > 1 {
+
+		firstpassprog := &byPassProgFirstPass{}
+
+		compileByPassPartialPrefix(firstpassprog, tree)
+		compileByPassPartialSuffix(firstpassprog, tree)
+
+		// Inner anchors can make the prefix or suffix unmatchable (`^ab^c*`, `c*a$b$`), and the whole pattern with them
+		if firstpassprog.prefixProg != nil && firstpassprog.prefixProg.unmatchable ||
+			firstpassprog.suffixProg != nil && firstpassprog.suffixProg.unmatchable {
+			return &byPassProgUnmatchable{}
+		}
+
+		// The rest of the pattern may still be simple enough to avoid the other matchers (`^([^/]+)$`)
+		if plusprog := compileByPassPlus(tree); plusprog != nil {
+			plusprog.prefixProg = firstpassprog.prefixProg
+			plusprog.suffixProg = firstpassprog.suffixProg
+			return plusprog
+		}
+
+		if firstpassprog.prefixProg != nil || firstpassprog.suffixProg != nil {
+			compileByPassDotStarRest(firstpassprog, tree)
+			return firstpassprog
+		}
+
+	}
+
+	// An unanchored `class+` matches as soon as a single rune of the class is found (`[^\n]+`)
+	if bailout {
+		if plusprog := compileByPassUnanchoredPlus(tree); plusprog != nil {
+			return plusprog
+		}
+	}
+
+	// None of the optimizations are available, bailout to the other matchers.
+	if bailout {
+		return notByPass
+	}
+
+	return compileByPassFixed(prog)
+}
+
+// compileByPassFixed returns the matcher for a fixed-length pattern traversed into prog
+func compileByPassFixed(prog *byPassProgAnchored) byPassProg {
+
+	if prog.unmatchable {
+		return &byPassProgUnmatchable{}
+	}
+
+	// Empty patterns only need to check the anchors (``, `^$`)
+	if len(prog.steps) == 0 {
+		return &byPassProgEmpty{anchoredBegin: prog.anchoredBegin, anchoredEnd: prog.anchoredEnd}
+	}
+
+	if lengthprog := compileByPassLengthRange([]*byPassProgAnchored{prog}); lengthprog != nil {
+		return lengthprog
+	}
+
+	prog.computeWidth()
+
+	// Literal-only patterns can be matched on bytes directly
+	if len(prog.steps) == 1 && prog.steps[0].op == byPassOpLiteral {
+		return &byPassProgLiteral{
+			literal:       prog.steps[0].literal,
+			runes:         []rune(prog.steps[0].literal),
+			anchoredBegin: prog.anchoredBegin,
+			anchoredEnd:   prog.anchoredEnd,
+		}
+	}
+
+	if prog.anchoredBegin || prog.anchoredEnd {
+		prog.asciiBytes = asciiBytesOfSteps(prog.steps)
+		return prog
+	}
+
+	// Use an unanchored prog
+	return &byPassProgUnanchored{
+		steps:    prog.steps,
+		length:   prog.length,
+		minWidth: prog.minWidth,
+		maxWidth: prog.maxWidth,
+	}
+}
+
+// asciiBytesOfSteps builds the bitmap of the bytes allowed at each position of steps,
+// or returns nil if some of them can match something else than a single ASCII byte
+func asciiBytesOfSteps(steps []*byPassStep) [][2]uint64 {
+	var sets [][2]uint64
+	for _, step := range steps {
+		switch {
+		case step.op == byPassOpLiteral:
+			for i := 0; i < len(step.literal); i++ {
+				char := step.literal[i]
+				if char >= utf8.RuneSelf {
+					return nil
+				}
+				var set [2]uint64
+				set[char>>6] |= 1 << uint(char&63)
+				sets = append(sets, set)
+			}
+		case step.op == byPassOpCharClass && step.asciiOnly:
+			for i := 0; i < step.length; i++ {
+				sets = append(sets, step.asciiSet)
+			}
+		default:
+			return nil
+		}
+	}
+	return sets
+}
+
+// compileByPassWordBoundary finds out if the tree is a literal followed by `\b` (`foo\b`),
+// or if a `\b` between two word characters makes it unmatchable (`foo\bbar`)
+func compileByPassWordBoundary(tree *syntax.Regexp, isWordChar func(rune) bool) byPassProg {
+
+	last := len(tree.Sub) - 1
+	for i := 1; i < last; i++ {
+		if tree.Sub[i].Op == syntax.OpWordBoundary && isWordLiteral(tree.Sub[i-1], true, isWordChar) && isWordLiteral(tree.Sub[i+1], false, isWordChar) {
+			return &byPassProgUnmatchable{}
+		}
+	}
+
+	if tree.Sub[last].Op != syntax.OpWordBoundary {
+		return notByPass
+	}
+	prog := &byPassProgAnchored{}
+	for _, sub := range tree.Sub[:last] {
+		if prog.traverseTree(sub) {
+			return notByPass
+		}
+	}
+	if prog.unmatchable || prog.anchoredEnd || len(prog.steps) != 1 || prog.steps[0].op != byPassOpLiteral {
+		return notByPass
+	}
+
+	// After a non-word character, `\b` would need a word character instead
+	literal := prog.steps[0].literal
+	if last, _ := utf8.DecodeLastRuneInString(literal); !isWordChar(last) {
+		return notByPass
+	}
+
+	return &byPassProgLiteral{
+		literal:         literal,
+		runes:           []rune(literal),
+		anchoredBegin:   prog.anchoredBegin,
+		wordBoundaryEnd: true,
+	}
+}
+
+// isWordLiteral returns true if the tree is a case-sensitive literal ending (or starting) with a word character.
+// Case folding is excluded because `(?i)k` also matches the Kelvin sign, which isn't a word character.
+func isWordLiteral(tree *syntax.Regexp, end bool, isWordChar func(rune) bool) bool {
+	for tree.Op == syntax.OpCapture {
+		tree = tree.Sub[0]
+	}
+	if tree.Op != syntax.OpLiteral || tree.Flags&syntax.FoldCase != 0 || len(tree.Rune) == 0 {
+		return false
+	}
+	if end {
+		return isWordChar(tree.Rune[len(tree.Rune)-1])
+	}
+	return isWordChar(tree.Rune[0])
+}
+
+// compileByPassExpanded compiles a pattern with `?` as an alternation of all its fixed-length expansions
+func compileByPassExpanded(tree *syntax.Regexp, maxExpansions int) byPassProg {
+
+	expansions := expandQuests(tree, maxExpansions)
+	if expansions == nil {
+		return notByPass
+	}
+
+	progs := make([]*byPassProgAnchored, 0, len(expansions))
+	for _, expansion := range expansions {
+		prog := &byPassProgAnchored{}
+		for _, sub := range expansion {
+			if prog.traverseTree(sub) {
+				return notByPass
+			}
+		}
+		progs = append(progs, prog)
+	}
+
+	// `^.{3,5}$` is expanded to `^...$|^....$|^.....$`, which only needs to count runes
+	if lengthprog := compileByPassLengthRange(progs); lengthprog != nil {
+		return lengthprog
+	}
+
+	// Expansions anchored on both ends often start with the same steps (`^GE[TX] /a?$`), which are matched once
+	if prefixalt := compileByPassSharedPrefix(progs); prefixalt != nil {
+		return prefixalt
+	}
+
+	progalt := &byPassProgAlternate{}
+	for _, prog := range progs {
+		progalt.progs = append(progalt.progs, compileByPassFixed(prog))
+	}
+	return progalt
+}
+
+// compileByPassSharedPrefix factors the leading steps shared by all the progs into a
+// byPassProgPrefixAlternate. Returns nil if they don't share any step, or if some of them
+// aren't anchored on both ends.
+func compileByPassSharedPrefix(progs []*byPassProgAnchored) *byPassProgPrefixAlternate {
+
+	if len(progs) < 2 {
+		return nil
+	}
+	shared := len(progs[0].steps)
+	for _, prog := range progs {
+		if prog.unmatchable || !prog.anchoredBegin || !prog.anchoredEnd {
+			return nil
+		}
+		n := 0
+		for n < shared && n < len(prog.steps) && sameByPassStep(prog.steps[n], progs[0].steps[n]) {
+			n++
+		}
+		shared = n
+	}
+	if shared == 0 {
+		return nil
+	}
+
+	prefixalt := &byPassProgPrefixAlternate{
+		prefixProg: &byPassProgAnchored{steps: progs[0].steps[:shared:shared], anchoredBegin: true},
+	}
+	prefixalt.prefixProg.computeWidth()
+	prefixalt.prefixProg.asciiBytes = asciiBytesOfSteps(prefixalt.prefixProg.steps)
+
+	// The rest of each prog is anchored right after the prefix. The steps were only compiled for this
+	// expansion, so they can be moved to the new prog.
+	for _, prog := range progs {
+		altProg := &byPassProgAnchored{steps: prog.steps[shared:], anchoredBegin: true, anchoredEnd: true}
+		for _, step := range altProg.steps {
+			step.anchorIndex -= prefixalt.prefixProg.length
+		}
+		altProg.computeWidth()
+		altProg.asciiBytes = asciiBytesOfSteps(altProg.steps)
+		prefixalt.progs = append(prefixalt.progs, altProg)
+	}
+	if len(prefixalt.progs) >= byPassMinWidthIndex {
+		prefixalt.indexByWidth()
+	}
+	return prefixalt
+}
+
+// indexByWidth sets byWidth and anyWidth if all the parts of the alternation are anchored to the end
+func (prog *byPassProgPrefixAlternate) indexByWidth() {
+	byWidth := make(map[int][]*byPassProgAnchored)
+	var anyWidth []*byPassProgAnchored
+	for _, subprog := range prog.progs {
+		if !subprog.anchoredEnd {
+			return
+		}
+		if subprog.minWidth == subprog.maxWidth {
+			byWidth[subprog.maxWidth] = append(byWidth[subprog.maxWidth], subprog)
+		} else {
+			anyWidth = append(anyWidth, subprog)
+		}
+	}
+	prog.byWidth = byWidth
+	prog.anyWidth = anyWidth
+}
+
+// sameByPassStep returns true if both steps match the same runes
+func sameByPassStep(a *byPassStep, b *byPassStep) bool {
+	if a.op != b.op || a.length != b.length || a.literal != b.literal || a.char != b.char ||
+		len(a.classes) != len(b.classes) || len(a.literals) != len(b.literals) {
+		return false
+	}
+	for i := range a.classes {
+		if a.classes[i] != b.classes[i] {
+			return false
+		}
+	}
+	for i := range a.literals {
+		if a.literals[i] != b.literals[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// compileByPassLengthRange returns a byPassProgLengthRange if all the progs are made only of
+// the same kind of `.`, anchored on both ends, and have contiguous lengths. Returns nil otherwise.
+func compileByPassLengthRange(progs []*byPassProgAnchored) *byPassProgLengthRange {
+
+	lengthprog := &byPassProgLengthRange{minLength: -1}
+	lengths := make(map[int]bool, len(progs))
+	anyChar, anyCharNotNL := false, false
+
+	for _, prog := range progs {
+		if prog.unmatchable || !prog.anchoredBegin || !prog.anchoredEnd {
+			return nil
+		}
+		length := 0
+		for _, step := range prog.steps {
+			switch {
+			case step.op == byPassOpAnyChar:
+				anyChar = true
+			case step.op == byPassOpNegativeCharClass && step.classes == nil && step.char == '\n':
+				anyCharNotNL = true
+			default:
+				return nil
+			}
+			length += step.length
+		}
+		lengths[length] = true
+		if lengthprog.minLength == -1 || length < lengthprog.minLength {
+			lengthprog.minLength = length
+		}
+		if length > lengthprog.maxLength {
+			lengthprog.maxLength = length
+		}
+	}
+
+	// Patterns mixing both kinds of `.` (e.g. `^(?s:.).$`) must check each rune
+	if anyChar && anyCharNotNL {
+		return nil
+	}
+	for length := lengthprog.minLength; length <= lengthprog.maxLength; length++ {
+		if !lengths[length] {
+			return nil
+		}
+	}
+	lengthprog.matchNL = anyChar
+	return lengthprog
+}
+
+// expandQuests returns all the sequences of nodes the tree can match, with its `?` either taken or skipped.
+// They are in the order a backtracking matcher would try them, so that the first one matching wins.
+// Returns nil if there are more than maxExpansions.
+func expandQuests(tree *syntax.Regexp, maxExpansions int) (expansions [][]*syntax.Regexp) {
+
+	switch tree.Op {
+	case syntax.OpConcat:
+		expansions = [][]*syntax.Regexp{nil}
+		for _, sub := range tree.Sub {
+			subexpansions := expandQuests(sub, maxExpansions)
+			if subexpansions == nil || len(expansions)*len(subexpansions) > maxExpansions {
+				return nil
+			}
+			product := make([][]*syntax.Regexp, 0, len(expansions)*len(subexpansions))
+			for _, expansion := range expansions {
+				for _, subexpansion := range subexpansions {
+					product = append(product, append(expansion[:len(expansion):len(expansion)], subexpansion...))
+				}
+			}
+			expansions = product
+		}
+		return expansions
+
+	case syntax.OpQuest:
+		subexpansions := expandQuests(tree.Sub[0], maxExpansions)
+		if subexpansions == nil || len(subexpansions)+1 > maxExpansions {
+			return nil
+		}
+		// Greedy `?` tries to match first, non-greedy `??` tries to skip first
+		if tree.Flags&syntax.NonGreedy != 0 {
+			return append([][]*syntax.Regexp{nil}, subexpansions...)
+		}
+		return append(subexpansions, nil)
+	}
+
+	return [][]*syntax.Regexp{{tree}}
+}
+
+// isDotStar returns true if the tree is a `.*`. matchNL is true if the `.` also matches `\n`.
+func isDotStar(tree *syntax.Regexp) (ok bool, matchNL bool) {
+	if tree.Op != syntax.OpStar || len(tree.Sub) != 1 {
+		return false, false
+	}
+	switch tree.Sub[0].Op {
+	case syntax.OpAnyChar:
+		return true, true
+	case syntax.OpAnyCharNotNL:
+		return true, false
+	}
+	return false, false
+}
+
+// repeatedStep returns the step of a single-rune `class+` or `class*`, or of a repeated literal (`(?:ab)*`),
+// or nil if the tree is something else
+func repeatedStep(tree *syntax.Regexp) (step *byPassStep, plus bool) {
+	if tree.Op != syntax.OpPlus && tree.Op != syntax.OpStar {
+		return nil, false
+	}
+	prog := &byPassProgAnchored{}
+	if prog.traverseTree(tree.Sub[0]) || len(prog.steps) != 1 || prog.steps[0].length != 1 && prog.steps[0].op != byPassOpLiteral {
+		return nil, false
+	}
+	return prog.steps[0], tree.Op == syntax.OpPlus
+}
+
+// compileByPassDotStarSuffix finds out if the tree is a `.*` followed by a fixed-length suffix anchored to the end
+func compileByPassDotStarSuffix(tree *syntax.Regexp) byPassProg {
+
+	prog := &byPassProgDotStarSuffix{}
+	i := 0
+
+	if tree.Sub[0].Op == syntax.OpBeginText {
+		prog.anchoredBegin = true
+		i++
+	}
+
+	if i >= len(tree.Sub)-1 {
+		return notByPass
+	}
+
+	ok, matchNL := isDotStar(tree.Sub[i])
+	if !ok {
+		// With `^`, `class*` and `class+` must span the whole beginning of the string: this is a byPassProgPlus
+		step, plus := repeatedStep(tree.Sub[i])
+		if step == nil || step.length != 1 || prog.anchoredBegin {
+			return notByPass
+		}
+		prog.step, prog.plus = step, plus
+	}
+	prog.matchNL = matchNL
+
+	suffixProg := &byPassProgAnchored{}
+	for _, sub := range tree.Sub[i+1:] {
+		if suffixProg.traverseTree(sub) {
+			return notByPass
+		}
+	}
+
+	if suffixProg.unmatchable {
+		return &byPassProgUnmatchable{}
+	}
+
+	if !suffixProg.anchoredEnd || suffixProg.anchoredBegin || len(suffixProg.steps) == 0 {
+		return notByPass
+	}
+
+	suffixProg.computeWidth()
+	prog.suffixProg = suffixProg
+
+	return prog
+}
+
+// compileByPassPrefixDotStar finds out if the tree is an anchored fixed-length prefix, followed by
+// a `.*` and an optional fixed-length pattern (e.g. `^abc.*xyz`)
+func compileByPassPrefixDotStar(tree *syntax.Regexp) byPassProg {
+
+	if tree.Sub[0].Op != syntax.OpBeginText {
+		return notByPass
+	}
+
+	j := 1
+	for ; j < len(tree.Sub); j++ {
+		if ok, _ := isDotStar(tree.Sub[j]); ok {
+			break
+		}
+	}
+	if j == len(tree.Sub) {
+		return notByPass
+	}
+
+	prog := &byPassProgPrefixDotStar{
+		prefixProg: &byPassProgAnchored{},
+	}
+	_, prog.matchNL = isDotStar(tree.Sub[j])
+
+	for _, sub := range tree.Sub[:j] {
+		if prog.prefixProg.traverseTree(sub) {
+			return notByPass
+		}
+	}
+	if prog.prefixProg.unmatchable {
+		return &byPassProgUnmatchable{}
+	}
+	if prog.prefixProg.anchoredEnd || len(prog.prefixProg.steps) == 0 {
+		return notByPass
+	}
+	prog.prefixProg.computeWidth()
+
+	if j == len(tree.Sub)-1 {
+		return prog
+	}
+
+	restProg := &byPassProgAnchored{}
+	for _, sub := range tree.Sub[j+1:] {
+		if restProg.traverseTree(sub) {
+			return notByPass
+		}
+	}
+	if restProg.unmatchable {
+		return &byPassProgUnmatchable{}
+	}
+	if restProg.anchoredBegin {
+		return notByPass
+	}
+	restProg.computeWidth()
+
+	// `^abc.*$` doesn't need a restProg, only a check that the `.*` reaches the end
+	if restProg.anchoredEnd && len(restProg.steps) == 0 {
+		prog.anchoredEnd = true
+		return prog
+	}
+
+	if restProg.anchoredEnd {
+		prog.restProg = restProg
+	} else {
+		prog.restProg = &byPassProgUnanchored{
+			steps:    restProg.steps,
+			length:   restProg.length,
+			minWidth: restProg.minWidth,
+			maxWidth: restProg.maxWidth,
+		}
+	}
+
+	return prog
+}
+
+// compileByPassContains finds out if the tree is a fixed-length unanchored pattern between two greedy `.*`
+// with the same newline semantics (e.g. `.*foo.*`)
+func compileByPassContains(tree *syntax.Regexp) byPassProg {
+
+	last := len(tree.Sub) - 1
+	okBegin, matchNL := isDotStar(tree.Sub[0])
+	okEnd, endMatchNL := isDotStar(tree.Sub[last])
+	if !okBegin || !okEnd || matchNL != endMatchNL ||
+		tree.Sub[0].Flags&syntax.NonGreedy != 0 || tree.Sub[last].Flags&syntax.NonGreedy != 0 {
+		return notByPass
+	}
+
+	coreProg := &byPassProgAnchored{}
+	for _, sub := range tree.Sub[1:last] {
+		if coreProg.traverseTree(sub) {
+			return notByPass
+		}
+	}
+	if coreProg.unmatchable {
+		return &byPassProgUnmatchable{}
+	}
+	if coreProg.anchoredBegin || coreProg.anchoredEnd || len(coreProg.steps) == 0 {
+		return notByPass
+	}
+
+	prog := &byPassProgContains{matchNL: matchNL}
+	prog.coreProg = compileByPassFixed(coreProg).(byPassIndexProg)
+	return prog
+}
+
+// compileByPassPrefixAlternate finds out if the tree is an anchored fixed-length prefix followed by
+// an alternation of fixed-length parts (e.g. `^abc(?:1|22|333)$`)
+func compileByPassPrefixAlternate(tree *syntax.Regexp) byPassProg {
+
+	if tree.Sub[0].Op != syntax.OpBeginText {
+		return notByPass
+	}
+
+	j := 1
+	for ; j < len(tree.Sub); j++ {
+		if tree.Sub[j].Op == syntax.OpAlternate {
+			break
+		}
+	}
+	if j == len(tree.Sub) || hasOps(tree.Sub[j:], []syntax.Op{syntax.OpBeginText}) {
+		return notByPass
+	}
+
+	prog := &byPassProgPrefixAlternate{
+		prefixProg: &byPassProgAnchored{},
+	}
+	for _, sub := range tree.Sub[:j] {
+		if prog.prefixProg.traverseTree(sub) {
+			return notByPass
+		}
+	}
+	if prog.prefixProg.unmatchable {
+		return &byPassProgUnmatchable{}
+	}
+	if prog.prefixProg.anchoredEnd {
+		return notByPass
+	}
+	prog.prefixProg.computeWidth()
+
+	// Each part of the alternation is followed by the rest of the pattern (`1$`, `22$`, `333$`)
+	for _, alt := range tree.Sub[j].Sub {
+		altProg := &byPassProgAnchored{anchoredBegin: true}
+		if altProg.traverseTree(alt) {
+			return notByPass
+		}
+		for _, sub := range tree.Sub[j+1:] {
+			if altProg.traverseTree(sub) {
+				return notByPass
+			}
+		}
+		if altProg.unmatchable {
+			continue
+		}
+		altProg.computeWidth()
+		prog.progs = append(prog.progs, altProg)
+	}
+
+	if len(prog.progs) == 0 {
+		return &byPassProgUnmatchable{}
+	}
+	if len(prog.progs) >= byPassMinWidthIndex {
+		prog.indexByWidth()
+	}
+
+	return prog
+}
+
+// compileByPassPlus finds out if the tree is a `class+` or `class*` anchored on both ends (e.g. `^([^/]+)$`, `^(?:ab)*$`)
+func compileByPassPlus(tree *syntax.Regexp) *byPassProgPlus {
+
+	if tree.Op != syntax.OpConcat || tree.Sub[0].Op != syntax.OpBeginText {
+		return nil
+	}
+	if len(tree.Sub) == 2 {
+		return compileByPassOpenPlus(tree.Sub[1])
+	}
+	if len(tree.Sub) != 3 || tree.Sub[2].Op != syntax.OpEndText {
+		return nil
+	}
+
+	plusprog := &byPassProgPlus{}
+
+	plus := tree.Sub[1]
+	if plus.Op == syntax.OpCapture {
+		plusprog.capture = plus.Cap
+		plus = plus.Sub[0]
+	}
+	step, isPlus := repeatedStep(plus)
+	if step == nil {
+		// Bounded repeats were simplified by the parser (`[0-9]{2,5}` => `[0-9][0-9](?:[0-9](?:[0-9][0-9]?)?)?`)
+		step, plusprog.minCount, plusprog.maxCount = boundedRepeatedStep(plus)
+		if step == nil {
+			return nil
+		}
+		isPlus = plusprog.minCount > 0
+	}
+	plusprog.step = step
+	plusprog.star = !isPlus
+
+	return plusprog
+}
+
+// compileByPassOpenPlus finds out if the tree, right after the `^` or the prefix, is a greedy
+// single-rune `class+` or `class*` that isn't followed by anything (`^[A-Z]([a-z]+)`)
+func compileByPassOpenPlus(tree *syntax.Regexp) *byPassProgPlus {
+
+	plusprog := &byPassProgPlus{openEnd: true}
+
+	if tree.Op == syntax.OpCapture {
+		plusprog.capture = tree.Cap
+		tree = tree.Sub[0]
+	}
+	// A non-greedy `class+?` would stop after a single rune
+	if tree.Flags&syntax.NonGreedy != 0 {
+		return nil
+	}
+	step, isPlus := repeatedStep(tree)
+	if step == nil || step.length != 1 {
+		return nil
+	}
+	plusprog.step = step
+	plusprog.star = !isPlus
+
+	return plusprog
+}
+
+// boundedRepeatedStep finds out if the tree is a single-rune class repeated between min and max
+// times, as simplified by the parser (`[0-9]{2,}` => `[0-9][0-9]+`, `[0-9]{1,3}` => `[0-9](?:[0-9][0-9]?)?`).
+// max is 0 if the repeat is unbounded.
+func boundedRepeatedStep(tree *syntax.Regexp) (step *byPassStep, min int, max int) {
+	subs := []*syntax.Regexp{tree}
+	if tree.Op == syntax.OpConcat {
+		subs = tree.Sub
+	}
+	last := subs[len(subs)-1]
+
+	// The step is taken from the first rune of the repeat: `[0-9]` in `[0-9](?:...)?` or `(?:[0-9](?:...)?)?`
+	first := subs[0]
+	if len(subs) == 1 && first.Op == syntax.OpQuest {
+		first = first.Sub[0]
+		if first.Op == syntax.OpConcat {
+			first = first.Sub[0]
+		}
+	}
+	if step = singleRuneStep(first); step == nil {
+		return nil, 0, 0
+	}
+
+	for _, sub := range subs[:len(subs)-1] {
+		if other := singleRuneStep(sub); other == nil || !sameByPassStep(step, other) {
+			return nil, 0, 0
+		}
+		min++
+	}
+
+	switch last.Op {
+	case syntax.OpPlus, syntax.OpStar:
+		other, isPlus := repeatedStep(last)
+		if other == nil || !sameByPassStep(step, other) {
+			return nil, 0, 0
+		}
+		if isPlus {
+			min++
+		}
+		return step, min, 0
+	case syntax.OpQuest:
+		depth := questDepth(last, step)
+		if depth == 0 {
+			return nil, 0, 0
+		}
+		return step, min, min + depth
+	}
+	return nil, 0, 0
+}
+
+// questDepth returns the number of times step is optionally repeated by nested quests
+// (`(?:[0-9](?:[0-9][0-9]?)?)?` is 3), or 0 if the tree is something else
+func questDepth(tree *syntax.Regexp, step *byPassStep) int {
+	if tree.Op != syntax.OpQuest {
+		return 0
+	}
+	sub := tree.Sub[0]
+	if other := singleRuneStep(sub); other != nil {
+		if !sameByPassStep(step, other) {
+			return 0
+		}
+		return 1
+	}
+	if sub.Op != syntax.OpConcat || len(sub.Sub) != 2 {
+		return 0
+	}
+	if other := singleRuneStep(sub.Sub[0]); other == nil || !sameByPassStep(step, other) {
+		return 0
+	}
+	if depth := questDepth(sub.Sub[1], step); depth > 0 {
+		return depth + 1
+	}
+	return 0
+}
+
+// singleRuneStep returns the step matching the tree if it's a single rune, nil otherwise
+func singleRuneStep(tree *syntax.Regexp) *byPassStep {
+	prog := &byPassProgAnchored{}
+	if prog.traverseTree(tree) || len(prog.steps) != 1 || prog.steps[0].length != 1 {
+		return nil
+	}
+	return prog.steps[0]
+}
+
+// compileByPassUnanchoredPlus finds out if the tree is a standalone unanchored single-rune `class+`
+func compileByPassUnanchoredPlus(tree *syntax.Regexp) *byPassProgPlus {
+
+	plusprog := &byPassProgPlus{}
+
+	if tree.Op == syntax.OpCapture {
+		plusprog.capture = tree.Cap
+		tree = tree.Sub[0]
+	}
+	// A non-greedy `class+?` would only match a single rune
+	if tree.Flags&syntax.NonGreedy != 0 {
+		return nil
+	}
+	step, isPlus := repeatedStep(tree)
+	if step == nil || !isPlus || step.length != 1 {
+		return nil
+	}
+	plusprog.step = step
+
+	prog := &byPassProgAnchored{steps: []*byPassStep{step}}
+	prog.computeWidth()
+	plusprog.find = &byPassProgUnanchored{
+		steps:    prog.steps,
+		length:   prog.length,
+		minWidth: prog.minWidth,
+		maxWidth: prog.maxWidth,
+	}
+
+	return plusprog
+}
+
+// compileByPassPartialPrefix finds out if a fixed-length prefix can be extracted from the tree
+func compileByPassPartialPrefix(firstpassprog *byPassProgFirstPass, tree *syntax.Regexp) {
+
+	if tree.Sub[0].Op == syntax.OpBeginText {
+
+		prefixProg := &byPassProgAnchored{}
+		i := 0
+
+		// Find the longest fixed-length prefix supported by a byPassProgAnchored
+		for ; i < len(tree.Sub); i++ {
+			if prefixProg.traverseTree(tree.Sub[i]) {
+				break
+			}
+		}
+
+		// The node we bailed out on may already have changed the prog (`^a(b$c*)` appends `b` to
+		// the `a` literal and sets anchoredEnd), so the prefix is traversed again without it
+		prefixProg = &byPassProgAnchored{}
+		for _, sub := range tree.Sub[:i] {
+			prefixProg.traverseTree(sub)
+		}
+
+		if len(prefixProg.steps) > 0 && i > 1 {
+			if !hasOps(tree.Sub[i:], []syntax.Op{syntax.OpBeginText, syntax.OpBeginLine, syntax.OpWordBoundary, syntax.OpNoWordBoundary}) {
+
+				prefixProg.computeWidth()
+				firstpassprog.prefixProg = prefixProg
+
+				// Build and compile a new Regexp for the rest of the pattern (`^aa(c*)` => `^(c*)`)
+				tree.Sub = append(tree.Sub[0:1], tree.Sub[i:]...)
+				// TODO longest?
+				// Error is safe to ignore because it was already compiled earlier
+				re, err := compileParsed(tree, false)
+				if err != nil {
+					panic(err)
+				}
+				firstpassprog.regexp = re
+
+			}
+		}
+	}
+}
+
+// compileByPassPartialPrefix finds out if a fixed-length suffix can be extracted from the tree
+func compileByPassPartialSuffix(firstpassprog *byPassProgFirstPass, tree *syntax.Regexp) {
+
+	if len(tree.Sub) > 1 && tree.Sub[len(tree.Sub)-1].Op == syntax.OpEndText {
+
+		suffixProg := &byPassProgAnchored{}
+		lastInvalid := 0
+
+		// Find the longest fixed-length suffix supported by a byPassProgAnchored
+		for i := 0; i < len(tree.Sub); i++ {
+			// Reset the prog until we have a full suffix
+			if suffixProg.traverseTree(tree.Sub[i]) {
+				suffixProg = &byPassProgAnchored{}
+				lastInvalid = i
+			}
+		}
+
+		if len(suffixProg.steps) > 0 && lastInvalid < len(tree.Sub)-1 {
+			if !hasOps(tree.Sub[:lastInvalid+1], []syntax.Op{syntax.OpEndText, syntax.OpEndLine, syntax.OpWordBoundary, syntax.OpNoWordBoundary}) {
+
+				suffixProg.computeWidth()
+				firstpassprog.suffixProg = suffixProg
+
+				// Build and compile a new Regexp for the rest of the pattern (`(c*)bb$` => `(c*)$`)
+				tree.Sub = append(tree.Sub[:lastInvalid+1], tree.Sub[len(tree.Sub)-1])
+				// TODO longest?
+				// Error is safe to ignore because it was already compiled earlier
+				re, err := compileParsed(tree, false)
+				if err != nil {
+					panic(err)
+				}
+				firstpassprog.regexp = re
+			}
+		}
+	}
+}
+
+// compileByPassDotStarRest finds out if the rest of the pattern, once the prefix and suffix were extracted, is anchored
+// on both ends and only made of `.*` and `.+` (`^xx.*.+yy$` => `\A.*.+$`), so that it doesn't need the other matchers
+func compileByPassDotStarRest(firstpassprog *byPassProgFirstPass, tree *syntax.Regexp) {
+
+	last := len(tree.Sub) - 1
+	if tree.Op != syntax.OpConcat || last < 1 || tree.Sub[0].Op != syntax.OpBeginText || tree.Sub[last].Op != syntax.OpEndText {
+		return
+	}
+
+	minLength := 0
+	matchNL := false
+	for i, sub := range tree.Sub[1:last] {
+		// Capturing groups are transparent when matching
+		for sub.Op == syntax.OpCapture {
+			sub = sub.Sub[0]
+		}
+		if sub.Op != syntax.OpStar && sub.Op != syntax.OpPlus || len(sub.Sub) != 1 {
+			return
+		}
+		var subMatchNL bool
+		switch sub.Sub[0].Op {
+		case syntax.OpAnyChar:
+			subMatchNL = true
+		case syntax.OpAnyCharNotNL:
+		default:
+			return
+		}
+		// `(?s:.*).*` would need to know where the `\n` are
+		if i > 0 && subMatchNL != matchNL {
+			return
+		}
+		matchNL = subMatchNL
+		if sub.Op == syntax.OpPlus {
+			minLength++
+		}
+	}
+
+	firstpassprog.dotStarRest = true
+	firstpassprog.restMinLength = minLength
+	firstpassprog.restMatchNL = matchNL
+}
+
+// compileParsed is a shorter version of compile() that takes a parsed tree as input
+// TODO: factorize this with the regular compile() function
+func compileParsed(re *syntax.Regexp, longest bool) (*Regexp, error) {
+
+	expr := re.String()
+	maxCap := re.MaxCap()
+	capNames := re.CapNames()
 
 	re = re.Simplify()
 	prog, err := syntax.Compile(re)
 	if err != nil {
 		return nil, err
 	}
-	regexp := &Regexp{
-		regexpRO: regexpRO{
-			// expr:        expr,
-			prog:        prog,
-			onepass:     compileOnePass(prog),
-			numSubexp:   maxCap,
-			subexpNames: capNames,
-			cond:        prog.StartCond(),
-			longest:     longest,
-		},
+	regexp := &Regexp{
+		regexpRO: regexpRO{
+			expr:        expr,
+			mode:        syntax.Perl,
+			prog:        prog,
+			onepass:     compileOnePass(prog),
+			numSubexp:   maxCap,
+			subexpNames: capNames,
+			cond:        prog.StartCond(),
+			longest:     longest,
+		},
+	}
+	if regexp.onepass == notOnePass {
+		regexp.prefix, regexp.prefixComplete = prog.Prefix()
+	} else {
+		regexp.prefix, regexp.prefixComplete, regexp.prefixEnd = onePassPrefix(prog)
+	}
+	if regexp.prefix != "" {
+		// TODO(rsc): Remove this allocation by adding
+		// IndexString to package bytes.
+		regexp.prefixBytes = []byte(regexp.prefix)
+		regexp.prefixRune, _ = utf8.DecodeRuneInString(regexp.prefix)
+	}
+	return regexp, nil
+}
+
+// splitLiteral returns a concatenation matching the literal tree with runes that can't be compared as
+// bytes replaced by classes, and without the FoldCase flag. With FoldCase (`(?i:ab)`), runes with other
+// cases become classes of all their cases (`[Aa][Bb]`). U+FFFD becomes a class too, because it also
+// matches invalid UTF-8 bytes, which are decoded as RuneError. The other runes stay literals.
+func splitLiteral(tree *syntax.Regexp) *syntax.Regexp {
+	flags := tree.Flags &^ syntax.FoldCase
+	concat := &syntax.Regexp{Op: syntax.OpConcat, Flags: flags}
+	for _, r := range tree.Rune {
+		sub := &syntax.Regexp{Op: syntax.OpLiteral, Flags: flags, Rune: []rune{r}}
+		if r == utf8.RuneError {
+			sub.Op = syntax.OpCharClass
+			sub.Rune = []rune{r, r}
+		} else if tree.Flags&syntax.FoldCase != 0 && unicode.SimpleFold(r) != r {
+			folded := []rune{r}
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				folded = append(folded, f)
+			}
+			sort.Slice(folded, func(i, j int) bool { return folded[i] < folded[j] })
+			sub.Op = syntax.OpCharClass
+			sub.Rune = make([]rune, 0, 2*len(folded))
+			for _, f := range folded {
+				sub.Rune = append(sub.Rune, f, f)
+			}
+		}
+		concat.Sub = append(concat.Sub, sub)
+	}
+	return concat
+}
+
+// hasRuneError returns true if the runes contain U+FFFD, which also matches invalid UTF-8 bytes
+func hasRuneError(runes []rune) bool {
+	for _, r := range runes {
+		if r == utf8.RuneError {
+			return true
+		}
+	}
+	return false
+}
+
+// traverseTree visits each node of the parsed regexp to detect fixed-length patterns
+func (prog *byPassProgAnchored) traverseTree(tree *syntax.Regexp) (bailout bool) {
+
+	// WasDollar marks a `$` compiled to OpEndText because the pattern isn't multiline. It then has
+	// the same semantics as `\z`. Under `(?m)` (or POSIX), `$` is an OpEndLine which is not supported.
+	// NonGreedy (`+?`, `(?U)`) only changes the length picked by repetitions, which are either
+	// not supported here or expanded in the backtracking order by expandQuests.
+	// DotNL (`(?s)`) is already resolved by the parser into OpAnyChar instead of OpAnyCharNotNL.
+	// FoldCase (`(?i)`) is resolved too in character classes, which include all the cases of their
+	// runes, and case-insensitive literals (`a(?i:b)c`) are matched like classes of their runes (`a[Bb]c`).
+	if tree.Op == syntax.OpLiteral && (tree.Flags&syntax.FoldCase != 0 || hasRuneError(tree.Rune)) {
+		return prog.traverseTree(splitLiteral(tree))
+	}
+	flags := tree.Flags &^ (syntax.NonGreedy | syntax.DotNL | syntax.FoldCase)
+	if tree.Op == syntax.OpEndText {
+		flags &^= syntax.WasDollar
+	}
+
+	// TODO make sure other flag combinations can't be supported too
+	// Note that there is no flag for `(?x)`: it is rejected when parsing, like in RE2.
+	if flags != syntax.Perl && flags != syntax.POSIX {
+		return true
+	}
+
+	var step *byPassStep
+
+	// https://golang.org/pkg/regexp/syntax/#Op
+	switch tree.Op {
+
+	case syntax.OpConcat:
+		for _, sub := range tree.Sub {
+			if prog.traverseTree(sub) {
+				return true
+			}
+		}
+	case syntax.OpRepeat:
+		// We only support fixed-length patterns
+		if tree.Min != tree.Max {
+			return true
+		}
+		for i := 0; i < tree.Min; i++ {
+			if prog.traverseTree(tree.Sub[0]) {
+				return true
+			}
+		}
+	case syntax.OpLiteral:
+
+		// The parser never produces empty literals, but make sure they can't become zero-length steps
+		if len(tree.Rune) == 0 {
+			break
+		}
+
+		// If the previous step was also an OpLiteral, append to it
+		if len(prog.steps) > 0 && prog.steps[len(prog.steps)-1].op == byPassOpLiteral && !prog.anchoredEnd {
+			prevstep := prog.steps[len(prog.steps)-1]
+			prevstep.literal += string(tree.Rune)
+			prevstep.length += len(tree.Rune)
+			prevstep.minWidth += len(string(tree.Rune))
+			prevstep.maxWidth += len(string(tree.Rune))
+			prog.length += len(tree.Rune)
+			break
+		}
+
+		step = &byPassStep{
+			op:       byPassOpLiteral,
+			literal:  string(tree.Rune),
+			length:   len(tree.Rune),
+			minWidth: len(string(tree.Rune)),
+			maxWidth: len(string(tree.Rune)),
+		}
+
+	case syntax.OpBeginText:
+		if prog.length > 0 {
+			prog.unmatchable = true
+			return false
+		}
+		prog.anchoredBegin = true
+
+	case syntax.OpEndText:
+		prog.anchoredEnd = true
+
+		if !prog.anchoredBegin {
+			right := 0
+			// go back to the previous steps & set their anchorIndex to the right
+			for i := len(prog.steps) - 1; i >= 0; i-- {
+				right -= prog.steps[i].length
+				prog.steps[i].anchored = true
+				prog.steps[i].anchorIndex = right
+			}
+		}
+
+	case syntax.OpAnyCharNotNL:
+		step = &byPassStep{
+			op:       byPassOpNegativeCharClass,
+			char:     rune('\n'),
+			literal:  "\n",
+			length:   1,
+			minWidth: 1,
+			maxWidth: -1,
+		}
+
+	case syntax.OpAnyChar:
+		step = &byPassStep{
+			op:       byPassOpAnyChar,
+			length:   1,
+			minWidth: 1,
+			// TODO: could we use maxWidth:4 ? What about combining characters?
+			maxWidth: -1,
+		}
+
+	case syntax.OpEmptyMatch:
+		// Empty groups (`a()b`) and `x{0}` match the empty string and don't add any step
+
+	case syntax.OpNoMatch:
+		prog.unmatchable = true
+		return false
+
+	case syntax.OpCharClass:
+		// Optimize single-character exclusion classes
+		if len(tree.Rune) == 4 && tree.Rune[0] == 0 && tree.Rune[3] == utf8.MaxRune && tree.Rune[1]+2 == tree.Rune[2] {
+			step = &byPassStep{
+				op:       byPassOpNegativeCharClass,
+				char:     tree.Rune[1] + 1,
+				literal:  string(tree.Rune[1] + 1),
+				length:   1,
+				minWidth: 1,
+				maxWidth: -1,
+			}
+		} else if len(tree.Rune) > 4 && tree.Rune[0] == 0 && tree.Rune[len(tree.Rune)-1] == utf8.MaxRune {
+			// Other exclusion classes are stored as their excluded ranges
+			excluded := make([]rune, 0, len(tree.Rune)-2)
+			for i := 1; i < len(tree.Rune)-1; i += 2 {
+				excluded = append(excluded, tree.Rune[i]+1, tree.Rune[i+1]-1)
+			}
+			step = &byPassStep{
+				op:       byPassOpNegativeCharClass,
+				classes:  excluded,
+				literal:  asciiCharsInRanges(excluded),
+				length:   1,
+				minWidth: 1,
+				maxWidth: -1,
+			}
+			step.asciiSet, step.asciiOnly = asciiSetOfRanges(excluded)
+		} else {
+			step = &byPassStep{
+				op:       byPassOpCharClass,
+				classes:  tree.Rune,
+				length:   1,
+				minWidth: 1,
+				maxWidth: -1,
+			}
+			step.asciiSet, step.asciiOnly = asciiSetOfRanges(tree.Rune)
+			// ASCII classes (`[a-z]`) only match single bytes, which lets `^[a-z]{5}$` reject other lengths early
+			if step.asciiOnly {
+				step.maxWidth = 1
+				step.byteSet = byteSetOfRanges(tree.Rune)
+			}
+		}
+
+		// If the previous step was the same single-byte class, repeat it (`[0-9]{4}`)
+		if step.op == byPassOpCharClass && step.asciiOnly && len(prog.steps) > 0 && !prog.anchoredEnd {
+			prevstep := prog.steps[len(prog.steps)-1]
+			if prevstep.op == byPassOpCharClass && prevstep.asciiOnly && prevstep.asciiSet == step.asciiSet {
+				prevstep.length++
+				prevstep.minWidth++
+				prevstep.maxWidth++
+				prog.length++
+				step = nil
+			}
+		}
+
+	case syntax.OpAlternate:
+		// Alternations of literals with the same number of runes are a single step (`ab(?:cd|ef)gh`)
+		step = &byPassStep{
+			op:     byPassOpLiteralSet,
+			length: len(tree.Sub[0].Rune),
+		}
+		for _, sub := range tree.Sub {
+			if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 || len(sub.Rune) != step.length || hasRuneError(sub.Rune) {
+				return true
+			}
+			literal := string(sub.Rune)
+			step.literals = append(step.literals, literal)
+			if step.minWidth == 0 || len(literal) < step.minWidth {
+				step.minWidth = len(literal)
+			}
+			if len(literal) > step.maxWidth {
+				step.maxWidth = len(literal)
+			}
+		}
+
+	case syntax.OpCapture:
+		// Capturing groups don't change what is matched. Their offsets are fixed since all the steps are.
+		begin := prog.length
+		if prog.traverseTree(tree.Sub[0]) {
+			return true
+		}
+		prog.captures = append(prog.captures, byPassCapture{index: tree.Cap, begin: begin, end: prog.length})
+		if tree.Cap > prog.numSubexp {
+			prog.numSubexp = tree.Cap
+		}
+
+	case syntax.OpBeginLine, syntax.OpEndLine:
+		// Multiline anchors can match in the middle of the string
+		return true
+
+	/*
+		case syntax.OpAlternate,
+			 syntax.OpWordBoundary, syntax.OpNoWordBoundary,
+			 syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
+			 return true
+	*/
+
+	default:
+		// Unsupported Op
+		return true
+	}
+
+	if step != nil {
+
+		// No more steps with length > 0 can be added after a $. Empty elements (`abc$(?:)`, `abc$a{0}`)
+		// don't add any step, so they keep the pattern matchable.
+		if prog.anchoredEnd && step.length > 0 {
+			prog.unmatchable = true
+			return false
+		}
+
+		if prog.anchoredBegin {
+			step.anchored = true
+			step.anchorIndex = prog.length
+		}
+
+		prog.length += step.length
+		prog.steps = append(prog.steps, step)
+
 	}
-	if regexp.onepass == notOnePass {
-		regexp.prefix, regexp.prefixComplete = prog.Prefix()
-	} else {
-		regexp.prefix, regexp.prefixComplete, regexp.prefixEnd = onePassPrefix(prog)
+
+	return false
+}
+
+func (prog *byPassProgLiteral) MatchString(s string) (matched bool) {
+
+	if byPassStatsEnabled && prog.stats != nil {
+		if len(s) < len(prog.literal) || prog.anchoredBegin && prog.anchoredEnd && len(s) > len(prog.literal) {
+			atomic.AddUint64(&prog.stats.earlyRejections, 1)
+		}
 	}
-	if regexp.prefix != "" {
-		// TODO(rsc): Remove this allocation by adding
-		// IndexString to package bytes.
-		regexp.prefixBytes = []byte(regexp.prefix)
-		regexp.prefixRune, _ = utf8.DecodeRuneInString(regexp.prefix)
+
+	// Plain literals used as filters (`error`) are a single strings.Contains
+	if !prog.anchoredBegin && !prog.anchoredEnd && !prog.wordBoundaryEnd {
+		return strings.Contains(s, prog.literal)
+	}
+
+	// Exact matches (`^abc$`) are a single string comparison, `\b` can't follow them
+	if prog.anchoredBegin && prog.anchoredEnd {
+		return s == prog.literal
+	}
+
+	if prog.wordBoundaryEnd {
+		matchBegin, _ := prog.IndexString(s, 0)
+		return matchBegin != -1
+	}
+
+	if prog.anchoredBegin {
+		return strings.HasPrefix(s, prog.literal)
+	}
+	return strings.HasSuffix(s, prog.literal)
+}
+
+func (prog *byPassProgLiteral) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+
+	if prog.wordBoundaryEnd {
+		return prog.indexWordBoundary(s, pos)
+	}
+
+	switch {
+	case prog.anchoredBegin:
+		if pos > 0 || !prog.MatchString(s) {
+			return -1, -1
+		}
+		return 0, len(prog.literal)
+	case prog.anchoredEnd:
+		matchBegin = len(s) - len(prog.literal)
+		if matchBegin < pos || !strings.HasSuffix(s, prog.literal) {
+			return -1, -1
+		}
+		return matchBegin, len(s)
+	}
+
+	matchBegin = strings.Index(s[pos:], prog.literal)
+	if matchBegin == -1 {
+		return -1, -1
+	}
+	return pos + matchBegin, pos + matchBegin + len(prog.literal)
+}
+
+// indexWordBoundary finds the leftmost literal not followed by a word character. As `\b` only
+// knows about ASCII word characters, checking the next byte is enough, unless they were
+// customized by CompileWordChars.
+func (prog *byPassProgLiteral) indexWordBoundary(s string, pos int) (matchBegin int, matchEnd int) {
+	for pos <= len(s) {
+		if prog.anchoredBegin {
+			if pos > 0 || !strings.HasPrefix(s, prog.literal) {
+				return -1, -1
+			}
+			matchBegin = 0
+		} else {
+			matchBegin = strings.Index(s[pos:], prog.literal)
+			if matchBegin == -1 {
+				return -1, -1
+			}
+			matchBegin += pos
+		}
+		matchEnd = matchBegin + len(prog.literal)
+		if !prog.wordCharAt(s, matchEnd) {
+			return matchBegin, matchEnd
+		}
+		pos = matchBegin + 1
+	}
+	return -1, -1
+}
+
+// wordCharAt returns true if s has a word character at offset i
+func (prog *byPassProgLiteral) wordCharAt(s string, i int) bool {
+	if i == len(s) {
+		return false
+	}
+	if prog.isWordChar == nil {
+		return syntax.IsWordChar(rune(s[i]))
+	}
+	char, _ := utf8.DecodeRuneInString(s[i:])
+	return prog.isWordChar(char)
+}
+
+func (prog *byPassProgLiteral) LastIndexString(s string) (matchBegin int, matchEnd int) {
+
+	// Anchored literals can only match at a single offset
+	if prog.anchoredBegin || prog.anchoredEnd {
+		return prog.IndexString(s, 0)
+	}
+
+	for end := len(s); ; {
+		matchBegin = strings.LastIndex(s[:end], prog.literal)
+		if matchBegin == -1 {
+			return -1, -1
+		}
+		matchEnd = matchBegin + len(prog.literal)
+		if !prog.wordBoundaryEnd || !prog.wordCharAt(s, matchEnd) {
+			return matchBegin, matchEnd
+		}
+		// Previous occurrences begin before this one, but can overlap it
+		end = matchEnd - 1
+	}
+}
+
+func (prog *byPassProgLiteral) ReaderIndex(r io.RuneReader) (matchBegin int, matchEnd int) {
+
+	length := len(prog.runes)
+	if length == 0 {
+		return 0, 0
+	}
+
+	// Same circular window of the last runes read as byPassProgUnanchored.ReaderIndex
+	window := make([]rune, length)
+	offsets := make([]int, length)
+	matchBegin, matchEnd = -1, -1
+
+	// With `\b`, a match is only reported once the next rune is known not to be a word character
+	pendingBegin, pendingEnd := -1, -1
+
+	pos := 0
+	for n := 0; ; n++ {
+		char, size, err := r.ReadRune()
+		if err != nil {
+			if pendingBegin != -1 {
+				return pendingBegin, pendingEnd
+			}
+			// `$` only matches if the last window matched
+			if matchEnd != pos {
+				return -1, -1
+			}
+			return matchBegin, matchEnd
+		}
+		if pendingBegin != -1 {
+			if prog.isWordChar == nil && !syntax.IsWordChar(char) || prog.isWordChar != nil && !prog.isWordChar(char) {
+				return pendingBegin, pendingEnd
+			}
+			pendingBegin, pendingEnd = -1, -1
+		}
+		window[n%length] = char
+		offsets[n%length] = pos
+		pos += size
+
+		if prog.anchoredBegin && n >= length {
+			return -1, -1
+		}
+
+		matchBegin, matchEnd = -1, -1
+		if n+1 >= length {
+			matched := true
+			for i, char := range prog.runes {
+				if window[(n+1+i)%length] != char {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				matchBegin, matchEnd = offsets[(n+1)%length], pos
+				if prog.wordBoundaryEnd {
+					pendingBegin, pendingEnd = matchBegin, matchEnd
+					matchBegin, matchEnd = -1, -1
+				} else if !prog.anchoredEnd {
+					return matchBegin, matchEnd
+				}
+			}
+		}
+	}
+}
+
+func (prog *byPassProgAlternate) MatchString(s string) (matched bool) {
+	if prog.byWidth != nil {
+		for _, subprog := range prog.byWidth[len(s)] {
+			if subprog.MatchString(s) {
+				return true
+			}
+		}
+		for _, subprog := range prog.anyWidth {
+			if subprog.MatchString(s) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, subprog := range prog.progs {
+		if subprog.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexByWidth sets byWidth and anyWidth if all the parts of the alternation are anchored on both ends
+func (prog *byPassProgAlternate) indexByWidth() {
+	byWidth := make(map[int][]byPassProg)
+	var anyWidth []byPassProg
+	for _, subprog := range prog.progs {
+		width := -1
+		switch p := subprog.(type) {
+		case *byPassProgLiteral:
+			if !p.anchoredBegin || !p.anchoredEnd {
+				return
+			}
+			width = len(p.literal)
+		case *byPassProgAnchored:
+			if !p.anchoredBegin || !p.anchoredEnd {
+				return
+			}
+			if p.minWidth == p.maxWidth {
+				width = p.maxWidth
+			}
+		default:
+			return
+		}
+		if width == -1 {
+			anyWidth = append(anyWidth, subprog)
+		} else {
+			byWidth[width] = append(byWidth[width], subprog)
+		}
+	}
+	prog.byWidth = byWidth
+	prog.anyWidth = anyWidth
+}
+
+func (prog *byPassProgAlternate) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+
+	// All the parts can only match the whole string, so their order doesn't matter
+	if prog.byWidth != nil {
+		if pos > 0 || !prog.MatchString(s) {
+			return -1, -1
+		}
+		return 0, len(s)
+	}
+
+	matchBegin, matchEnd = -1, -1
+	for _, subprog := range prog.progs {
+		// Leftmost-first: on a tie, the first part of the alternation wins
+		begin, end := subprog.(byPassIndexProg).IndexString(s, pos)
+		if begin != -1 && (matchBegin == -1 || begin < matchBegin) {
+			matchBegin, matchEnd = begin, end
+		}
+	}
+	return matchBegin, matchEnd
+}
+
+// LastIndexString takes, like IndexString, the first part of the alternation on a tie
+func (prog *byPassProgAlternate) LastIndexString(s string) (matchBegin int, matchEnd int) {
+
+	// All the parts can only match the whole string
+	if prog.byWidth != nil {
+		return prog.IndexString(s, 0)
+	}
+
+	matchBegin, matchEnd = -1, -1
+	for _, subprog := range prog.progs {
+		if begin, end := subprog.(byPassLastIndexProg).LastIndexString(s); begin > matchBegin {
+			matchBegin, matchEnd = begin, end
+		}
+	}
+	return matchBegin, matchEnd
+}
+
+func (prog *byPassProgResidual) MatchString(s string) (matched bool) {
+	return prog.regexp.MatchString(s)
+}
+
+func (prog *byPassProgResidual) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+	loc := prog.regexp.doExecute(nil, nil, s, pos, 2, nil)
+	if loc == nil {
+		return -1, -1
 	}
-	return regexp, nil
+	return loc[0], loc[1]
 }
 
-// traverseTree visits each node of the parsed regexp to detect fixed-length patterns
-func (prog *byPassProgAnchored) traverseTree(tree *syntax.Regexp) (bailout bool) {
+// FindAlternateLiteral returns the literal matched by the leftmost match in s, when all the parts
+// of the alternation are literals (e.g. `cat|dog|bird`)
+func (prog *byPassProgAlternate) FindAlternateLiteral(s string) (literal string, found bool) {
+	matchBegin := -1
+	for _, subprog := range prog.progs {
+		literalprog, ok := subprog.(*byPassProgLiteral)
+		if !ok {
+			return "", false
+		}
+		// Leftmost-first: on a tie, the first part of the alternation wins
+		begin, _ := literalprog.IndexString(s, 0)
+		if begin != -1 && (matchBegin == -1 || begin < matchBegin) {
+			matchBegin, literal = begin, literalprog.literal
+		}
+	}
+	return literal, matchBegin != -1
+}
 
-	// TODO make sure other flag combinations can't be supported too
-	if tree.Flags != syntax.Perl && tree.Flags != syntax.POSIX && tree.Flags != syntax.Perl|syntax.WasDollar {
+// isLiteralAlternation returns true if the prog is made of a single alternation of literals,
+// compiled as a LiteralSet step (`cat|dog`) or as parts of an alternation without prefix (`^(?:cat|bird)`)
+func isLiteralAlternation(prog byPassProg) bool {
+	switch p := prog.(type) {
+	case *byPassProgAnchored:
+		return len(p.steps) == 1 && p.steps[0].op == byPassOpLiteralSet
+	case *byPassProgUnanchored:
+		return len(p.steps) == 1 && p.steps[0].op == byPassOpLiteralSet
+	case *byPassProgPrefixTrie:
+		return true
+	case *byPassProgPrefixAlternate:
+		if len(p.prefixProg.steps) > 0 {
+			return false
+		}
+		for _, subprog := range p.progs {
+			if len(subprog.steps) != 1 || subprog.steps[0].op != byPassOpLiteral {
+				return false
+			}
+		}
 		return true
 	}
+	return false
+}
 
-	var step *byPassStep
+func (prog *byPassProgPrefixAlternate) MatchString(s string) (matched bool) {
+	begin, _ := prog.IndexString(s, 0)
+	return begin != -1
+}
 
-	// https://golang.org/pkg/regexp/syntax/#Op
-	switch tree.Op {
+func (prog *byPassProgPrefixAlternate) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
 
-	case syntax.OpConcat:
-		for _, sub := range tree.Sub {
-			if prog.traverseTree(sub) {
-				return true
+	if pos > 0 || !prog.prefixProg.MatchString(s) {
+		return -1, -1
+	}
+	prefixWidth := nextRunesWidth(s, prog.prefixProg.length)
+
+	// All the parts can only match the rest of the string, so their order doesn't matter
+	if prog.byWidth != nil {
+		rest := s[prefixWidth:]
+		for _, subprog := range prog.byWidth[len(rest)] {
+			if subprog.MatchString(rest) {
+				return 0, len(s)
 			}
 		}
-	case syntax.OpRepeat:
-		// We only support fixed-length patterns
-		if tree.Min != tree.Max {
-			return true
-		}
-		for i := 0; i < tree.Min; i++ {
-			if prog.traverseTree(tree.Sub[0]) {
-				return true
+		for _, subprog := range prog.anyWidth {
+			if subprog.MatchString(rest) {
+				return 0, len(s)
 			}
 		}
-	case syntax.OpLiteral:
+		return -1, -1
+	}
 
-		// If the previous step was also an OpLiteral, append to it
-		if len(prog.steps) > 0 && prog.steps[len(prog.steps)-1].op == byPassOpLiteral && !prog.anchoredEnd {
-			prevstep := prog.steps[len(prog.steps)-1]
-			prevstep.literal += string(tree.Rune)
-			prevstep.length += len(tree.Rune)
-			prevstep.minWidth += len(string(tree.Rune))
-			prevstep.maxWidth += len(string(tree.Rune))
-			prog.length += len(tree.Rune)
-			break
+	// Leftmost-first: the first part of the alternation that matches wins
+	for _, subprog := range prog.progs {
+		if _, end := subprog.IndexString(s[prefixWidth:], 0); end != -1 {
+			return 0, prefixWidth + end
 		}
+	}
+	return -1, -1
+}
 
-		step = &byPassStep{
-			op:       byPassOpLiteral,
-			literal:  string(tree.Rune),
-			length:   len(tree.Rune),
-			minWidth: len(string(tree.Rune)),
-			maxWidth: len(string(tree.Rune)),
-		}
+func (prog *byPassProgFirstPass) MatchString(s string) (matched bool) {
 
-	case syntax.OpBeginText:
-		if prog.length > 0 {
-			prog.unmatchable = true
-			return false
-		}
-		prog.anchoredBegin = true
+	// Execute prefix and suffix first
+	begin, end, matched := trimPrefixSuffix(s, prog.prefixProg, prog.suffixProg)
+	if !matched {
+		return false
+	}
 
-	case syntax.OpEndText:
-		prog.anchoredEnd = true
+	if prog.dotStarRest {
+		return prog.matchDotStarRest(s[begin:end])
+	}
 
-		if !prog.anchoredBegin {
-			right := 0
-			// go back to the previous steps & set their anchorIndex to the right
-			for i := len(prog.steps) - 1; i >= 0; i-- {
-				right -= prog.steps[i].length
-				prog.steps[i].anchored = true
-				prog.steps[i].anchorIndex = right
-			}
-		}
+	// Finally, execute the rest of the regexp with other matchers
+	return prog.regexp.MatchString(s[begin:end])
 
-	case syntax.OpAnyCharNotNL:
-		step = &byPassStep{
-			op:       byPassOpNegativeCharClass,
-			char:     rune('\n'),
-			literal:  "\n",
-			length:   1,
-			minWidth: 1,
-			maxWidth: -1,
+}
+
+func (prog *byPassProgFirstPass) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+
+	// `^` can only match at the beginning of the string
+	if prog.prefixProg != nil && pos > 0 {
+		return -1, -1
+	}
+
+	begin, end, matched := trimPrefixSuffix(s, prog.prefixProg, prog.suffixProg)
+	if !matched || pos > end {
+		return -1, -1
+	}
+
+	// The rest is anchored on both ends, so the match is the whole string
+	if prog.dotStarRest {
+		if pos > 0 || !prog.matchDotStarRest(s[begin:end]) {
+			return -1, -1
 		}
+		return 0, len(s)
+	}
 
-	case syntax.OpAnyChar:
-		step = &byPassStep{
-			op:       byPassOpAnyChar,
-			length:   1,
-			minWidth: 1,
-			// TODO: could we use maxWidth:4 ? What about combining characters?
-			maxWidth: -1,
+	// The match spans the prefix and the suffix (`^aa(.*)bb$`), otherwise its bounds are given by the rest
+	// of the regexp, translated back to s. Without a prefix, s is not trimmed at the beginning: the `^` of
+	// `^(a*)bb$` must still fail at pos > 0.
+	if prog.prefixProg == nil {
+		begin = 0
+	}
+	loc := prog.regexp.doExecute(nil, nil, s[begin:end], pos, 2, nil)
+	if loc == nil {
+		return -1, -1
+	}
+	matchBegin, matchEnd = begin+loc[0], begin+loc[1]
+	if prog.prefixProg != nil {
+		matchBegin = 0
+	}
+	if prog.suffixProg != nil {
+		matchEnd = len(s)
+	}
+	return matchBegin, matchEnd
+}
+
+// matchDotStarRest checks the rest of the string between the prefix and the suffix against `.*` and `.+`
+func (prog *byPassProgFirstPass) matchDotStarRest(rest string) (matched bool) {
+	if !prog.restMatchNL && strings.IndexByte(rest, '\n') != -1 {
+		return false
+	}
+	return len(rest) >= prog.restMinLength*utf8.UTFMax || utf8.RuneCountInString(rest) >= prog.restMinLength
+}
+
+// trimPrefixSuffix matches the optional prefix and suffix progs and returns the byte offsets of the rest of the string
+func trimPrefixSuffix(s string, prefixProg *byPassProgAnchored, suffixProg *byPassProgAnchored) (begin int, end int, matched bool) {
+	end = len(s)
+	if prefixProg != nil {
+		if !prefixProg.MatchString(s) {
+			return 0, 0, false
+		}
+		begin = nextRunesWidth(s, prefixProg.length)
+	}
+	if suffixProg != nil {
+		if !suffixProg.MatchString(s[begin:]) {
+			return 0, 0, false
 		}
+		end -= lastRunesWidth(s[begin:], suffixProg.length)
+	}
+	return begin, end, true
+}
 
-	case syntax.OpNoMatch:
-		prog.unmatchable = true
+func (prog *byPassProgPlus) MatchString(s string) (matched bool) {
+	if prog.find != nil {
+		return prog.find.MatchString(s)
+	}
+	begin, end, matched := trimPrefixSuffix(s, prog.prefixProg, prog.suffixProg)
+	if !matched || begin == end && !prog.star {
+		return false
+	}
+	// Without `$`, the following runes only extend the match of the first one
+	if prog.openEnd {
+		_, width := utf8.DecodeRuneInString(s[begin:])
+		return prog.star || matchStepRepeat(prog.step, s[begin:begin+width])
+	}
+	if (prog.minCount > 1 || prog.maxCount > 0) && !prog.matchCount(s[begin:end]) {
 		return false
+	}
+	return matchStepRepeat(prog.step, s[begin:end])
+}
 
-	case syntax.OpCharClass:
-		// Optimize single-character exclusion classes
-		if len(tree.Rune) == 4 && tree.Rune[0] == 0 && tree.Rune[3] == utf8.MaxRune && tree.Rune[1]+2 == tree.Rune[2] {
-			step = &byPassStep{
-				op:       byPassOpNegativeCharClass,
-				char:     tree.Rune[1] + 1,
-				literal:  string(tree.Rune[1] + 1),
-				length:   1,
-				minWidth: 1,
-				maxWidth: -1,
-			}
-		} else {
-			step = &byPassStep{
-				op:       byPassOpCharClass,
-				classes:  tree.Rune,
-				length:   1,
-				minWidth: 1,
-				maxWidth: -1, // TODO we could be more precise (if [a-z] we know maxWidth=1)
-			}
+// matchCount checks that the number of runes in s, the part of the string matched by the step,
+// is within the bounds of the repeat
+func (prog *byPassProgPlus) matchCount(s string) bool {
+	if prog.maxCount > 0 && len(s) > prog.maxCount*utf8.UTFMax {
+		return false
+	}
+	count := utf8.RuneCountInString(s)
+	return count >= prog.minCount && (prog.maxCount == 0 || count <= prog.maxCount)
+}
+
+func (prog *byPassProgPlus) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+	if prog.find != nil {
+		return prog.indexUnanchored(s, pos)
+	}
+	// The pattern is anchored at the beginning, and at the end unless openEnd
+	if pos > 0 || !prog.MatchString(s) {
+		return -1, -1
+	}
+	if prog.openEnd {
+		begin, _, _ := trimPrefixSuffix(s, prog.prefixProg, nil)
+		return 0, prog.extendRepeat(s, begin)
+	}
+	return 0, len(s)
+}
+
+// indexUnanchored finds the first rune of the unanchored `class+`, and extends the match
+// as long as the following runes are in the class too
+func (prog *byPassProgPlus) indexUnanchored(s string, pos int) (matchBegin int, matchEnd int) {
+	matchBegin, _ = prog.find.IndexString(s, pos)
+	if matchBegin == -1 {
+		return -1, -1
+	}
+	return matchBegin, prog.extendRepeat(s, matchBegin)
+}
+
+// extendRepeat returns the end of the longest run of runes matching the single-rune step from begin
+func (prog *byPassProgPlus) extendRepeat(s string, begin int) (end int) {
+	end = begin
+	for end < len(s) {
+		_, width := utf8.DecodeRuneInString(s[end:])
+		if !matchStepRepeat(prog.step, s[end:end+width]) {
+			break
+		}
+		end += width
+	}
+	return end
+}
+
+func (prog *byPassProgPlus) NumSubexp() int {
+	// Other capturing groups may have been matched by the prefix (`^(ab)([^/]+)$`)
+	if prog.capture > 1 {
+		return -1
+	}
+	return prog.capture
+}
+
+func (prog *byPassProgPlus) FindStringSubmatchIndex(s string) (loc []int) {
+	if prog.find != nil {
+		matchBegin, matchEnd := prog.indexUnanchored(s, 0)
+		if matchBegin == -1 {
+			return nil
+		}
+		if prog.capture == 0 {
+			return []int{matchBegin, matchEnd}
 		}
+		return []int{matchBegin, matchEnd, matchBegin, matchEnd}
+	}
+	if !prog.MatchString(s) {
+		return nil
+	}
+	begin, end, _ := trimPrefixSuffix(s, prog.prefixProg, prog.suffixProg)
+	matchEnd := len(s)
+	if prog.openEnd {
+		end = prog.extendRepeat(s, begin)
+		matchEnd = end
+	}
+	if prog.capture == 0 {
+		return []int{0, matchEnd}
+	}
+	return []int{0, matchEnd, begin, end}
+}
 
-	/*
-		case syntax.OpCapture:
+func (prog *byPassProgDotStarSuffix) MatchString(s string) (matched bool) {
 
-		case syntax.OpBeginLine, syntax.OpEndLine, syntax.OpAlternate, syntax.OpEmptyMatch,
-			 syntax.OpWordBoundary, syntax.OpNoWordBoundary,
-			 syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
-			 return true
-	*/
+	if !prog.suffixProg.MatchString(s) {
+		return false
+	}
 
-	default:
-		// Unsupported Op
+	// When unanchored, `class+` can always be reduced to its last rune, right before the suffix
+	if prog.plus {
+		rest := s[:len(s)-lastRunesWidth(s, prog.suffixProg.length)]
+		_, width := utf8.DecodeLastRuneInString(rest)
+		return width > 0 && matchStepRepeat(prog.step, rest[len(rest)-width:])
+	}
+
+	// When unanchored, the `.*` can always match an empty string right before the suffix
+	if !prog.anchoredBegin || prog.matchNL {
 		return true
 	}
 
-	if step != nil {
+	// `^.*` must not contain any `\n` before the suffix
+	width := lastRunesWidth(s, prog.suffixProg.length)
+	return strings.IndexByte(s[:len(s)-width], '\n') == -1
+}
 
-		// No more steps with length > 0 can be added after a $
-		if prog.anchoredEnd {
-			prog.unmatchable = true
-			return false
+func (prog *byPassProgDotStarSuffix) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+
+	// `^` can only match at the beginning of the string
+	if prog.anchoredBegin {
+		if pos > 0 || !prog.MatchString(s) {
+			return -1, -1
 		}
+		return 0, len(s)
+	}
 
-		if prog.anchoredBegin {
-			step.anchored = true
-			step.anchorIndex = prog.length
+	// The suffix is anchored to the end of the string, so it can only begin at one position
+	suffixWidth := lastRunesWidth(s, prog.suffixProg.length)
+	if suffixWidth == -1 || len(s)-suffixWidth < pos || !prog.suffixProg.MatchString(s) {
+		return -1, -1
+	}
+
+	// The leftmost match extends the `.*` back from the suffix over all the runes it can match,
+	// e.g. up to the last `\n` before the suffix, but not before pos
+	suffixBegin := len(s) - suffixWidth
+	matchBegin = suffixBegin
+	for matchBegin > pos {
+		char, width := utf8.DecodeLastRuneInString(s[pos:matchBegin])
+		if prog.step != nil && !matchStepRepeat(prog.step, s[matchBegin-width:matchBegin]) ||
+			prog.step == nil && !prog.matchNL && char == '\n' {
+			break
 		}
+		matchBegin -= width
+	}
+	if prog.plus && matchBegin == suffixBegin {
+		return -1, -1
+	}
+	return matchBegin, len(s)
+}
 
-		prog.length += step.length
-		prog.steps = append(prog.steps, step)
+func (prog *byPassProgPrefixDotStar) MatchString(s string) (matched bool) {
 
+	if !prog.prefixProg.MatchString(s) {
+		return false
+	}
+	if prog.restProg == nil {
+		if prog.anchoredEnd && !prog.matchNL {
+			return strings.IndexByte(s[nextRunesWidth(s, prog.prefixProg.length):], '\n') == -1
+		}
+		return true
 	}
 
-	return false
+	prefixWidth := nextRunesWidth(s, prog.prefixProg.length)
+
+	// The leftmost match is enough: if the `.*` can't reach it because of a `\n`, it can't reach the others either
+	begin, _ := prog.restProg.IndexString(s, prefixWidth)
+	if begin == -1 {
+		return false
+	}
+	return prog.matchNL || strings.IndexByte(s[prefixWidth:begin], '\n') == -1
 }
 
-func (prog *byPassProgAlternate) MatchString(s string) (matched bool) {
-	for _, subprog := range prog.progs {
-		if subprog.MatchString(s) {
-			return true
+func (prog *byPassProgContains) MatchString(s string) (matched bool) {
+	matchBegin, _ := prog.coreProg.IndexString(s, 0)
+	return matchBegin != -1
+}
+
+func (prog *byPassProgContains) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+
+	coreBegin, coreEnd := prog.coreProg.IndexString(s, pos)
+	if coreBegin == -1 {
+		return -1, -1
+	}
+	if prog.matchNL {
+		return pos, len(s)
+	}
+
+	// The leftmost match starts at the beginning of the line of the first occurrence
+	matchBegin = pos + strings.LastIndexByte(s[pos:coreBegin], '\n') + 1
+
+	// The first `.*` is greedy: it reaches the last occurrence before the end of the line,
+	// and the second one extends it to the end of its line
+	lineEnd := len(s)
+	if i := strings.IndexByte(s[matchBegin:], '\n'); i != -1 {
+		lineEnd = matchBegin + i
+	}
+	for coreBegin < lineEnd {
+		_, width := utf8.DecodeRuneInString(s[coreBegin:])
+		nextBegin, nextEnd := prog.coreProg.IndexString(s, coreBegin+width)
+		if nextBegin == -1 || nextBegin > lineEnd {
+			break
 		}
+		coreBegin, coreEnd = nextBegin, nextEnd
 	}
-	return false
+	matchEnd = len(s)
+	if i := strings.IndexByte(s[coreEnd:], '\n'); i != -1 {
+		matchEnd = coreEnd + i
+	}
+	return matchBegin, matchEnd
 }
 
-func (prog *byPassProgFirstPass) MatchString(s string) (matched bool) {
+func (prog *byPassProgLengthRange) MatchString(s string) (matched bool) {
 
-	// Execute prefix and suffix first
-	if prog.prefixProg != nil {
-		if !prog.prefixProg.MatchString(s) {
-			return false
+	// Each rune takes between 1 and utf8.UTFMax bytes
+	if len(s) < prog.minLength || len(s) > prog.maxLength*utf8.UTFMax {
+		if byPassStatsEnabled && prog.stats != nil {
+			atomic.AddUint64(&prog.stats.earlyRejections, 1)
 		}
-		s = s[nextRunesWidth(s, prog.prefixProg.length):]
+		return false
 	}
-	if prog.suffixProg != nil {
-		if !prog.suffixProg.MatchString(s) {
-			return false
-		}
-		s = s[:len(s)-lastRunesWidth(s, prog.suffixProg.length)]
+
+	if !prog.matchNL && strings.IndexByte(s, '\n') != -1 {
+		return false
 	}
 
-	// Finally, execute the rest of the regexp with other matchers
-	return prog.regexp.MatchString(s)
+	length := utf8.RuneCountInString(s)
+	return length >= prog.minLength && length <= prog.maxLength
+}
+
+func (prog *byPassProgLengthRange) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+
+	// `^` can only match at the beginning of the string, and `$` at the end
+	if pos > 0 || !prog.MatchString(s) {
+		return -1, -1
+	}
+	return 0, len(s)
+}
 
+func (prog *byPassProgEmpty) MatchString(s string) (matched bool) {
+	return !prog.anchoredBegin || !prog.anchoredEnd || len(s) == 0
+}
+
+func (prog *byPassProgEmpty) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+	switch {
+	case prog.anchoredBegin && (pos > 0 || prog.anchoredEnd && len(s) > 0):
+		return -1, -1
+	case prog.anchoredEnd:
+		return len(s), len(s)
+	}
+	return pos, pos
 }
 
 func (prog *byPassProgUnmatchable) MatchString(s string) (matched bool) {
 	return false
 }
 
+func (prog *byPassProgUnmatchable) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+	return -1, -1
+}
+
+func (prog *byPassProgUnmatchable) LastIndexString(s string) (matchBegin int, matchEnd int) {
+	return -1, -1
+}
+
+// ReaderIndex returns without reading r, as nothing can match
+func (prog *byPassProgUnmatchable) ReaderIndex(r io.RuneReader) (matchBegin int, matchEnd int) {
+	return -1, -1
+}
+
 // computeWidth computes the byte length of a byPassProgAnchored from its steps
 func (prog *byPassProgAnchored) computeWidth() {
 
@@ -491,17 +3078,85 @@ func (prog *byPassProgAnchored) computeWidth() {
 		step.minNextWidth = minNextWidth
 		minNextWidth -= step.minWidth
 	}
-
+
+}
+
+func (prog *byPassProgAnchored) NumSubexp() int {
+	return prog.numSubexp
+}
+
+// FindStringSubmatchIndex locates the match, then each capturing group at its rune offsets from
+// the beginning of the match. Groups repeated by `(x){n}` report their last repetition.
+func (prog *byPassProgAnchored) FindStringSubmatchIndex(s string) (loc []int) {
+	matchBegin, matchEnd := prog.IndexString(s, 0)
+	if matchBegin == -1 {
+		return nil
+	}
+	loc = make([]int, 2+2*prog.numSubexp)
+	loc[0], loc[1] = matchBegin, matchEnd
+	for i := 2; i < len(loc); i++ {
+		loc[i] = -1
+	}
+	match := s[matchBegin:matchEnd]
+	// With only single-byte runes, rune offsets are byte offsets
+	ascii := prog.minWidth == prog.length && prog.maxWidth == prog.length
+	for _, capture := range prog.captures {
+		begin, end := capture.begin, capture.end
+		if !ascii {
+			begin = nextRunesWidth(match, begin)
+			end = begin + nextRunesWidth(match[begin:], capture.end-capture.begin)
+		}
+		loc[2*capture.index] = matchBegin + begin
+		loc[2*capture.index+1] = matchBegin + end
+	}
+	return loc
 }
 
 func (prog *byPassProgAnchored) MatchString(s string) (matched bool) {
 
 	if len(s) < prog.minWidth {
+		if byPassStatsEnabled && prog.stats != nil {
+			atomic.AddUint64(&prog.stats.earlyRejections, 1)
+		}
 		return false
 	}
 
+	// Every rune is a single ASCII byte (`^[a-z][0-9]`): check the bytes directly, like strings.HasPrefix.
+	// Anchored at the end only (`[0-9]{3}$`), like strings.HasSuffix: the last bytes can only be the last
+	// runes if they are all ASCII, otherwise one of these runes isn't and lastRunesWidth isn't needed.
+	if prog.asciiBytes != nil {
+		if prog.anchoredEnd {
+			if prog.anchoredBegin && len(s) != len(prog.asciiBytes) {
+				if byPassStatsEnabled && prog.stats != nil {
+					atomic.AddUint64(&prog.stats.earlyRejections, 1)
+				}
+				return false
+			}
+			s = s[len(s)-len(prog.asciiBytes):]
+		}
+		for i, set := range prog.asciiBytes {
+			if char := s[i]; char >= utf8.RuneSelf || set[char>>6]&(1<<(char&63)) == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
 	// For exact matches like ^aa$, we know the number of bytes in advance
 	if prog.anchoredBegin && prog.anchoredEnd && prog.maxWidth != -1 && len(s) > prog.maxWidth {
+		if byPassStatsEnabled && prog.stats != nil {
+			atomic.AddUint64(&prog.stats.earlyRejections, 1)
+		}
+		return false
+	}
+
+	// Otherwise (`^a.b$`), we still know the number of runes. The string is short enough to count them
+	// once it is checked against the maximum width of these runes.
+	if prog.anchoredBegin && prog.anchoredEnd && prog.maxWidth == -1 &&
+		(len(s) > prog.length*utf8.UTFMax || utf8.RuneCountInString(s) != prog.length) {
+		if byPassStatsEnabled && prog.stats != nil {
+			atomic.AddUint64(&prog.stats.earlyRejections, 1)
+		}
 		return false
 	}
 
@@ -521,16 +3176,9 @@ func (prog *byPassProgAnchored) MatchString(s string) (matched bool) {
 			return false
 		}
 
-		// Anchored from the beginning
-		if step.anchorIndex >= 0 {
-			anchorWidth := nextRunesWidth(s, step.anchorIndex)
-			if anchorWidth < begin {
-				return false
-			}
-			begin = anchorWidth
-
-			// Anchored from the end
-		} else {
+		// Anchored from the beginning, steps follow each other so begin is already
+		// step.anchorIndex runes from the start. Anchored from the end, count them backwards.
+		if step.anchorIndex < 0 {
 			anchorWidth := lastRunesWidth(s, -step.anchorIndex)
 			if anchorWidth == -1 {
 				return false
@@ -538,7 +3186,15 @@ func (prog *byPassProgAnchored) MatchString(s string) (matched bool) {
 			begin = end - anchorWidth
 		}
 
-		stepWidth = nextRunesWidth(s[begin:], step.length)
+		// Literals and single-byte classes already know their width in bytes, long ones shouldn't be decoded rune by rune
+		if step.minWidth == step.maxWidth {
+			stepWidth = step.minWidth
+			if begin+stepWidth > len(s) {
+				return false
+			}
+		} else {
+			stepWidth = nextRunesWidth(s[begin:], step.length)
+		}
 		end = begin + stepWidth
 
 		// Test the contents of the slice
@@ -560,29 +3216,196 @@ func (prog *byPassProgAnchored) MatchString(s string) (matched bool) {
 
 }
 
+func (prog *byPassProgAnchored) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+
+	// `^` can only match at the beginning of the string
+	if prog.anchoredBegin && pos > 0 {
+		return -1, -1
+	}
+
+	if !prog.MatchString(s) {
+		return -1, -1
+	}
+
+	switch {
+	case prog.anchoredBegin && prog.anchoredEnd:
+		return 0, len(s)
+	case prog.anchoredBegin:
+		return 0, nextRunesWidth(s, prog.length)
+	}
+
+	if prog.asciiBytes != nil {
+		matchBegin = len(s) - len(prog.asciiBytes)
+	} else {
+		matchBegin = len(s) - lastRunesWidth(s, prog.length)
+	}
+	if matchBegin < pos {
+		return -1, -1
+	}
+	return matchBegin, len(s)
+}
+
+// LastIndexString returns the only match of the prog, since it is anchored
+func (prog *byPassProgAnchored) LastIndexString(s string) (matchBegin int, matchEnd int) {
+	return prog.IndexString(s, 0)
+}
+
+// MatchPrefix checks if the prog matches at the beginning of s, and returns the number of bytes consumed by the match
+func (prog *byPassProgAnchored) MatchPrefix(s string) (matched bool, n int) {
+	matchBegin, matchEnd := prog.IndexString(s, 0)
+	if matchBegin != 0 {
+		return false, 0
+	}
+	return true, matchEnd
+}
+
 // findCharClass finds the first character in a string that belongs to a byPassOpCharClass
 func findCharClass(s string, step *byPassStep) (foundIndex int, matchingChar rune) {
-	for idx, char := range s {
-		for i := 0; i < len(step.classes); i += 2 {
-			if step.classes[i] <= char && char <= step.classes[i+1] {
-				return idx, char
+	if step.byteSet != nil {
+		// A single lookup per byte, without checking that it is ASCII first
+		for idx := 0; idx < len(s); idx++ {
+			if step.byteSet[s[idx]] {
+				return idx, rune(s[idx])
+			}
+		}
+		return -1, 0
+	}
+	if step.asciiOnly {
+		// Bytes of multi-byte characters are never ASCII, so there is no need to decode them
+		for idx := 0; idx < len(s); idx++ {
+			if char := s[idx]; char < utf8.RuneSelf && step.asciiSet[char>>6]&(1<<(char&63)) != 0 {
+				return idx, rune(char)
 			}
 		}
+		return -1, 0
+	}
+	for idx, char := range s {
+		if matchCharInClasses(char, step) {
+			return idx, char
+		}
 	}
 	foundIndex = -1
 	return
 }
 
+// byPassMaxLinearRanges is the number of ranges of a class scanned linearly. Characters beyond them
+// in larger Unicode classes (`\pL`, `\p{Nd}`) are binary searched.
+const byPassMaxLinearRanges = 8
+
 // matchCharInClasses checks if a character belongs to a byPassOpCharClass
 func matchCharInClasses(char rune, step *byPassStep) (matches bool) {
-	for i := 0; i < len(step.classes); i += 2 {
-		if step.classes[i] <= char && char <= step.classes[i+1] {
+	if step.asciiOnly {
+		return char < utf8.RuneSelf && step.asciiSet[char>>6]&(1<<uint(char&63)) != 0
+	}
+	// Ranges are sorted, so the lowest characters (ASCII digits in `\p{Nd}`) are found or rejected by the
+	// first ones, and only characters beyond them need to be binary searched in large Unicode classes
+	if len(step.classes) > 2*byPassMaxLinearRanges && char > step.classes[2*byPassMaxLinearRanges-1] {
+		return inSortedRanges(char, step.classes)
+	}
+	for i := 0; i < len(step.classes) && step.classes[i] <= char; i += 2 {
+		if char <= step.classes[i+1] {
 			return true
 		}
 	}
 	return false
 }
 
+// inSortedRanges checks if a character belongs to sorted and non-overlapping pairs of rune ranges
+func inSortedRanges(char rune, ranges []rune) bool {
+	// Find the first range ending at or after char
+	low, high := 0, len(ranges)/2
+	for low < high {
+		middle := int(uint(low+high) >> 1)
+		if ranges[2*middle+1] < char {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	return low < len(ranges)/2 && ranges[2*low] <= char
+}
+
+// findCharNotInClasses finds the first character in a string that doesn't belong to the classes of a byPassStep
+func findCharNotInClasses(s string, step *byPassStep) (foundIndex int, matchingChar rune) {
+	for idx, char := range s {
+		if !matchCharInClasses(char, step) {
+			return idx, char
+		}
+	}
+	foundIndex = -1
+	return
+}
+
+// asciiCharsInRanges lists all the characters in pairs of rune ranges,
+// or returns an empty string if some of them are not ASCII
+func asciiCharsInRanges(ranges []rune) string {
+	var chars []byte
+	for i := 0; i < len(ranges); i += 2 {
+		if ranges[i+1] >= utf8.RuneSelf {
+			return ""
+		}
+		for char := ranges[i]; char <= ranges[i+1]; char++ {
+			chars = append(chars, byte(char))
+		}
+	}
+	return string(chars)
+}
+
+// asciiSetOfRanges builds a bitmap of the characters in pairs of rune ranges,
+// or returns ok=false if some of them are not ASCII
+func asciiSetOfRanges(ranges []rune) (set [2]uint64, ok bool) {
+	for i := 0; i < len(ranges); i += 2 {
+		if ranges[i+1] >= utf8.RuneSelf {
+			return set, false
+		}
+		for char := ranges[i]; char <= ranges[i+1]; char++ {
+			set[char>>6] |= 1 << uint(char&63)
+		}
+	}
+	return set, true
+}
+
+// byPassMaxByteSetChars is the maximum number of characters in a class for its byteSet to be built.
+// Larger ASCII classes (`\w`, `[[:print:]]`) already match most bytes quickly with their asciiSet.
+const byPassMaxByteSetChars = 16
+
+// byteSetOfRanges builds a lookup array of the bytes in pairs of ASCII rune ranges,
+// or returns nil if there are more than byPassMaxByteSetChars of them
+func byteSetOfRanges(ranges []rune) *[256]bool {
+	count := 0
+	for i := 0; i < len(ranges); i += 2 {
+		count += int(ranges[i+1]-ranges[i]) + 1
+	}
+	if count > byPassMaxByteSetChars {
+		return nil
+	}
+	set := &[256]bool{}
+	for i := 0; i < len(ranges); i += 2 {
+		for char := ranges[i]; char <= ranges[i+1]; char++ {
+			set[char] = true
+		}
+	}
+	return set
+}
+
+// matchNegativeCharClass checks if a character matches a byPassOpNegativeCharClass
+func matchNegativeCharClass(char rune, step *byPassStep) (matches bool) {
+	if step.classes == nil {
+		return char != step.char
+	}
+	return !matchCharInClasses(char, step)
+}
+
+// foundRuneWidth returns the width of the rune found at the beginning of s. Invalid UTF-8 bytes are
+// decoded as RuneError with a width of 1, while utf8.RuneLen(RuneError) is 3.
+func foundRuneWidth(s string, char rune) int {
+	if char == utf8.RuneError {
+		_, width := utf8.DecodeRuneInString(s)
+		return width
+	}
+	return utf8.RuneLen(char)
+}
+
 // findOtherChar finds the first character in a string that's different than a specific character
 func findOtherChar(s string, char rune) (foundIndex int, matchingChar rune) {
 	for idx, nextChar := range s {
@@ -606,6 +3429,10 @@ func matchStepAnchored(step *byPassStep, s string) (matched bool) {
 
 	case byPassOpCharClass:
 
+		// Repeated single-byte classes (`[0-9]{4}`) must match each of their bytes
+		if step.length > 1 {
+			return matchStepRepeat(step, s)
+		}
 		idx, _ := findCharClass(s, step)
 		if idx == -1 {
 			return false
@@ -613,22 +3440,358 @@ func matchStepAnchored(step *byPassStep, s string) (matched bool) {
 
 	case byPassOpNegativeCharClass:
 
-		if s == step.literal {
+		if step.classes == nil {
+			if s == step.literal {
+				return false
+			}
+		} else if idx, _ := findCharClass(s, step); idx != -1 {
 			return false
 		}
 
 	case byPassOpAnyChar:
 		// nothing to do
 
+	case byPassOpLiteralSet:
+
+		return findLiteralPrefix(s, step) == len(s)
+
+	}
+
+	return true
+}
+
+// findLiteralPrefix returns the width of the literal of a byPassOpLiteralSet that s starts with, or -1 if there is none
+func findLiteralPrefix(s string, step *byPassStep) (width int) {
+	for _, literal := range step.literals {
+		if strings.HasPrefix(s, literal) {
+			return len(literal)
+		}
+	}
+	return -1
+}
+
+// matchStepRepeat checks if all the runes of a string match a single-rune byPassStep
+func matchStepRepeat(step *byPassStep, s string) (matched bool) {
+
+	switch step.op {
+	case byPassOpLiteral:
+
+		// Longer literals (`^(ab)*$`) must be repeated a whole number of times
+		if step.length > 1 {
+			if len(s)%len(step.literal) != 0 {
+				return false
+			}
+			for i := 0; i < len(s); i += len(step.literal) {
+				if s[i:i+len(step.literal)] != step.literal {
+					return false
+				}
+			}
+			return true
+		}
+
+		char, _ := utf8.DecodeRuneInString(step.literal)
+		idx, _ := findOtherChar(s, char)
+		return idx == -1
+
+	case byPassOpCharClass:
+
+		// Bytes of multi-byte runes are never in ASCII classes (`^\d+$`), so there is no need to decode them
+		if step.asciiOnly {
+			for i := 0; i < len(s); i++ {
+				if char := s[i]; char >= utf8.RuneSelf || step.asciiSet[char>>6]&(1<<(char&63)) == 0 {
+					return false
+				}
+			}
+			return true
+		}
+		for _, char := range s {
+			if !matchCharInClasses(char, step) {
+				return false
+			}
+		}
+
+	case byPassOpNegativeCharClass:
+
+		if step.classes == nil {
+			return strings.IndexRune(s, step.char) == -1
+		}
+		// ASCII bytes can't be part of a multi-byte rune, so they can be searched without decoding runes
+		if step.literal != "" {
+			return strings.IndexAny(s, step.literal) == -1
+		}
+		idx, _ := findCharClass(s, step)
+		return idx == -1
+
+	case byPassOpAnyChar:
+		// nothing to do
+
 	}
 
 	return true
 }
 
 func (prog *byPassProgUnanchored) MatchString(s string) (matched bool) {
+	matchBegin, _ := prog.index(s)
+	return matchBegin != -1
+}
+
+func (prog *byPassProgUnanchored) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+	matchBegin, matchEnd = prog.index(s[pos:])
+	if matchBegin == -1 {
+		return -1, -1
+	}
+	return pos + matchBegin, pos + matchEnd
+}
+
+// LastIndexString checks the windows of prog.length runes one by one from the end of s,
+// since the matches have a fixed number of runes
+func (prog *byPassProgUnanchored) LastIndexString(s string) (matchBegin int, matchEnd int) {
+	width := lastRunesWidth(s, prog.length)
+	if width == -1 {
+		return -1, -1
+	}
+	matchBegin, matchEnd = len(s)-width, len(s)
+	for {
+		if begin, end := prog.index(s[matchBegin:matchEnd]); begin == 0 {
+			return matchBegin, matchBegin + end
+		}
+		if matchBegin == 0 {
+			return -1, -1
+		}
+		_, beginWidth := utf8.DecodeLastRuneInString(s[:matchBegin])
+		_, endWidth := utf8.DecodeLastRuneInString(s[:matchEnd])
+		matchBegin, matchEnd = matchBegin-beginWidth, matchEnd-endWidth
+	}
+}
+
+// byPassContextChunk is the number of bytes scanned by MatchStringContext between two checks of the context
+const byPassContextChunk = 64 << 10
+
+// matchStringChunks matches prog against chunks of s overlapping by overlap bytes, and stops with
+// ctx.Err() between them if ctx is done. prog must find its matches in any chunk (isByPassChunkable).
+func matchStringChunks(ctx context.Context, prog byPassProg, overlap int, s string) (matched bool, err error) {
+
+	for begin := 0; ; {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		end := begin + byPassContextChunk + overlap
+		if end >= len(s) {
+			return prog.MatchString(s[begin:]), nil
+		}
+		// Chunks must not cut runes, or their bytes could be matched as RuneError
+		end = runeStartBefore(s, end)
+		if prog.MatchString(s[begin:end]) {
+			return true, nil
+		}
+		begin = runeStartBefore(s, end-overlap)
+	}
+}
+
+// isByPassChunkable returns true if prog matches in chunks of a string overlapping by its maximum
+// width as in the whole string: it must be unanchored, even by a `\b` looking at the next byte
+func isByPassChunkable(prog byPassProg) bool {
+	switch p := prog.(type) {
+	case *byPassProgUnanchored:
+		return true
+	case *byPassProgLiteral:
+		return !p.anchoredBegin && !p.anchoredEnd && !p.wordBoundaryEnd
+	case *byPassProgAlternate:
+		for _, subprog := range p.progs {
+			if !isByPassChunkable(subprog) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// isByPassBounded returns true if prog only looks at a bounded number of bytes of any string,
+// at its beginning or its end (`^abc`, `a.c$`, `^.{3,5}$`)
+func isByPassBounded(prog byPassProg) bool {
+	switch p := prog.(type) {
+	case *byPassProgAnchored:
+		return p.anchoredBegin || p.anchoredEnd
+	case *byPassProgLiteral:
+		return p.anchoredBegin || p.anchoredEnd
+	case *byPassProgLengthRange, *byPassProgSuffixes, *byPassProgPrefixTrie, *byPassProgUnmatchable, *byPassProgEmpty:
+		return true
+	case *byPassProgAlternate:
+		for _, subprog := range p.progs {
+			if !isByPassBounded(subprog) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// contextReader reads the runes of s for the other matchers, and fails with ctx.Err() once ctx
+// is done, which is checked every byPassContextChunk bytes
+type contextReader struct {
+	ctx   context.Context
+	s     string
+	pos   int
+	check int   // position of the next check of ctx
+	err   error // ctx.Err() once it failed
+}
+
+func (r *contextReader) ReadRune() (char rune, size int, err error) {
+	if r.pos >= r.check {
+		if r.err = r.ctx.Err(); r.err != nil {
+			return 0, 0, r.err
+		}
+		r.check = r.pos + byPassContextChunk
+	}
+	if r.pos >= len(r.s) {
+		return 0, 0, io.EOF
+	}
+	char, size = utf8.DecodeRuneInString(r.s[r.pos:])
+	r.pos += size
+	return char, size, nil
+}
+
+// byPassScanChunk is the number of bytes read at once by ScanReader, in addition to the overlap
+const byPassScanChunk = 64 << 10
+
+// scanReader matches prog against the chunks read from r, each of them starting with the
+// last overlap bytes of the previous one
+func scanReader(prog byPassProg, overlap int, r io.Reader) (matched bool, err error) {
+
+	if overlap < 0 {
+		overlap = 0
+	}
+	buf := make([]byte, overlap+byPassScanChunk)
+	kept := 0
+
+	for {
+		n, err := io.ReadFull(r, buf[kept:])
+		s := bytesToString(buf[:kept+n])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return prog.MatchString(s), nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		// A rune cut at the end of the buffer would be matched as RuneError: it is scanned with the next chunk
+		end := runeStartBefore(s, len(s)-1)
+		if utf8.FullRuneInString(s[end:]) {
+			end = len(s)
+		}
+		if prog.MatchString(s[:end]) {
+			return true, nil
+		}
+
+		begin := end - overlap
+		if begin < 0 {
+			begin = 0
+		} else if begin < len(s) {
+			begin = runeStartBefore(s, begin)
+		}
+		kept = copy(buf, s[begin:])
+	}
+}
+
+// runeStartBefore returns the byte offset of the rune containing s[i]
+func runeStartBefore(s string, i int) int {
+	for j := i; j >= 0 && j > i-utf8.UTFMax; j-- {
+		if utf8.RuneStart(s[j]) {
+			return j
+		}
+	}
+	return i
+}
+
+func (prog *byPassProgUnanchored) ReaderIndex(r io.RuneReader) (matchBegin int, matchEnd int) {
+
+	if prog.length == 0 {
+		return 0, 0
+	}
+
+	// As the pattern is fixed-length, a match can only be in the window of the last prog.length runes.
+	// Both are circular buffers indexed by the number of runes read so far.
+	window := make([]rune, prog.length)
+	offsets := make([]int, prog.length)
+
+	pos := 0
+	for n := 0; ; n++ {
+		char, size, err := r.ReadRune()
+		if err != nil {
+			return -1, -1
+		}
+		window[n%prog.length] = char
+		offsets[n%prog.length] = pos
+		pos += size
+
+		if n+1 >= prog.length && prog.matchWindow(window, n+1) {
+			return offsets[(n+1)%prog.length], pos
+		}
+	}
+}
+
+// matchWindow checks the circular window of runes, starting at the oldest one
+func (prog *byPassProgUnanchored) matchWindow(window []rune, first int) (matched bool) {
+	i := first
+	for _, step := range prog.steps {
+		switch step.op {
+		case byPassOpLiteral:
+			for _, char := range step.literal {
+				if window[i%prog.length] != char {
+					return false
+				}
+				i++
+			}
+			continue
+		case byPassOpCharClass:
+			for j := i; j < i+step.length; j++ {
+				if !matchCharInClasses(window[j%prog.length], step) {
+					return false
+				}
+			}
+		case byPassOpNegativeCharClass:
+			if !matchNegativeCharClass(window[i%prog.length], step) {
+				return false
+			}
+		case byPassOpLiteralSet:
+			if !matchWindowLiterals(window, i, step.literals) {
+				return false
+			}
+		}
+		i += step.length
+	}
+	return true
+}
+
+// matchWindowLiterals checks if one of the literals is in the circular window of runes, starting at i
+func matchWindowLiterals(window []rune, i int, literals []string) (matched bool) {
+	for _, literal := range literals {
+		j := i
+		matched = true
+		for _, char := range literal {
+			if window[j%len(window)] != char {
+				matched = false
+				break
+			}
+			j++
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// index returns the byte offsets of the leftmost match in s, or -1 if there is none
+func (prog *byPassProgUnanchored) index(s string) (matchBegin int, matchEnd int) {
 
 	if len(s) < prog.minWidth {
-		return false
+		if byPassStatsEnabled && prog.stats != nil {
+			atomic.AddUint64(&prog.stats.earlyRejections, 1)
+		}
+		return -1, -1
 	}
 
 	var nextRune rune
@@ -649,7 +3812,7 @@ byPassUnanchoredRestart:
 
 		// Do we already know we wont have enough bytes to match the rest of the pattern?
 		if begin+step.minNextWidth > len(s) {
-			return false
+			return -1, -1
 		}
 
 		if step.op != byPassOpLiteral {
@@ -668,11 +3831,11 @@ byPassUnanchoredRestart:
 			switch idx {
 			case -1:
 				// Not found at all
-				return false
+				return -1, -1
 			case 0:
 				// Found in the right place
 				if stepn == 0 {
-					firstRuneWidth = step.minWidth
+					_, firstRuneWidth = utf8.DecodeRuneInString(step.literal)
 				}
 				begin += step.minWidth
 			default:
@@ -680,12 +3843,13 @@ byPassUnanchoredRestart:
 				if stepn == 0 {
 					cursor = begin + idx
 					begin += idx + step.minWidth
-					firstRuneWidth = step.minWidth
+					_, firstRuneWidth = utf8.DecodeRuneInString(step.literal)
 				} else {
 
 					if idx == 1 {
-						// In this special case we know the next rune had a width of 1 byte
-						cursor += 1
+						// In this special case we know the skipped rune had a width of 1 byte, so the
+						// window of the previous steps moves by a single rune, which may be multi-byte (`..☺`)
+						cursor += firstRuneWidth
 					} else {
 						// TODO: the call to lastRunesWidth could be avoided in some cases
 						cursor = begin + idx - lastRunesWidth(s[cursor:begin+idx], step.previousLength)
@@ -696,16 +3860,22 @@ byPassUnanchoredRestart:
 
 		case byPassOpNegativeCharClass:
 
-			if nextRune != step.char {
+			if matchNegativeCharClass(nextRune, step) {
 				begin += nextWidth
 			} else if stepn == 0 {
 
-				idx, char := findOtherChar(s[begin+nextWidth:], step.char)
+				var idx int
+				var char rune
+				if step.classes == nil {
+					idx, char = findOtherChar(s[begin+nextWidth:], step.char)
+				} else {
+					idx, char = findCharNotInClasses(s[begin+nextWidth:], step)
+				}
 				if idx == -1 {
-					return false
+					return -1, -1
 				}
-				firstRuneWidth = utf8.RuneLen(char)
 				cursor = begin + nextWidth + idx
+				firstRuneWidth = foundRuneWidth(s[cursor:], char)
 				begin += nextWidth + idx + firstRuneWidth
 
 			} else {
@@ -721,10 +3891,10 @@ byPassUnanchoredRestart:
 				idx, matchingChar := findCharClass(s[begin+nextWidth:], step)
 
 				if idx == -1 {
-					return false
+					return -1, -1
 				}
-				firstRuneWidth = utf8.RuneLen(matchingChar)
 				cursor = begin + nextWidth + idx
+				firstRuneWidth = foundRuneWidth(s[cursor:], matchingChar)
 				begin += nextWidth + idx + firstRuneWidth
 
 			} else {
@@ -732,12 +3902,30 @@ byPassUnanchoredRestart:
 				goto byPassUnanchoredRestart
 			}
 
+			// The other bytes of a repeated single-byte class (`[0-9]{4}`) are checked at once
+			if step.length > 1 {
+				if begin+step.length-1 > len(s) || !matchStepRepeat(step, s[begin:begin+step.length-1]) {
+					cursor += firstRuneWidth
+					goto byPassUnanchoredRestart
+				}
+				begin += step.length - 1
+			}
+
 		case byPassOpAnyChar:
 
 			begin += nextWidth
+
+		case byPassOpLiteralSet:
+
+			if width := findLiteralPrefix(s[begin:], step); width != -1 {
+				begin += width
+			} else {
+				cursor += firstRuneWidth
+				goto byPassUnanchoredRestart
+			}
 		}
 	}
 
-	return true
+	return cursor, begin
 
 }
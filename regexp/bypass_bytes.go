@@ -0,0 +1,130 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"errors"
+	"regexp/syntax"
+)
+
+// byPassProgBytes can match fixed-length patterns compiled in byte mode (e.g. `^\x7fELF`).
+// It never decodes runes: each byte of the input is checked against the set of bytes allowed at its position.
+type byPassProgBytes struct {
+	sets          [][256]bool // one set of allowed bytes for each byte of the pattern
+	anchoredBegin bool
+	anchoredEnd   bool
+}
+
+// CompileByteMode is like Compile, but the bypass matcher treats the input as bytes
+// instead of UTF-8 text: `.` and character classes match a single byte, including
+// invalid UTF-8, and `\xff` matches the byte 0xff instead of the rune U+00FF.
+//
+// Only fixed-length patterns supported by the bypass matcher can be compiled in byte
+// mode, and their literals must not contain characters above `\xff`. The other matchers
+// decode the input as UTF-8, so the returned ByPassMatcher only has the methods that
+// the bypass matcher executes.
+func CompileByteMode(expr string) (*ByPassMatcher, error) {
+	re, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	tree = tree.Simplify()
+
+	prog := &byPassProgAnchored{}
+	if prog.traverseTree(tree) {
+		return nil, errors.New("regexp: " + quote(expr) + " can't be compiled in byte mode: " + explainByPassBailout(tree))
+	}
+	if prog.unmatchable {
+		re.setByPass(&byPassProgUnmatchable{})
+		return &ByPassMatcher{re: re}, nil
+	}
+
+	bytesprog := &byPassProgBytes{
+		anchoredBegin: prog.anchoredBegin,
+		anchoredEnd:   prog.anchoredEnd,
+	}
+	for _, step := range prog.steps {
+		switch step.op {
+		case byPassOpLiteral:
+			for _, char := range step.literal {
+				if char > 0xff {
+					return nil, errors.New("regexp: " + quote(expr) + " can't be compiled in byte mode: `" + string(char) + "` is not a byte")
+				}
+				var set [256]bool
+				set[char] = true
+				bytesprog.sets = append(bytesprog.sets, set)
+			}
+		case byPassOpCharClass, byPassOpNegativeCharClass, byPassOpAnyChar:
+			var set [256]bool
+			for char := range set {
+				set[char] = matchByteInStep(rune(char), step)
+			}
+			for i := 0; i < step.length; i++ {
+				bytesprog.sets = append(bytesprog.sets, set)
+			}
+		default:
+			return nil, errors.New("regexp: " + quote(expr) + " can't be compiled in byte mode: unsupported " + step.op.String())
+		}
+	}
+
+	re.setByPass(bytesprog)
+	return &ByPassMatcher{re: re}, nil
+}
+
+// matchByteInStep checks if a byte, as a rune up to 0xff, matches a single-rune byPassStep
+func matchByteInStep(char rune, step *byPassStep) (matched bool) {
+	switch step.op {
+	case byPassOpCharClass:
+		return matchCharInClasses(char, step)
+	case byPassOpNegativeCharClass:
+		return matchNegativeCharClass(char, step)
+	}
+	return true
+}
+
+// matchAt checks the bytes of s starting at begin, which must have enough bytes left for the pattern
+func (prog *byPassProgBytes) matchAt(s string, begin int) (matched bool) {
+	for i, set := range prog.sets {
+		if !set[s[begin+i]] {
+			return false
+		}
+	}
+	return true
+}
+
+func (prog *byPassProgBytes) MatchString(s string) (matched bool) {
+	matchBegin, _ := prog.IndexString(s, 0)
+	return matchBegin != -1
+}
+
+func (prog *byPassProgBytes) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+
+	length := len(prog.sets)
+
+	switch {
+	case prog.anchoredBegin:
+		if pos > 0 || len(s) < length || prog.anchoredEnd && len(s) != length || !prog.matchAt(s, 0) {
+			return -1, -1
+		}
+		return 0, length
+	case prog.anchoredEnd:
+		matchBegin = len(s) - length
+		if matchBegin < pos || !prog.matchAt(s, matchBegin) {
+			return -1, -1
+		}
+		return matchBegin, len(s)
+	}
+
+	for matchBegin = pos; matchBegin+length <= len(s); matchBegin++ {
+		if prog.matchAt(s, matchBegin) {
+			return matchBegin, matchBegin + length
+		}
+	}
+	return -1, -1
+}
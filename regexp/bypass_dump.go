@@ -0,0 +1,163 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DumpByPass returns a readable description of the bypass program compiled for re,
+// with one line for each prog and each of its steps. It returns an empty string if re
+// isn't handled by the bypass matcher. It is only meant to help optimizing patterns.
+func (re *Regexp) DumpByPass() string {
+	if re.bypass == notByPass {
+		return ""
+	}
+	var b strings.Builder
+	dumpByPassProg(&b, re.bypass, "")
+	return b.String()
+}
+
+func (op byPassOp) String() string {
+	switch op {
+	case byPassOpLiteral:
+		return "Literal"
+	case byPassOpCharClass:
+		return "CharClass"
+	case byPassOpNegativeCharClass:
+		return "NegativeCharClass"
+	case byPassOpAnyChar:
+		return "AnyChar"
+	case byPassOpLiteralSet:
+		return "LiteralSet"
+	}
+	return fmt.Sprintf("byPassOp(%d)", uint8(op))
+}
+
+func (step *byPassStep) String() string {
+	var b strings.Builder
+	b.WriteString(step.op.String())
+	switch {
+	case step.op == byPassOpLiteral:
+		fmt.Fprintf(&b, " %q", step.literal)
+	case step.op == byPassOpNegativeCharClass && step.classes == nil:
+		fmt.Fprintf(&b, " %q", step.char)
+	case step.classes != nil:
+		fmt.Fprintf(&b, " %q", step.classes)
+	case step.literals != nil:
+		fmt.Fprintf(&b, " %q", step.literals)
+	}
+	fmt.Fprintf(&b, " length=%d previousLength=%d minWidth=%d maxWidth=%d minNextWidth=%d",
+		step.length, step.previousLength, step.minWidth, step.maxWidth, step.minNextWidth)
+	if step.anchored {
+		fmt.Fprintf(&b, " anchorIndex=%d", step.anchorIndex)
+	}
+	return b.String()
+}
+
+// dumpByPassProg writes prog and its sub-progs to b, indented by indent
+func dumpByPassProg(b *strings.Builder, prog byPassProg, indent string) {
+	switch p := prog.(type) {
+	case *byPassProgAnchored:
+		fmt.Fprintf(b, "%sAnchored begin=%t end=%t length=%d minWidth=%d maxWidth=%d\n",
+			indent, p.anchoredBegin, p.anchoredEnd, p.length, p.minWidth, p.maxWidth)
+		dumpByPassSteps(b, p.steps, indent+"  ")
+		for _, capture := range p.captures {
+			fmt.Fprintf(b, "%s  Capture %d begin=%d end=%d\n", indent, capture.index, capture.begin, capture.end)
+		}
+	case *byPassProgUnanchored:
+		fmt.Fprintf(b, "%sUnanchored length=%d minWidth=%d maxWidth=%d\n",
+			indent, p.length, p.minWidth, p.maxWidth)
+		dumpByPassSteps(b, p.steps, indent+"  ")
+	case *byPassProgLiteral:
+		fmt.Fprintf(b, "%sLiteral %q begin=%t end=%t wordBoundaryEnd=%t\n", indent, p.literal, p.anchoredBegin, p.anchoredEnd, p.wordBoundaryEnd)
+	case *byPassProgLengthRange:
+		fmt.Fprintf(b, "%sLengthRange min=%d max=%d matchNL=%t\n", indent, p.minLength, p.maxLength, p.matchNL)
+	case *byPassProgAlternate:
+		if p.byWidth != nil {
+			fmt.Fprintf(b, "%sAlternate byWidth=%d anyWidth=%d\n", indent, len(p.byWidth), len(p.anyWidth))
+		} else {
+			fmt.Fprintf(b, "%sAlternate\n", indent)
+		}
+		for _, subprog := range p.progs {
+			dumpByPassProg(b, subprog, indent+"  ")
+		}
+	case *byPassProgResidual:
+		fmt.Fprintf(b, "%sResidual regexp=`%s`\n", indent, p.regexp)
+	case *byPassProgPrefixAlternate:
+		if p.byWidth != nil {
+			fmt.Fprintf(b, "%sPrefixAlternate byWidth=%d anyWidth=%d\n", indent, len(p.byWidth), len(p.anyWidth))
+		} else {
+			fmt.Fprintf(b, "%sPrefixAlternate\n", indent)
+		}
+		dumpByPassProg(b, p.prefixProg, indent+"  ")
+		for _, subprog := range p.progs {
+			dumpByPassProg(b, subprog, indent+"  ")
+		}
+	case *byPassProgFirstPass:
+		fmt.Fprintf(b, "%sFirstPass regexp=`%s` dotStarRest=%t\n", indent, p.regexp, p.dotStarRest)
+		if p.prefixProg != nil {
+			dumpByPassProg(b, p.prefixProg, indent+"  ")
+		}
+		if p.suffixProg != nil {
+			dumpByPassProg(b, p.suffixProg, indent+"  ")
+		}
+	case *byPassProgPlus:
+		fmt.Fprintf(b, "%sPlus capture=%d star=%t", indent, p.capture, p.star)
+		if p.minCount > 1 || p.maxCount > 0 {
+			fmt.Fprintf(b, " min=%d max=%d", p.minCount, p.maxCount)
+		}
+		if p.openEnd {
+			b.WriteString(" openEnd=true")
+		}
+		fmt.Fprintf(b, " unanchored=%t\n", p.find != nil)
+		if p.prefixProg != nil {
+			dumpByPassProg(b, p.prefixProg, indent+"  ")
+		}
+		fmt.Fprintf(b, "%s  Repeat %s\n", indent, p.step)
+		if p.suffixProg != nil {
+			dumpByPassProg(b, p.suffixProg, indent+"  ")
+		}
+	case *byPassProgDotStarSuffix:
+		fmt.Fprintf(b, "%sDotStarSuffix begin=%t matchNL=%t\n", indent, p.anchoredBegin, p.matchNL)
+		if p.step != nil {
+			fmt.Fprintf(b, "%s  Repeat %s\n", indent, p.step)
+		}
+		dumpByPassProg(b, p.suffixProg, indent+"  ")
+	case *byPassProgPrefixDotStar:
+		fmt.Fprintf(b, "%sPrefixDotStar matchNL=%t end=%t\n", indent, p.matchNL, p.anchoredEnd)
+		dumpByPassProg(b, p.prefixProg, indent+"  ")
+		if restProg, ok := p.restProg.(byPassProg); ok {
+			dumpByPassProg(b, restProg, indent+"  ")
+		}
+	case *byPassProgContains:
+		fmt.Fprintf(b, "%sContains matchNL=%t\n", indent, p.matchNL)
+		if coreProg, ok := p.coreProg.(byPassProg); ok {
+			dumpByPassProg(b, coreProg, indent+"  ")
+		}
+	case *byPassProgBytes:
+		fmt.Fprintf(b, "%sBytes begin=%t end=%t length=%d\n", indent, p.anchoredBegin, p.anchoredEnd, len(p.sets))
+	case *byPassProgPrefixTrie:
+		fmt.Fprintf(b, "%sPrefixTrie count=%d maxWidth=%d end=%t\n", indent, p.count, p.maxWidth, p.anchoredEnd)
+	case *byPassProgSuffixes:
+		fmt.Fprintf(b, "%sSuffixes count=%d maxWidth=%d\n", indent, p.count, p.maxWidth)
+	case *byPassProgEmpty:
+		fmt.Fprintf(b, "%sEmpty begin=%t end=%t\n", indent, p.anchoredBegin, p.anchoredEnd)
+	case *byPassProgValidUTF8:
+		fmt.Fprintf(b, "%sValidUTF8\n", indent)
+		dumpByPassProg(b, p.prog, indent+"  ")
+	case *byPassProgUnmatchable:
+		fmt.Fprintf(b, "%sUnmatchable\n", indent)
+	default:
+		fmt.Fprintf(b, "%s%T\n", indent, prog)
+	}
+}
+
+func dumpByPassSteps(b *strings.Builder, steps []*byPassStep, indent string) {
+	for _, step := range steps {
+		fmt.Fprintf(b, "%s%s\n", indent, step)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import "strconv"
+
+// ByPassKind is the kind of bypass program a Regexp is executed with.
+type ByPassKind int
+
+const (
+	ByPassNone            ByPassKind = iota // not executed by the bypass matcher
+	ByPassAnchored                          // fixed-length anchored pattern (`^a[^b]`)
+	ByPassUnanchored                        // fixed-length unanchored pattern (`a.b`)
+	ByPassLiteral                           // literal, maybe anchored (`^ab`)
+	ByPassLengthRange                       // runs of `.` anchored on both ends (`^.{3,5}$`)
+	ByPassAlternate                         // top-level alternation (`jpg|png`)
+	ByPassPrefixAlternate                   // anchored alternation with a shared prefix (`^abc(?:1|22)$`)
+	ByPassFirstPass                         // fixed-length prefix or suffix checked first (`(a*)bb$`)
+	ByPassPlus                              // `class+` between a prefix and a suffix (`^/users/([^/]+)$`)
+	ByPassDotStarSuffix                     // leading `.*` and a suffix (`.*foo$`)
+	ByPassPrefixDotStar                     // prefix followed by `.*` (`^abc.*xyz`)
+	ByPassBytes                             // fixed-length pattern compiled by CompileByteMode
+	ByPassUnmatchable                       // pattern that can never match (`a^b`)
+	ByPassSuffixes                          // set of literal suffixes compiled by CompileSuffixes
+	ByPassPrefixTrie                        // anchored alternation of literals (`^(?:GET|POST) `)
+	ByPassContains                          // fixed-length pattern between two `.*` (`.*foo.*`)
+	ByPassEmpty                             // pattern only matching empty strings (`^$`)
+	ByPassValidUTF8                         // fixed-length pattern compiled by CompileStrictUTF8
+)
+
+var byPassKindNames = []string{
+	ByPassNone:            "None",
+	ByPassAnchored:        "Anchored",
+	ByPassUnanchored:      "Unanchored",
+	ByPassLiteral:         "Literal",
+	ByPassLengthRange:     "LengthRange",
+	ByPassAlternate:       "Alternate",
+	ByPassPrefixAlternate: "PrefixAlternate",
+	ByPassFirstPass:       "FirstPass",
+	ByPassPlus:            "Plus",
+	ByPassDotStarSuffix:   "DotStarSuffix",
+	ByPassPrefixDotStar:   "PrefixDotStar",
+	ByPassBytes:           "Bytes",
+	ByPassUnmatchable:     "Unmatchable",
+	ByPassSuffixes:        "Suffixes",
+	ByPassPrefixTrie:      "PrefixTrie",
+	ByPassContains:        "Contains",
+	ByPassEmpty:           "Empty",
+	ByPassValidUTF8:       "ValidUTF8",
+}
+
+func (kind ByPassKind) String() string {
+	if kind < 0 || int(kind) >= len(byPassKindNames) {
+		return "ByPassKind(" + strconv.Itoa(int(kind)) + ")"
+	}
+	return byPassKindNames[kind]
+}
+
+// ByPassKind returns the kind of bypass program re is executed with,
+// or ByPassNone if it is executed by the standard matchers.
+func (re *Regexp) ByPassKind() ByPassKind {
+	switch re.bypass.(type) {
+	case *byPassProgAnchored:
+		return ByPassAnchored
+	case *byPassProgUnanchored:
+		return ByPassUnanchored
+	case *byPassProgLiteral:
+		return ByPassLiteral
+	case *byPassProgLengthRange:
+		return ByPassLengthRange
+	case *byPassProgAlternate:
+		return ByPassAlternate
+	case *byPassProgPrefixAlternate:
+		return ByPassPrefixAlternate
+	case *byPassProgFirstPass:
+		return ByPassFirstPass
+	case *byPassProgPlus:
+		return ByPassPlus
+	case *byPassProgDotStarSuffix:
+		return ByPassDotStarSuffix
+	case *byPassProgPrefixDotStar:
+		return ByPassPrefixDotStar
+	case *byPassProgBytes:
+		return ByPassBytes
+	case *byPassProgUnmatchable:
+		return ByPassUnmatchable
+	case *byPassProgSuffixes:
+		return ByPassSuffixes
+	case *byPassProgPrefixTrie:
+		return ByPassPrefixTrie
+	case *byPassProgContains:
+		return ByPassContains
+	case *byPassProgEmpty:
+		return ByPassEmpty
+	case *byPassProgValidUTF8:
+		return ByPassValidUTF8
+	}
+	return ByPassNone
+}
+
+// CompileAll compiles each of the patterns and returns parallel slices with the
+// Regexps, the kind of bypass program each of them is executed with, and the
+// compilation errors. It lets tools ingesting many patterns audit their bypass
+// coverage. The Regexp of a pattern that doesn't compile is nil, with ByPassNone.
+func CompileAll(patterns []string) ([]*Regexp, []ByPassKind, []error) {
+	regexps := make([]*Regexp, len(patterns))
+	kinds := make([]ByPassKind, len(patterns))
+	errs := make([]error, len(patterns))
+	for i, pattern := range patterns {
+		regexps[i], errs[i] = Compile(pattern)
+		if errs[i] == nil {
+			kinds[i] = regexps[i].ByPassKind()
+		}
+	}
+	return regexps, kinds, errs
+}
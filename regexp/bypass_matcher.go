@@ -0,0 +1,148 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+// ByPassMatcher is a regular expression compiled in a mode that only the bypass
+// matcher implements: the byte mode of CompileByteMode, the strict UTF-8 mode of
+// CompileStrictUTF8 or the custom word characters of CompileWordChars. Unlike a
+// Regexp, it only has the methods that the bypass matcher can execute on its own,
+// so that all of them follow the mode. Submatches aren't available.
+type ByPassMatcher struct {
+	re *Regexp
+}
+
+// String returns the source text used to compile the regular expression.
+func (m *ByPassMatcher) String() string {
+	return m.re.String()
+}
+
+// ByPassKind returns the kind of bypass program m is executed with.
+func (m *ByPassMatcher) ByPassKind() ByPassKind {
+	return m.re.ByPassKind()
+}
+
+// MatchString reports whether the string s contains any match of m.
+func (m *ByPassMatcher) MatchString(s string) bool {
+	return m.re.MatchString(s)
+}
+
+// Match reports whether the byte slice b contains any match of m.
+func (m *ByPassMatcher) Match(b []byte) bool {
+	return m.re.Match(b)
+}
+
+// MatchStrings reports, for each string in inputs, whether it contains any match of m.
+func (m *ByPassMatcher) MatchStrings(inputs []string) []bool {
+	return m.re.MatchStrings(inputs)
+}
+
+// FindStringIndex returns a two-element slice of integers defining the location of
+// the leftmost match in s of m, like Regexp.FindStringIndex. A return value of nil
+// indicates no match.
+func (m *ByPassMatcher) FindStringIndex(s string) (loc []int) {
+	return m.index(s, nil)
+}
+
+// FindIndex returns a two-element slice of integers defining the location of the
+// leftmost match in b of m, like Regexp.FindIndex. A return value of nil indicates
+// no match.
+func (m *ByPassMatcher) FindIndex(b []byte) (loc []int) {
+	return m.index("", b)
+}
+
+// FindAllStringIndex is the 'All' version of FindStringIndex, like
+// Regexp.FindAllStringIndex. A return value of nil indicates no match.
+func (m *ByPassMatcher) FindAllStringIndex(s string, n int) [][]int {
+	return m.allIndex(s, nil, n)
+}
+
+// FindAllIndex is the 'All' version of FindIndex, like Regexp.FindAllIndex.
+// A return value of nil indicates no match.
+func (m *ByPassMatcher) FindAllIndex(b []byte, n int) [][]int {
+	return m.allIndex("", b, n)
+}
+
+// ReplaceAllLiteralString returns a copy of src, replacing matches of m with
+// the replacement string repl. The replacement repl is substituted directly,
+// without using Expand.
+func (m *ByPassMatcher) ReplaceAllLiteralString(src, repl string) string {
+	return string(m.replaceAll(nil, src, func(dst []byte, match []int) []byte {
+		return append(dst, repl...)
+	}))
+}
+
+// ReplaceAllStringFunc returns a copy of src in which all matches of m have been
+// replaced by the return value of function repl applied to the matched substring.
+func (m *ByPassMatcher) ReplaceAllStringFunc(src string, repl func(string) string) string {
+	return string(m.replaceAll(nil, src, func(dst []byte, match []int) []byte {
+		return append(dst, repl(src[match[0]:match[1]])...)
+	}))
+}
+
+// ReplaceAllLiteral returns a copy of src, replacing matches of m with the
+// replacement bytes repl. The replacement repl is substituted directly, without
+// using Expand.
+func (m *ByPassMatcher) ReplaceAllLiteral(src, repl []byte) []byte {
+	return m.replaceAll(src, "", func(dst []byte, match []int) []byte {
+		return append(dst, repl...)
+	})
+}
+
+// ReplaceAllFunc returns a copy of src in which all matches of m have been
+// replaced by the return value of function repl applied to the matched byte slice.
+func (m *ByPassMatcher) ReplaceAllFunc(src []byte, repl func([]byte) []byte) []byte {
+	return m.replaceAll(src, "", func(dst []byte, match []int) []byte {
+		return append(dst, repl(src[match[0]:match[1]])...)
+	})
+}
+
+// index locates the leftmost match in b if b is non-nil, otherwise in s. The
+// other matchers are only used for patterns that the mode doesn't change.
+func (m *ByPassMatcher) index(s string, b []byte) []int {
+	if m.re.bypassIndex != nil {
+		return m.re.doExecuteByPass(b, s, 0, nil)
+	}
+	return m.re.doExecute(nil, b, s, 0, 2, nil)
+}
+
+// allIndex locates the successive matches in b if b is non-nil, otherwise in s
+func (m *ByPassMatcher) allIndex(s string, b []byte, n int) [][]int {
+	if n < 0 {
+		n = len(s) + len(b) + 1
+	}
+	var result [][]int
+	it := m.re.newMatchIterator(s, b, 2)
+	for len(result) < n {
+		match := it.next(nil)
+		if match == nil {
+			break
+		}
+		result = append(result, match)
+	}
+	return result
+}
+
+// replaceAll returns a copy of bsrc if it is non-nil, otherwise of src, with the
+// matches of allIndex replaced by repl. Unlike Regexp.replaceAll, it doesn't step
+// over whole runes, which would skip the matches ending inside a rune in byte mode.
+func (m *ByPassMatcher) replaceAll(bsrc []byte, src string, repl func(dst []byte, match []int) []byte) []byte {
+	var buf []byte
+	var dstCap [2]int
+	lastMatchEnd := 0
+	it := m.re.newMatchIterator(src, bsrc, 2)
+	for match := it.next(dstCap[:0]); match != nil; match = it.next(dstCap[:0]) {
+		if bsrc != nil {
+			buf = append(buf, bsrc[lastMatchEnd:match[0]]...)
+		} else {
+			buf = append(buf, src[lastMatchEnd:match[0]]...)
+		}
+		buf = repl(buf, match)
+		lastMatchEnd = match[1]
+	}
+	if bsrc != nil {
+		return append(buf, bsrc[lastMatchEnd:]...)
+	}
+	return append(buf, src[lastMatchEnd:]...)
+}
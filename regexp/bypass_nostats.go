@@ -0,0 +1,11 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !bypassstats
+// +build !bypassstats
+
+package regexp
+
+// byPassStatsEnabled is false by default so that the counters don't slow down the hot path.
+const byPassStatsEnabled = false
@@ -0,0 +1,155 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"unsafe"
+)
+
+// ByPassSize returns an estimate of the number of bytes used by the bypass program
+// compiled for re: its steps, character classes, literals, bitmaps and tries. The
+// standard matchers' program isn't included. It returns 0 if re isn't handled by the
+// bypass matcher.
+//
+// It can help to detect patterns whose `?` and bounded repeats were expanded to
+// many alternatives.
+func (re *Regexp) ByPassSize() int {
+	if re.bypass == notByPass {
+		return 0
+	}
+	// the stats are shared by all the sub-progs, so they are counted once here
+	return byPassProgSize(re.bypass) + byPassStatsSize(re.stats)
+}
+
+// byPassProgSize returns the number of bytes used by prog and its sub-progs
+func byPassProgSize(prog byPassProg) int {
+	switch p := prog.(type) {
+	case *byPassProgAnchored:
+		return int(unsafe.Sizeof(*p)) + byPassStepsSize(p.steps) + cap(p.asciiBytes)*int(unsafe.Sizeof([2]uint64{})) +
+			cap(p.captures)*int(unsafe.Sizeof(byPassCapture{}))
+	case *byPassProgUnanchored:
+		return int(unsafe.Sizeof(*p)) + byPassStepsSize(p.steps)
+	case *byPassProgLiteral:
+		return int(unsafe.Sizeof(*p)) + len(p.literal) + len(p.runes)*int(unsafe.Sizeof(rune(0)))
+	case *byPassProgLengthRange:
+		return int(unsafe.Sizeof(*p))
+	case *byPassProgAlternate:
+		size := int(unsafe.Sizeof(*p)) + cap(p.progs)*int(unsafe.Sizeof(prog))
+		for _, subprog := range p.progs {
+			size += byPassProgSize(subprog)
+		}
+		// byWidth and anyWidth only hold references to the progs counted above
+		for _, progs := range p.byWidth {
+			size += int(unsafe.Sizeof(0)) + cap(progs)*int(unsafe.Sizeof(prog))
+		}
+		return size + cap(p.anyWidth)*int(unsafe.Sizeof(prog))
+	case *byPassProgResidual:
+		return int(unsafe.Sizeof(*p)) + p.regexp.ByPassSize()
+	case *byPassProgPrefixAlternate:
+		size := int(unsafe.Sizeof(*p)) + byPassProgSize(p.prefixProg) + cap(p.progs)*int(unsafe.Sizeof(p.prefixProg))
+		for _, subprog := range p.progs {
+			size += byPassProgSize(subprog)
+		}
+		// byWidth and anyWidth only hold references to the progs counted above
+		for _, progs := range p.byWidth {
+			size += int(unsafe.Sizeof(0)) + cap(progs)*int(unsafe.Sizeof(p.prefixProg))
+		}
+		return size + cap(p.anyWidth)*int(unsafe.Sizeof(p.prefixProg))
+	case *byPassProgFirstPass:
+		size := int(unsafe.Sizeof(*p))
+		if p.regexp != nil {
+			size += p.regexp.ByPassSize()
+		}
+		if p.prefixProg != nil {
+			size += byPassProgSize(p.prefixProg)
+		}
+		if p.suffixProg != nil {
+			size += byPassProgSize(p.suffixProg)
+		}
+		return size
+	case *byPassProgPlus:
+		size := int(unsafe.Sizeof(*p)) + byPassStepSize(p.step)
+		if p.prefixProg != nil {
+			size += byPassProgSize(p.prefixProg)
+		}
+		if p.suffixProg != nil {
+			size += byPassProgSize(p.suffixProg)
+		}
+		if p.find != nil {
+			size += byPassProgSize(p.find)
+		}
+		return size
+	case *byPassProgDotStarSuffix:
+		size := int(unsafe.Sizeof(*p)) + byPassProgSize(p.suffixProg)
+		if p.step != nil {
+			size += byPassStepSize(p.step)
+		}
+		return size
+	case *byPassProgPrefixDotStar:
+		size := int(unsafe.Sizeof(*p)) + byPassProgSize(p.prefixProg)
+		if restProg, ok := p.restProg.(byPassProg); ok {
+			size += byPassProgSize(restProg)
+		}
+		return size
+	case *byPassProgContains:
+		size := int(unsafe.Sizeof(*p))
+		if coreProg, ok := p.coreProg.(byPassProg); ok {
+			size += byPassProgSize(coreProg)
+		}
+		return size
+	case *byPassProgBytes:
+		return int(unsafe.Sizeof(*p)) + cap(p.sets)*int(unsafe.Sizeof([256]bool{}))
+	case *byPassProgPrefixTrie:
+		return int(unsafe.Sizeof(*p)) + cap(p.lengths)*int(unsafe.Sizeof(0)) + byPassTrieSize(p.root)
+	case *byPassProgSuffixes:
+		return int(unsafe.Sizeof(*p)) + byPassTrieSize(p.root)
+	case *byPassProgEmpty:
+		return int(unsafe.Sizeof(*p))
+	case *byPassProgValidUTF8:
+		return int(unsafe.Sizeof(*p)) + byPassProgSize(p.prog)
+	case *byPassProgUnmatchable:
+		return int(unsafe.Sizeof(*p))
+	}
+	return 0
+}
+
+// byPassStepsSize returns the number of bytes used by steps, including the slice itself
+func byPassStepsSize(steps []*byPassStep) int {
+	size := cap(steps) * int(unsafe.Sizeof((*byPassStep)(nil)))
+	for _, step := range steps {
+		size += byPassStepSize(step)
+	}
+	return size
+}
+
+// byPassStepSize returns the number of bytes used by step and its classes, literals and byteSet
+func byPassStepSize(step *byPassStep) int {
+	size := int(unsafe.Sizeof(*step)) + cap(step.classes)*int(unsafe.Sizeof(rune(0))) + len(step.literal)
+	size += cap(step.literals) * int(unsafe.Sizeof(""))
+	for _, literal := range step.literals {
+		size += len(literal)
+	}
+	if step.byteSet != nil {
+		size += int(unsafe.Sizeof(*step.byteSet))
+	}
+	return size
+}
+
+// byPassTrieSize returns the number of bytes used by node and its descendants
+func byPassTrieSize(node *byPassTrieNode) int {
+	size := int(unsafe.Sizeof(*node)) + cap(node.labels) + cap(node.children)*int(unsafe.Sizeof(node))
+	for _, child := range node.children {
+		size += byPassTrieSize(child)
+	}
+	return size
+}
+
+// byPassStatsSize returns the number of bytes used by stats, if enabled
+func byPassStatsSize(stats *byPassStats) int {
+	if stats == nil {
+		return 0
+	}
+	return int(unsafe.Sizeof(*stats))
+}
@@ -0,0 +1,12 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build bypassstats
+// +build bypassstats
+
+package regexp
+
+// byPassStatsEnabled turns on the match statistics counters.
+// Build with `-tags bypassstats` to enable them.
+const byPassStatsEnabled = true
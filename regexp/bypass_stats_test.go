@@ -0,0 +1,95 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build bypassstats
+// +build bypassstats
+
+package regexp
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestByPassStats(t *testing.T) {
+
+	re := MustCompile(`^abc$`)
+	re.MatchString("abc")
+	re.MatchString("ab")
+	re.MatchString("abcd")
+	re.MatchString("abd")
+
+	stats := re.Stats()
+	if stats.ByPass != 4 || stats.Fallback != 0 || stats.EarlyRejections != 2 {
+		t.Errorf("unexpected stats for `^abc$`: %+v", stats)
+	}
+
+	re = MustCompile(`x.y`)
+	re.MatchString("x")
+	re.MatchString("xay")
+	if stats = re.Stats(); stats.ByPass != 2 || stats.EarlyRejections != 1 {
+		t.Errorf("unexpected stats for `x.y`: %+v", stats)
+	}
+
+	// ASCII classes have a known width, so other lengths are rejected without scanning
+	re = MustCompile(`^[a-z]{5}$`)
+	re.MatchString("abcd")
+	re.MatchString("abcdef")
+	re.MatchString("abcde")
+	if stats = re.Stats(); stats.ByPass != 3 || stats.EarlyRejections != 2 {
+		t.Errorf("unexpected stats for `^[a-z]{5}$`: %+v", stats)
+	}
+
+	// The number of runes is known even when their width isn't
+	re = MustCompile(`^a.b$`)
+	re.MatchString("ab")
+	re.MatchString("a☺☺b")
+	re.MatchString("a" + strings.Repeat("☺", 100) + "b")
+	re.MatchString("a☺b")
+	if stats = re.Stats(); stats.ByPass != 4 || stats.EarlyRejections != 3 {
+		t.Errorf("unexpected stats for `^a.b$`: %+v", stats)
+	}
+
+	// Submatches come from the standard matchers, without keeping MatchString from the bypass matcher
+	re = MustCompile(`x(.)y`)
+	re.FindStringSubmatch("xay")
+	re.MatchString("xay")
+	re.MatchString("xa")
+	if stats = re.Stats(); stats.ByPass != 2 || stats.Fallback != 0 {
+		t.Errorf("unexpected stats for `x(.)y`: %+v", stats)
+	}
+
+	re = MustCompile(`a+b`)
+	re.MatchString("aab")
+	re.MatchString("c")
+	if stats = re.Stats(); stats.ByPass != 0 || stats.Fallback != 2 || stats.EarlyRejections != 0 {
+		t.Errorf("unexpected stats for `a+b`: %+v", stats)
+	}
+
+	// Oversized inputs are rejected before reaching any matcher
+	re.MatchStringLimit("aaaab", 4)
+	if stats = re.Stats(); stats.Fallback != 2 {
+		t.Errorf("unexpected stats for `a+b` with a limit: %+v", stats)
+	}
+
+	// Copies share the counters of the original Regexp
+	re.Copy().MatchString("b")
+	if stats = re.Stats(); stats.Fallback != 3 {
+		t.Errorf("unexpected stats for a copy of `a+b`: %+v", stats)
+	}
+}
+
+func TestByPassSizeStats(t *testing.T) {
+	// The stats are shared by all the sub-progs of an alternation, and only counted once
+	for _, pat := range []string{`^abc$`, `a.c`, `^a?b?c?d?$`, `colou?r`, `^(?:GET|PUT) /[a-z]+$`} {
+		re := MustCompile(pat)
+		if re.bypass == notByPass {
+			t.Fatalf("pat: %s isn't handled by the bypass matcher", pat)
+		}
+		if got, want := re.ByPassSize()-byPassProgSize(re.bypass), int(unsafe.Sizeof(byPassStats{})); got != want {
+			t.Errorf("pat: %s stats size got %v, want %v", pat, got, want)
+		}
+	}
+}
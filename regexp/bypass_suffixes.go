@@ -0,0 +1,107 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// byPassProgSuffixes can match a set of literal suffixes (e.g. `(?:\.png|\.jpg)$`). The suffixes
+// are stored in a trie of their reversed bytes, so the string is only read once from its end,
+// whatever the number of suffixes.
+type byPassProgSuffixes struct {
+	root     *byPassTrieNode // trie of the reversed suffixes
+	count    int             // number of distinct suffixes
+	maxWidth int             // length of the longest suffix
+}
+
+// CompileSuffixes returns a Regexp matching strings ending with any of the suffixes,
+// like `(?:\.png|\.jpg)$` for []string{".png", ".jpg"}. The suffixes are literals:
+// their regexp metacharacters are quoted.
+//
+// The bypass matcher looks up all the suffixes at once in a trie, so the cost of a
+// match only depends on the length of the matched suffix, not on the number of
+// suffixes. It is faster than an alternation for large sets, such as the file
+// extensions of content types.
+func CompileSuffixes(suffixes []string) (*Regexp, error) {
+	if len(suffixes) == 0 {
+		return nil, errors.New("regexp: CompileSuffixes needs at least one suffix")
+	}
+
+	quoted := make([]string, len(suffixes))
+	for i, suffix := range suffixes {
+		quoted[i] = QuoteMeta(suffix)
+	}
+	re, err := Compile(`(?:` + strings.Join(quoted, `|`) + `)$`)
+	if err != nil {
+		return nil, err
+	}
+
+	// U+FFFD also matches invalid UTF-8, which the trie can't see
+	for _, suffix := range suffixes {
+		if strings.ContainsRune(suffix, utf8.RuneError) {
+			return re, nil
+		}
+	}
+
+	prog := &byPassProgSuffixes{root: &byPassTrieNode{}}
+	for _, suffix := range suffixes {
+		prog.add(suffix)
+	}
+	re.setByPass(prog)
+	return re, nil
+}
+
+// add inserts a suffix in the trie, from its last byte to its first one
+func (prog *byPassProgSuffixes) add(suffix string) {
+	node := prog.root
+	for i := len(suffix) - 1; i >= 0; i-- {
+		node = node.insert(suffix[i])
+	}
+	if !node.terminal {
+		node.terminal = true
+		prog.count++
+	}
+	if len(suffix) > prog.maxWidth {
+		prog.maxWidth = len(suffix)
+	}
+}
+
+func (prog *byPassProgSuffixes) MatchString(s string) (matched bool) {
+	node := prog.root
+	for i := len(s) - 1; !node.terminal; i-- {
+		if i < 0 {
+			return false
+		}
+		if node = node.child(s[i]); node == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (prog *byPassProgSuffixes) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+
+	// All the suffixes end with the string: the leftmost match is the longest suffix starting after pos
+	matchBegin = -1
+	node := prog.root
+	for i := len(s); ; i-- {
+		if node.terminal {
+			matchBegin = i
+		}
+		if i <= pos {
+			break
+		}
+		if node = node.child(s[i-1]); node == nil {
+			break
+		}
+	}
+	if matchBegin == -1 {
+		return -1, -1
+	}
+	return matchBegin, len(s)
+}
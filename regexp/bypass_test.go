@@ -5,12 +5,22 @@
 package regexp
 
 import (
+	"bytes"
+	"context"
 	"encoding/csv"
+	"errors"
+	"io"
 	"os"
 	"reflect"
 	"regexp"
+	"regexp/syntax"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"unicode"
+	"unicode/utf8"
 )
 
 var compileByPassTests = []struct {
@@ -21,16 +31,63 @@ var compileByPassTests = []struct {
 	{`[a]`, true},
 	{`[^a]`, true},
 	{`.`, true},
-	{`.+`, false},
+	{`.+`, true},
+	{`.+?`, false},
 	{`a.`, true},
 	{`^a.`, true},
 	{`a{2}`, true},
-	{`(a)`, false},
+	{`(a)`, true},
 	{`x.[^z]yz$`, true},
 	{`^(?:(?:a(?:a.)))$`, true},
 	{`(?:a(?:a.))`, true},
 	{`\A(?:(?:a(?:a.)))\z`, true},
 	{`^aa.*`, true},
+	{`.*yxx$`, true},
+	{`^.*yxx$`, true},
+	{`.*$`, false},
+	{`^/users/([^/]+)$`, true},
+	{`^/users/([^/]+)/edit$`, true},
+	{`^[a-z]+$`, true},
+	{`^abc(?:1|22|333)$`, true},
+	{`ab(?:cd|ef)gh`, true},
+	{`ab(?:cd|e.)gh`, false},
+	{`^abc1$|^abc22$`, true},
+	{`abc$`, true},
+	{`abc\z`, true},
+	{`(?U)abc`, true},
+	{`a(?i:b)c`, true},
+	{`(?i)^abc$`, true},
+	{`(?s)a.c`, true},
+	{`.*foo.*`, true},
+	{`.*?foo.*`, false},
+	{`^abc+?`, true},
+	{`(?m)abc$`, false},
+	{`abc(?m:$)`, false},
+	{`(?m)^abc`, false},
+	{`(?m)^ab^cd$`, false},
+	{`^ab(?m:^)cd`, false},
+	{`^abc.*xyz`, true},
+	{`^abc.*xyz$`, true},
+	{`colou?r`, true},
+	{`a{1,3}b`, true},
+	{`a?b?c?d?e?`, false},
+	{`(?:(?:(?:abc)))`, true},
+	{`^(?:a)(?:b)$`, true},
+	{`((a))b`, true},
+	{`^(?:(a)(?:b(c)))$`, true},
+	{`(a(?:b(c.)))`, true},
+	{`[[:digit:]]`, true},
+	{`[[:space:]]`, true},
+	{`^[[:alpha:]]{3}$`, true},
+	{`[^[:alnum:]]`, true},
+	{`[^.]*\.txt$`, true},
+	{`[^.]+\.txt$`, true},
+	{`([^.]*)\.txt$`, true},
+	{`[^\n]+`, true},
+	{`^[^\n]+$`, true},
+	{`([a-z]+)`, true},
+	{`[^\n]*`, false},
+	{`(?:ab)+`, false},
 }
 
 func TestByPassCompile(t *testing.T) {
@@ -45,9 +102,2530 @@ func TestByPassCompile(t *testing.T) {
 
 }
 
+func TestByPassCanByPass(t *testing.T) {
+	for _, test := range compileByPassTests {
+		if ok, err := CanByPass(test.pat); err != nil || ok != test.isByPass {
+			t.Errorf("pat: %s got (%t, %v), want (%t, nil)", test.pat, ok, err, test.isByPass)
+		}
+	}
+	if _, err := CanByPass(`a(`); err == nil {
+		t.Errorf("pat: a( should not have parsed")
+	}
+}
+
+var byPassMatchTests = []struct {
+	pat   string
+	texts []string
+}{
+	{`.*yxx$`, []string{"", "yxx", strings.Repeat("x", 1000), strings.Repeat("x", 1000) + "yxx", "a\nyxx", "yxxa"}},
+	{`^.*yxx$`, []string{"", "yxx", strings.Repeat("x", 1000) + "yxx", "a\nyxx", "yxx\n", "\nyxx"}},
+	{`^(?s:.*)yxx$`, []string{"yxx", "a\nyxx", "yxx\n"}},
+	{`.*.y$`, []string{"y", "☺y", "\ny", "aay"}},
+	{`^/users/([^/]+)$`, []string{"/users/", "/users/42", "/users/42/", "/users/☺", "/user/42", "/users/\xff"}},
+	{`^/users/([^/]+)/edit$`, []string{"/users//edit", "/users/42/edit", "/users/4/2/edit", "/users/42/edi"}},
+	{`^[a-z]+$`, []string{"", "abc", "abC", "☺"}},
+	{`^(?:GET|POST|PUT|DELETE) `, []string{"", "GET /", "PATCH /", "POST", "PUT  /", "DELETE /x", "GETS /", " GET /"}},
+	{`^(?:ab|abc|b)`, []string{"a", "ab", "abc", "abcd", "bc", "cab"}},
+	{`^(?:abc|ab)`, []string{"ab", "abc", "abcd"}},
+	{`^(?:ab|abc)$`, []string{"ab", "abc", "abcd"}},
+	{`^GET|^POST|^GETS`, []string{"GET", "GETS", "POSTS", "xGET"}},
+	{`^(?:a|b)c$`, []string{"ac", "bc", "cc", "acc"}},
+	{`^(?:☺|é)x`, []string{"☺x", "éx", "\xe2\x98x", "ex"}},
+	{`^xx.*.*yy$`, []string{"xxyy", "xxayy", "xx\nyy", "xyy", "xxy"}},
+	{`^xx(.*)(.+)yy$`, []string{"xxyy", "xx☺yy", "xxabyy", "xx\nyy", "xxa\nyy"}},
+	{`^xx(?s:.+?)(?s:.+)yy$`, []string{"xxyy", "xx☺yy", "xx\n\nyy", "xx\xffayy"}},
+	{`^(.*)(.*)yy$`, []string{"yy", "ayy", "a\nyy", "yya"}},
+	{`foo\b`, []string{"", "foo", "foo!", "foobar", "foobar foo", "foo_", "foo1 foo☺", "foofoo-", "foo\xff"}},
+	{`^foo\b`, []string{"foo", "foo bar", "foobar", " foo"}},
+	{`(?:foo)\b`, []string{"foo", "foobar"}},
+	{`foo\bbar`, []string{"foobar", "foo bar", "foo"}},
+	{`[^\n]+`, []string{"", "\n", "\n\n", "a", "\nab\nc", "☺\n", "\n\xff\n", "\xe2\x98"}},
+	{`^[^\n]+$`, []string{"", "\n", "a", "ab\n", "☺", "\xff"}},
+	{`([a-z]+)`, []string{"", "123", "12ab3cd", "ABc", "☺z"}},
+	{`x|\d+`, []string{"", "a12x", "x12", "☺123☺"}},
+	{`(?:ab){2}c`, []string{"", "abc", "ababc", "abababc", "ababab"}},
+	{`^(?:(?:xy){2}){2}$`, []string{"xyxy", "xyxyxyxy", "xyxyxyxyxy"}},
+	{`^[^/?#]+$`, []string{"", "abc", "ab/c", "abc?", "#", "☺☺", "a\xff"}},
+	{`^/[^/☺]+$`, []string{"/", "/abc", "/ab/c", "/ab☺", "/☹"}},
+	{`^a.+$`, []string{"a", "ab", "ab\n", "a☺"}},
+	{`^.{3,5}$`, []string{"", "ab", "abc", "abcde", "abcdef", "ab\nc", "☺☺☺", "☺☺☺☺☺☺", "ab\xff"}},
+	{`^.{2}$`, []string{"a", "ab", "☺☺", "a\n", "abc"}},
+	{`a\^b$`, []string{"a^b", "xa^b", "a^bx", "ab", "^a^b"}},
+	{`^a\$b`, []string{"a$b", "a$bx", "xa$b", "ab", "a$"}},
+	{`^\$(a*)\^$`, []string{"$^", "$aa^", "$a", "a^", "$a^^"}},
+	{`^\^(a+)\$$`, []string{"^a$", "^aaa$", "^$", "a$", "^a$$"}},
+	{`^ab^cd$`, []string{"abcd", "ab^cd", "cd", ""}},
+	{`^ab$cd`, []string{"abcd", "ab", "ab$cd", "ab\ncd"}},
+	{`^ab^c(d+)`, []string{"abcd", "abcdd", "cd"}},
+	{`^ab$c(d*)`, []string{"ab", "abc", "abcd"}},
+	{`(d*)a$b$`, []string{"ab", "a", "dab"}},
+	{`ab(?:cd|ef)gh`, []string{"abcdgh", "abefgh", "abxygh", "ababefgh", "abcdefgh", "abcd"}},
+	{`^ab(?:cd|ef)gh$`, []string{"abcdgh", "abefgh", "abxygh", "abcdghx"}},
+	{`(?:cd|ef)gh$`, []string{"cdgh", "xefgh", "efg", "ghcdgh"}},
+	{`x(?:☺a|bb|é☺).`, []string{"x☺ab", "xbbb", "xé☺c", "xé☺", "xxbb☺", "x☺b"}},
+	{`^abc.*$`, []string{"abc", "abcd", "ab", "xabc", "abc\n", "abc" + strings.Repeat("x", 1000), "abc" + strings.Repeat("x", 1000) + "\nx"}},
+	{`^abc(?s:.*)$`, []string{"abc", "ab", "abc\n", "abc" + strings.Repeat("x", 1000) + "\nx"}},
+	{`^a.c.*$`, []string{"abc", "a☺c\n", "a\ncd", "a☺cd"}},
+	{`^a(b$c*)`, []string{"ab", "abc", "a", "abb"}},
+	{`^aa{1,2}c*`, []string{"a", "aa", "aab", "aaac", "ba"}},
+	{`^ba{1,2}(a$)*`, []string{"b", "ba", "baa", "baaa", "bab"}},
+	{`(c*)ab$$`, []string{"ab", "cab", "abc", "ab$"}},
+	{`(c*)a$b$`, []string{"ab", "a", "cab"}},
+	{`(c*)a\$b$`, []string{"a$b", "ca$b", "ab"}},
+	{`(?U)abc`, []string{"abc", "xabcx", "ab"}},
+	{`(?U)colou?r`, []string{"color", "colour", "colouur"}},
+	{`(?U)^a(b+)$`, []string{"a", "ab", "abbb", "abc"}},
+	{`^a([^/]+?)b$`, []string{"ab", "acb", "acbb", "ac/b"}},
+	{`^abc(?:1|22|333)$`, []string{"abc22", "abc2", "abc1", "abc333", "abc3333", "abd22", "abc"}},
+	{`^a.(?:b|cd)`, []string{"axb", "axcd", "a☺cdx", "axc", "ab"}},
+	{`^a(?:b$|c)d`, []string{"ab", "acd", "abd"}},
+	{`abc$`, []string{"abc", "xabc", "abc\n", "abc\nx", "ab"}},
+	{`abc\z`, []string{"abc", "xabc", "abc\n", "abc\nx", "ab"}},
+	{`[^abc]`, []string{"", "abc", "abcabcd", "cba☺", "aaaaaaaaaab"}},
+	{`[^a-cx]y`, []string{"xy", "axybcy", "abxdy", "☺y", "xyzy"}},
+	{`^[^abc]{2}$`, []string{"de", "da", "☺☺", "d"}},
+	{`^/[^/?]+$`, []string{"/a", "/a?b", "/a/b", "/"}},
+	{`^abc.*xyz`, []string{"abcxyz", "abc" + strings.Repeat("x", 1000) + "xyz", "abc" + strings.Repeat("x", 1000), "abc\nxyz", "abcxyz\nxyz", "xabcxyz", "abxyz"}},
+	{`^abc(?s:.*)xyz`, []string{"abcxyz", "abc\nxyz", "abc\n"}},
+	{`^abc.*x.z$`, []string{"abcxyz", "abcx☺z", "abc\nxyz", "abcxyzx", "abxyz", "abcxz"}},
+	{`^ab.*ba$`, []string{"aba", "abba", "ab\nba"}},
+	{`^xxxy.*`, []string{"xxxy", "xxxy\n", strings.Repeat("x", 1000)}},
+	{`colou?r`, []string{"color", "colour", "colouur", "colr"}},
+	{`^a{1,3}b$`, []string{"b", "ab", "aab", "aaab", "aaaab"}},
+	{`x?`, []string{"", "y"}},
+	{`((a))b`, []string{"ab", "aab", "a", "b"}},
+	{`^(?:(a)(?:b(c)))$`, []string{"abc", "abcd", "ab"}},
+	{`(a(?:b(c.)))`, []string{"abc☺", "xabcd", "abc"}},
+	{`(abc)(def)`, []string{"abcdef", "xabcdefx", "abcde", "abc(def)"}},
+	{`x(.)y`, []string{"xay", "x☺y", "x\ny", "xy", "xxyy"}},
+	{`a()b`, []string{"ab", "a()b", "xaby", "a", "b"}},
+	{`^a(?:)b$`, []string{"ab", "aab", "abb"}},
+	{`ab{0}c`, []string{"ac", "abc", "xacx"}},
+	{`()$`, []string{"", "a"}},
+	{`^a$()`, []string{"a", "ab", "a\n"}},
+	{`[[:digit:]]`, []string{"", "a", "a1", "☺9", "١"}},
+	{`a[[:space:]]b`, []string{"a b", "a\tb", "a\nb", "a\u00a0b", "ab"}},
+	{`^[[:alpha:]]{3}$`, []string{"abc", "ABC", "ab1", "abé", "ab"}},
+	{`[^[:alnum:]]`, []string{"", "abc123", "abc-", "ab☺"}},
+	{`(ab){3}`, []string{"ababab", "abababab", "abab", "xabababx", "ab ab ab"}},
+	{`^(?:a.){2}$`, []string{"abab", "a☺a☺", "aba", "ababa", "a\nab"}},
+	{`^[a-z]*$`, []string{"", "abc", "abc1", "1abc", "ab\n", "é"}},
+	{`^[a-z]+$`, []string{"", "abc", "abc1"}},
+	{`^a[^/]*b$`, []string{"ab", "axb", "a/b", "a", "b"}},
+	{`[^.]*\.txt$`, []string{"a.txt", ".txt", "a.b.txt", "a.txtx", "a.txt\n"}},
+	{`[^.]+\.txt$`, []string{"a.txt", ".txt", "a..txt", "☺.txt", "a.txtx"}},
+	{`\d+x$`, []string{"1x", "x", "a1x", "1ax", "١x"}},
+	{`^\d+$`, []string{"123", "", "12a", "a12", "١٢", "12\n", "1\xff"}},
+	{`^[[:alpha:]_][[:word:]]*$`, []string{"_", "a1", "1a", "ab-c", "é"}},
+	{`abc|(a+b+)`, []string{"abc", "xabcx", "aab", "ba", "acb", ""}},
+	{`^(ab)*$`, []string{"", "ab", "abab", "aba", "ba", "abba"}},
+	{`^foo(bar)*$`, []string{"foo", "foobar", "foobarbar", "foobarba", "fooba"}},
+	{`^x(?:☺a)+y$`, []string{"xy", "x☺ay", "x☺a☺ay", "x☺☺ay"}},
+	{`(?:ab)+c$`, []string{"abc", "bc", "xababc", "aabc", "c"}},
+	{`^a.b$`, []string{"ab", "axb", "a☺b", "a☺☺b", "a\xffb", "a\xe2\x98b", "a☺xb"}},
+	{`^[^a].☺$`, []string{"☺☺☺", "bb☺", "b☺", "bbb☺", "a☺☺"}},
+	{`^x$|a\bb|y`, []string{"x", "xy", "a b", "ab", ""}},
+}
+
+func TestByPassMatch(t *testing.T) {
+	for _, test := range byPassMatchTests {
+		re := MustCompile(test.pat)
+		if re.bypass == nil {
+			t.Errorf("pat: %s should have been bypassed", test.pat)
+			continue
+		}
+		std := regexp.MustCompile(test.pat)
+		for _, text := range test.texts {
+			if re.MatchString(text) != std.MatchString(text) {
+				t.Errorf("pat: %s text: %q got %t, want %t", test.pat, text, re.MatchString(text), std.MatchString(text))
+			}
+		}
+	}
+}
+
+var byPassSubmatchTests = []struct {
+	pat  string
+	text string
+	want []string
+}{
+	{`^/users/([^/]+)$`, "/users/42", []string{"/users/42", "42"}},
+	{`^/users/([^/]+)$`, "/users/☺a", []string{"/users/☺a", "☺a"}},
+	{`^/users/([^/]+)$`, "/users/42/", nil},
+	{`^/users/([^/]+?)$`, "/users/42", []string{"/users/42", "42"}},
+	{`^/users/([^/]+)/edit$`, "/users/jane/edit", []string{"/users/jane/edit", "jane"}},
+	{`^/users/[^/]+$`, "/users/42", []string{"/users/42"}},
+	{`^(/users)/([^/]+)$`, "/users/42", []string{"/users/42", "/users", "42"}},
+	{`^/users/(([^/]+))$`, "/users/42", []string{"/users/42", "42", "42"}},
+	{`a()b`, "xaby", []string{"ab", ""}},
+	{`(ab){3}`, "xabababx", []string{"ababab", "ab"}},
+	{`(ab){2}c`, "ababababc", []string{"ababc", "ab"}},
+	{`^([a-z]*)$`, "", []string{"", ""}},
+	{`^/users/([^/]*)$`, "/users/", []string{"/users/", ""}},
+	{`^(\d+)$`, "123", []string{"123", "123"}},
+	{`^(\d+)$`, "12a", nil},
+	{`([^\n]+)`, "\n\nab☺\ncd", []string{"ab☺", "ab☺"}},
+	{`[^\n]+`, "\n\n", nil},
+}
+
+func TestByPassSubmatch(t *testing.T) {
+	for _, test := range byPassSubmatchTests {
+		re := MustCompile(test.pat)
+		if re.bypass == nil {
+			t.Errorf("pat: %s should have been bypassed", test.pat)
+			continue
+		}
+		if got := re.FindStringSubmatch(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("pat: %s text: %q got %q, want %q", test.pat, test.text, got, test.want)
+		}
+		std := regexp.MustCompile(test.pat)
+		if got, want := re.FindStringSubmatchIndex(test.text), std.FindStringSubmatchIndex(test.text); !reflect.DeepEqual(got, want) {
+			t.Errorf("pat: %s text: %q got %v, want %v", test.pat, test.text, got, want)
+		}
+	}
+}
+
+var byPassReplaceTests = []struct {
+	pat  string
+	text string
+	repl string
+}{
+	{`x.y`, "xay xby xxyy x\ny", "Z"},
+	{`aa`, "aaaaa", "$0Z"},
+	{`☺`, "a☺b☺☺\xff", "Z"},
+	{`x.y`, "x☺yx", "Z"},
+	{`x.y`, "xay", "$0$0"},
+	{`^ab`, "ababab", "Z"},
+	{`ab$`, "ababab", "Z"},
+	{`^$`, "", "Z"},
+	{`^`, "abc", "Z"},
+	{`$`, "abc", "Z"},
+	{`png|jpg`, "a.jpg b.png c.gif", "img"},
+	{`abc|bc`, "aabc", "Z"},
+	{`^abc(?:1|22)`, "abc22abc1", "Z"},
+	{`colou?r`, "color colour colouur", "Z"},
+	{`ab??c?`, "abcacab", "Z"},
+	{`a{1,3}`, "aaaaa", "Z"},
+	{`x?`, "axb", "Z"},
+	{`[^b]`, "bbabb☺", "Z"},
+	{`[^abc]`, "abcxabc☺", "Z"},
+	{`a$a`, "aaa", "Z"},
+}
+
+func TestByPassReplaceAll(t *testing.T) {
+	for _, test := range byPassReplaceTests {
+		re := MustCompile(test.pat)
+		if re.bypassIndex == nil {
+			t.Errorf("pat: %s should have been bypassed with match locations", test.pat)
+			continue
+		}
+		std := regexp.MustCompile(test.pat)
+		if got, want := re.ReplaceAll([]byte(test.text), []byte(test.repl)), std.ReplaceAll([]byte(test.text), []byte(test.repl)); string(got) != string(want) {
+			t.Errorf("pat: %s text: %q ReplaceAll got %q, want %q", test.pat, test.text, got, want)
+		}
+		if got, want := re.ReplaceAllLiteralString(test.text, test.repl), std.ReplaceAllLiteralString(test.text, test.repl); got != want {
+			t.Errorf("pat: %s text: %q ReplaceAllLiteralString got %q, want %q", test.pat, test.text, got, want)
+		}
+		if got, want := re.FindIndex([]byte(test.text)), std.FindIndex([]byte(test.text)); !reflect.DeepEqual(got, want) {
+			t.Errorf("pat: %s text: %q FindIndex got %v, want %v", test.pat, test.text, got, want)
+		}
+		// Each match is transformed, including the empty ones
+		repl := func(match []byte) []byte {
+			return []byte("<" + strings.ToUpper(string(match)) + ">")
+		}
+		if got, want := re.ReplaceAllFunc([]byte(test.text), repl), std.ReplaceAllFunc([]byte(test.text), repl); string(got) != string(want) {
+			t.Errorf("pat: %s text: %q ReplaceAllFunc got %q, want %q", test.pat, test.text, got, want)
+		}
+	}
+}
+
+func TestByPassMatchStringLimit(t *testing.T) {
+	huge := strings.Repeat("x", 1<<20) + "y"
+	for _, pat := range []string{`x.y`, `xy$`, `x+y`} {
+		re := MustCompile(pat)
+		if !re.MatchString(huge) {
+			t.Errorf("pat: %s should match the huge input", pat)
+		}
+		if re.MatchStringLimit(huge, 1024) {
+			t.Errorf("pat: %s should have rejected the huge input", pat)
+		}
+		if !re.MatchStringLimit(huge, len(huge)) || !re.MatchStringLimit(huge, -1) {
+			t.Errorf("pat: %s should match the huge input within the limit", pat)
+		}
+	}
+}
+
+func TestByPassMatchStrings(t *testing.T) {
+	inputs := []string{"", "xy", "xxy", "yx", "x\ny", strings.Repeat("x", 1000) + "y"}
+	for _, pat := range []string{`x.y`, `xy$`, `x+y`, `^(x*)y`} {
+		re := MustCompile(pat)
+		matched := re.MatchStrings(inputs)
+		if len(matched) != len(inputs) {
+			t.Fatalf("pat: %s got %d results, want %d", pat, len(matched), len(inputs))
+		}
+		for i, s := range inputs {
+			if matched[i] != re.MatchString(s) {
+				t.Errorf("pat: %s text: %q got %v in batch, want %v", pat, s, matched[i], !matched[i])
+			}
+		}
+	}
+}
+
+func TestByPassMatchEachBytes(t *testing.T) {
+	lines := [][]byte{nil, []byte("xy"), []byte("xxy"), []byte("yx"), []byte("x\ny"), []byte("\xffxy"), bytes.Repeat([]byte("x"), 1000)}
+	for _, pat := range []string{`x.y`, `xy$`, `x+y`, `^(x*)y`, `\bx`} {
+		re := MustCompile(pat)
+		matched := re.MatchEachBytes(lines)
+		if len(matched) != len(lines) {
+			t.Fatalf("pat: %s got %d results, want %d", pat, len(matched), len(lines))
+		}
+		for i, b := range lines {
+			if matched[i] != re.Match(b) {
+				t.Errorf("pat: %s text: %q got %v in batch, want %v", pat, b, matched[i], !matched[i])
+			}
+		}
+	}
+}
+
+func TestByPassMatchStringContext(t *testing.T) {
+	ctx := context.Background()
+	chunk := strings.Repeat("x", byPassContextChunk)
+	for _, test := range []struct {
+		pat string
+		s   string
+	}{
+		{`xyz`, chunk + "xyz"},
+		{`x.z`, chunk[:byPassContextChunk-1] + "x☺z" + chunk},
+		{`a☺b`, chunk + chunk[:len(chunk)-2] + "a☺b" + chunk},
+		{`[^x]`, chunk + chunk + "☺"},
+		{`[^x]{2}`, chunk + chunk + "☺"},
+		{`y`, strings.Repeat("x", 10*byPassContextChunk)},
+		{`y`, ""},
+	} {
+		re := MustCompile(test.pat)
+		matched, err := re.MatchStringContext(ctx, test.s)
+		if err != nil || matched != re.MatchString(test.s) {
+			t.Errorf("pat: %s len: %d got (%t, %v), want %t", test.pat, len(test.s), matched, err, re.MatchString(test.s))
+		}
+	}
+
+	// Check that a split rune can't be matched as RuneError
+	for i := 1; i < utf8.UTFMax; i++ {
+		s := chunk[:len(chunk)-i] + strings.Repeat("☺", 3) + chunk
+		if matched, _ := MustCompile(`[^x☺]`).MatchStringContext(ctx, s); matched {
+			t.Errorf("offset %d: got a match of a split rune", i)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	huge := strings.Repeat("x", 100*byPassContextChunk)
+	for _, pat := range []string{`y`, `^y`} {
+		if matched, err := MustCompile(pat).MatchStringContext(cancelled, huge); matched || err != context.Canceled {
+			t.Errorf("pat: %s got (%t, %v) with a cancelled context, want (false, %v)", pat, matched, err, context.Canceled)
+		}
+	}
+
+	// Contexts cancelled during the scan stop it, whatever matcher executes the pattern
+	long := strings.Repeat("x", 4*byPassContextChunk)
+	for _, pat := range []string{`y`, `[^x]`, `y|z.`, `a?y`, `y\b`, `[xy]+z`, `^[^y]+$`, `(x+)y`} {
+		for _, text := range []string{long, long + "y", "y" + long} {
+			re := MustCompile(pat)
+			want := re.MatchString(text)
+			if matched, err := re.MatchStringContext(ctx, text); matched != want || err != nil {
+				t.Errorf("pat: %s len: %d got (%t, %v), want %t", pat, len(text), matched, err, want)
+			}
+		}
+		cancelling := &countdownContext{Context: ctx, n: 3}
+		if matched, err := MustCompile(pat).MatchStringContext(cancelling, long); matched || err != context.Canceled {
+			t.Errorf("pat: %s got (%t, %v) with a context cancelled during the scan, want (false, %v)", pat, matched, err, context.Canceled)
+		}
+		if cancelling.n != 0 {
+			t.Errorf("pat: %s the context was checked %d times less than expected", pat, cancelling.n)
+		}
+	}
+}
+
+// countdownContext is cancelled after n calls to its Err method
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (ctx *countdownContext) Err() error {
+	if ctx.n == 0 {
+		return context.Canceled
+	}
+	ctx.n--
+	return nil
+}
+
+// TestByPassConcurrent shares each Regexp between goroutines, and is meant to be run with -race
+func TestByPassConcurrent(t *testing.T) {
+	goroutines, iterations := 8, 100
+	if testing.Short() {
+		iterations = 10
+	}
+	for _, test := range byPassMatchTests {
+		re := MustCompile(test.pat)
+		want := make([][]int, len(test.texts))
+		for i, text := range test.texts {
+			want[i] = re.FindStringSubmatchIndex(text)
+		}
+
+		var wg sync.WaitGroup
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for n := 0; n < iterations; n++ {
+					for i, text := range test.texts {
+						if re.MatchString(text) != (want[i] != nil) {
+							t.Errorf("pat: %s text: %q got a different MatchString concurrently", test.pat, text)
+						}
+						if got := re.FindStringSubmatchIndex(text); !reflect.DeepEqual(got, want[i]) {
+							t.Errorf("pat: %s text: %q got %v concurrently, want %v", test.pat, text, got, want[i])
+						}
+						re.ReplaceAllString(text, "x")
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func TestByPassPrefixSuffixIndex(t *testing.T) {
+	for _, test := range []struct {
+		pat   string
+		texts []string
+	}{
+		{`^aa(.*)bb$`, []string{"aaXXbb", "aabb", "aab", "aaXXbbX", "aa\nbb"}},
+		{`^ab(?:[^c]*)`, []string{"abd", "abc", "ab", "xab"}},
+		{`(a*)bb$`, []string{"aabb", "xaabb", "bb", "abbb", "aab"}},
+		{`^(a*)bb$`, []string{"aabb", "xaabb", "bb"}},
+		{`^(a+)+bb$`, []string{"aabb", "xaabb", "bb"}},
+		{`^a☺(x|yy)*b$`, []string{"a☺xyyb", "a☺b", "a☺xyb"}},
+	} {
+		re := MustCompile(test.pat)
+		if re.bypassIndex == nil {
+			t.Errorf("pat: %s should have been located by the bypass matcher", test.pat)
+			continue
+		}
+		std := regexp.MustCompile(test.pat)
+		for _, text := range test.texts {
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", test.pat, text, got, want)
+			}
+			if got, want := re.FindAllStringIndex(text, -1), std.FindAllStringIndex(text, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v with FindAll, want %v", test.pat, text, got, want)
+			}
+		}
+	}
+}
+
+func TestByPassHybridAlternate(t *testing.T) {
+	re := MustCompile(`abc|(a+b+)`)
+	prog, ok := re.bypass.(*byPassProgAlternate)
+	if !ok || len(prog.progs) != 2 {
+		t.Fatalf("got %T, want a *byPassProgAlternate with 2 progs", re.bypass)
+	}
+	if _, ok := prog.progs[0].(*byPassProgLiteral); !ok {
+		t.Errorf("got %T for `abc`, want *byPassProgLiteral", prog.progs[0])
+	}
+	if _, ok := prog.progs[1].(*byPassProgResidual); !ok {
+		t.Errorf("got %T for `(a+b+)`, want *byPassProgResidual", prog.progs[1])
+	}
+
+	std := regexp.MustCompile(re.String())
+	for _, text := range []string{"abc", "aabbc", "xabcaab", "aab abc", "ba"} {
+		if got, want := re.FindAllStringIndex(text, -1), std.FindAllStringIndex(text, -1); !reflect.DeepEqual(got, want) {
+			t.Errorf("text: %q got %v, want %v", text, got, want)
+		}
+	}
+
+	if MustCompile(`a+b+|b*`).bypass != nil {
+		t.Errorf("pat: a+b+|b* should not have been bypassed")
+	}
+}
+
+func TestByPassRepeatedLiteral(t *testing.T) {
+	for _, test := range []struct {
+		pat     string
+		literal string
+	}{
+		{`^(ab)*$`, "ab"},
+		{`^foo(bar)*$`, "bar"},
+		{`^x(?:☺a)+y$`, "☺a"},
+	} {
+		prog, ok := MustCompile(test.pat).bypass.(*byPassProgPlus)
+		if !ok {
+			t.Errorf("pat: %s should have been bypassed with byPassProgPlus", test.pat)
+			continue
+		}
+		if prog.step.op != byPassOpLiteral || prog.step.literal != test.literal {
+			t.Errorf("pat: %s got step %s, want literal %q", test.pat, prog.step, test.literal)
+		}
+	}
+}
+
+func TestByPassClassWidth(t *testing.T) {
+	for _, test := range []struct {
+		pat      string
+		minWidth int
+		maxWidth int
+	}{
+		{`^[a-z]{5}$`, 5, 5},
+		{`^\d{3}-\d{4}$`, 8, 8},
+		{`^[a-z☺]{2}$`, 2, -1},
+		{`^[^a-z]{2}$`, 2, -1},
+	} {
+		prog, ok := MustCompile(test.pat).bypass.(*byPassProgAnchored)
+		if !ok {
+			t.Errorf("pat: %s should have been bypassed with byPassProgAnchored", test.pat)
+			continue
+		}
+		if prog.minWidth != test.minWidth || prog.maxWidth != test.maxWidth {
+			t.Errorf("pat: %s got widths %d-%d, want %d-%d", test.pat, prog.minWidth, prog.maxWidth, test.minWidth, test.maxWidth)
+		}
+	}
+}
+
+func TestByPassMaxMatchLen(t *testing.T) {
+	for _, test := range []struct {
+		pat     string
+		n       int
+		bounded bool
+	}{
+		{`x.xy$`, 7, true},
+		{`abc`, 3, true},
+		{`^a☺`, 4, true},
+		{`(?:ab|cd)☺`, 5, true},
+		{`jpg|png`, 3, true},
+		{`ab|c.e`, 6, true},
+		{`^abc(?:1|22)$`, 5, true},
+		{`^.{2,3}$`, 12, true},
+		{`^[a-z]{5}$`, 5, true},
+		{`a$b`, 0, true},
+		{`^abc.*`, -1, false},
+		{`^[a-z]+$`, -1, false},
+		{`a+`, -1, false},
+	} {
+		n, bounded := MustCompile(test.pat).MaxMatchLen()
+		if n != test.n || bounded != test.bounded {
+			t.Errorf("pat: %s got (%d, %t), want (%d, %t)", test.pat, n, bounded, test.n, test.bounded)
+		}
+	}
+}
+
+func TestByPassMatchRecords(t *testing.T) {
+	for _, test := range []struct {
+		pat     string
+		s       string
+		sep     byte
+		matched []bool
+	}{
+		{`^abc$`, "abc\x00abcd\x00xabc\x00abc", 0, []bool{true, false, false, true}},
+		{`^abc$`, "abc\x00", 0, []bool{true, false}},
+		{`^abc$`, "", 0, []bool{false}},
+		{`^$`, "\x00", 0, []bool{true, true}},
+		{`b+`, "ab|c|bb", '|', []bool{true, false, true}},
+		{`^a\nb$`, "a\nb;a\n;b", ';', []bool{true, false, false}},
+		{`^a$`, "a\xffb\xffa", 0xff, []bool{true, false, true}},
+	} {
+		if got := MustCompile(test.pat).MatchRecords(test.s, test.sep); !reflect.DeepEqual(got, test.matched) {
+			t.Errorf("pat: %s text: %q got %v, want %v", test.pat, test.s, got, test.matched)
+		}
+	}
+}
+
+func TestByPassFindAlternateLiteral(t *testing.T) {
+	for _, test := range []struct {
+		pat     string
+		s       string
+		literal string
+		found   bool
+	}{
+		{`cat|dog|bird`, "a bird and a dog", "bird", true},
+		{`cat|dog|bird`, "a fish", "", false},
+		{`cat|dog`, "hotdog, cat", "dog", true},
+		{`^cat$|^dog$|^bird$`, "bird", "bird", true},
+		{`^cat$|^dog$|^bird$`, "birds", "", false},
+		{`^(?:cat|dog|bird)$`, "dog", "dog", true},
+		{`^(?:cat|dog|bird)`, "birds", "bird", true},
+		{`^(cat|dog)$`, "cat", "cat", true},
+		{`cat|do+g`, "cat", "", false},
+		{`c.t|dog`, "cat", "", false},
+	} {
+		literal, found := MustCompile(test.pat).FindAlternateLiteral(test.s)
+		if literal != test.literal || found != test.found {
+			t.Errorf("pat: %s text: %q got (%q, %t), want (%q, %t)", test.pat, test.s, literal, found, test.literal, test.found)
+		}
+	}
+}
+
+func TestByPassReset(t *testing.T) {
+	re := MustCompile(`^/users/([^/]+)$`)
+	if re.bypass == nil || !re.MatchString("/users/42") {
+		t.Fatalf("pat: %s should have been bypassed and matched", re)
+	}
+	// Fill the machine cache of the previous pattern
+	re.FindStringSubmatch("/users/42/edit")
+
+	if err := re.Reset(`(a+)(b+)`); err != nil {
+		t.Fatal(err)
+	}
+	if re.bypass != nil || re.bypassIndex != nil || re.bypassSubmatch != nil {
+		t.Errorf("pat: %s should not have been bypassed after Reset", re)
+	}
+	if re.String() != `(a+)(b+)` || re.NumSubexp() != 2 {
+		t.Errorf("got %s with %d subexps after Reset", re, re.NumSubexp())
+	}
+	if got, want := re.FindStringSubmatch("xaabbb"), []string{"aabbb", "aa", "bbb"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after Reset, want %q", got, want)
+	}
+	if re.MatchString("/users/42") {
+		t.Errorf("the previous pattern still matches after Reset")
+	}
+
+	if err := re.Reset(`a(`); err == nil {
+		t.Errorf("Reset with an invalid pattern should fail")
+	}
+	if re.String() != `(a+)(b+)` || !re.MatchString("ab") {
+		t.Errorf("a failed Reset should leave the Regexp unchanged, got %s", re)
+	}
+
+	if err := re.Reset(`x.y`); err != nil || re.bypass == nil || !re.MatchString("xay") {
+		t.Errorf("pat: x.y should have been bypassed and matched after Reset")
+	}
+}
+
+func TestByPassWordBoundary(t *testing.T) {
+	for _, test := range []struct {
+		pat  string
+		kind ByPassKind
+	}{
+		{`foo\b`, ByPassLiteral},
+		{`^foo\b`, ByPassLiteral},
+		{`foo\bbar`, ByPassUnmatchable},
+		{`x(foo)\b(bar)`, ByPassUnmatchable},
+		{`foo\b bar`, ByPassNone},
+		{`foo!\b`, ByPassNone},
+		{`foo\b$`, ByPassNone},
+		{`(?i)k\bx`, ByPassNone},
+		{`f.o\b`, ByPassNone},
+	} {
+		if kind := MustCompile(test.pat).ByPassKind(); kind != test.kind {
+			t.Errorf("pat: %s got %s, want %s", test.pat, kind, test.kind)
+		}
+	}
+
+	re := MustCompile(`foo\b`)
+	for _, test := range []struct {
+		text    string
+		matched bool
+	}{
+		{"foo!", true},
+		{"foo", true},
+		{"foobar", false},
+		{"foobar foo", true},
+		{"foo_", false},
+		{"foo☺", true},
+	} {
+		if matched := re.MatchString(test.text); matched != test.matched {
+			t.Errorf("pat: %s text: %q got %t, want %t", re, test.text, matched, test.matched)
+		}
+	}
+
+	// Not a plain strings.ReplaceAll
+	if got := re.ReplaceAllLiteralString("foobar foo", "x"); got != "foobar x" {
+		t.Errorf("pat: %s text: \"foobar foo\" got %q, want \"foobar x\"", re, got)
+	}
+}
+
+func TestByPassWordChars(t *testing.T) {
+	isWordChar := func(char rune) bool {
+		return unicode.IsLetter(char) || unicode.IsDigit(char) || char == '_'
+	}
+	for _, test := range []struct {
+		pat       string
+		text      string
+		asciiLoc  []int // with the ASCII word characters
+		customLoc []int // with the Unicode letters and digits
+	}{
+		{`foo\b`, "foo!", []int{0, 3}, []int{0, 3}},
+		{`foo\b`, "foo_", nil, nil},
+		{`foo\b`, "fooé", []int{0, 3}, nil},
+		{`foo\b`, "fooé foo", []int{0, 3}, []int{6, 9}},
+		{`foo\b`, "foo٣ foo☺", []int{0, 3}, []int{6, 9}},
+		{`foo\b`, "foo\xff", []int{0, 3}, []int{0, 3}},
+		{`^foo\b`, "foo日本", []int{0, 3}, nil},
+		{`café\b`, "cafés café!", []int{0, 5}, []int{7, 12}},
+		{`é\bx`, "éx", []int{0, 3}, nil},
+		{`ab\b`, "abé ab abc ab", []int{0, 2}, []int{5, 7}},
+	} {
+		re, err := CompileWordChars(test.pat, isWordChar)
+		if err != nil {
+			t.Errorf("pat: %s should have compiled with custom word characters, got %v", test.pat, err)
+			continue
+		}
+		testByPassMatcherMethods(t, re, test.text, test.customLoc)
+		if got := MustCompile(test.pat).FindIndex([]byte(test.text)); !reflect.DeepEqual(got, test.asciiLoc) {
+			t.Errorf("pat: %s text: %q got %v with ASCII word characters, want %v", test.pat, test.text, got, test.asciiLoc)
+		}
+	}
+
+	// The ASCII word characters give the same matches as the default `\b`
+	for _, pat := range []string{`foo\b`, `^foo\b`, `foo\bbar`} {
+		re, err := CompileWordChars(pat, syntax.IsWordChar)
+		if err != nil {
+			t.Errorf("pat: %s should have compiled with custom word characters, got %v", pat, err)
+			continue
+		}
+		std := regexp.MustCompile(pat)
+		for _, text := range []string{"", "foo", "foo!", "foobar", "xfoo_ foo", "fooé", "foo\xff"} {
+			if got, want := re.ReplaceAllLiteralString(text, "<>"), std.ReplaceAllLiteralString(text, "<>"); got != want {
+				t.Errorf("pat: %s text: %q got %q, want %q", pat, text, got, want)
+			}
+		}
+	}
+
+	for _, pat := range []string{`a+b`, `foo`} {
+		if _, err := CompileWordChars(pat, isWordChar); err != nil {
+			t.Errorf("pat: %s without \\b should have compiled, got %v", pat, err)
+		}
+	}
+	for _, pat := range []string{`\bfoo`, `foo\B`, `a+\b`, `foo\b$`, `(?i)foo\b`} {
+		if _, err := CompileWordChars(pat, isWordChar); err == nil {
+			t.Errorf("pat: %s shouldn't have compiled with custom word characters", pat)
+		}
+	}
+}
+
+func TestByPassSuffixes(t *testing.T) {
+	for _, test := range []struct {
+		suffixes []string
+		texts    []string
+	}{
+		{[]string{".png", ".jpg", ".jpeg", ".tar.gz", ".gz"}, []string{"", "a.png", "png", ".jpg", "a.jpeg", "a.tar.gz", "a.gz", "a.tgz", "a.png.txt", "a.PNG"}},
+		{[]string{"b", "ab", "cab", "b"}, []string{"", "b", "ab", "xab", "cab", "bcab", "ba"}},
+		{[]string{"", "ab"}, []string{"", "a", "ab", "xab"}},
+		{[]string{"a+b", "(c)", "☺"}, []string{"a+b", "aab", "(c)", "c", "x☺", "\xe2\x98"}},
+	} {
+		re, err := CompileSuffixes(test.suffixes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind := re.ByPassKind(); kind != ByPassSuffixes {
+			t.Errorf("suffixes: %q got %s, want Suffixes", test.suffixes, kind)
+		}
+		std := regexp.MustCompile(re.String())
+		for _, text := range test.texts {
+			if got, want := re.MatchString(text), std.MatchString(text); got != want {
+				t.Errorf("pat: %s text: %q got %t, want %t", re, text, got, want)
+			}
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", re, text, got, want)
+			}
+			if got, want := re.FindAllStringIndex(text, -1), std.FindAllStringIndex(text, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v for FindAll, want %v", re, text, got, want)
+			}
+		}
+	}
+
+	if _, err := CompileSuffixes(nil); err == nil {
+		t.Errorf("CompileSuffixes without suffixes should fail")
+	}
+	if _, err := CompileSuffixes([]string{"\xff"}); err == nil {
+		t.Errorf("CompileSuffixes with invalid UTF-8 should fail")
+	}
+}
+
+func TestByPassDotStarRest(t *testing.T) {
+	for _, test := range []struct {
+		pat         string
+		dotStarRest bool
+	}{
+		{`^xx.*.*yy$`, true},
+		{`^xx(.*)(.+)yy$`, true},
+		{`^(.*)(.*)yy$`, true},
+		{`^xx(?s:.+?)(?s:.+)yy$`, true},
+		{`^xx(?s:.*).*yy$`, false},
+		{`^xx.*a*yy$`, false},
+		{`xx.*.*yy$`, false},
+		{`^xx.*.*yy`, false},
+	} {
+		prog, ok := MustCompile(test.pat).bypass.(*byPassProgFirstPass)
+		if !ok {
+			t.Errorf("pat: %s should have been bypassed with byPassProgFirstPass", test.pat)
+			continue
+		}
+		if prog.dotStarRest != test.dotStarRest {
+			t.Errorf("pat: %s got dotStarRest=%t, want %t", test.pat, prog.dotStarRest, test.dotStarRest)
+		}
+	}
+}
+
+func TestByPassScanReader(t *testing.T) {
+	// Matches are placed across the end of the first chunk, which also has room for the overlap
+	padded := func(pat string, middle string, offset int) string {
+		width, _ := MustCompile(pat).MaxMatchLen()
+		end := byPassScanChunk + width - 1
+		return strings.Repeat("x", end-offset) + middle + strings.Repeat("x", byPassScanChunk)
+	}
+	for _, test := range []struct {
+		pat  string
+		text string
+	}{
+		{`abc`, padded(`abc`, "abc", 1)},
+		{`abc`, padded(`abc`, "abc", 2)},
+		{`abc`, padded(`abc`, "ab", 1)},
+		{`a.c`, padded(`a.c`, "a☺c", 2)},
+		{`a.c`, padded(`a.c`, "a☺c", 3)},
+		{`[^x☺]`, padded(`[^x☺]`, "☺", 1)},
+		{`[^x☺]`, padded(`[^x☺]`, "☺", 2)},
+		{`[^x☺]`, padded(`[^x☺]`, "\xff", 1)},
+		{`[^x]y`, padded(`[^x]y`, "☺☺y", 4)},
+		{`y`, padded(`y`, "y", 1)},
+		{`y`, padded(`y`, "", 0)},
+		{`xyz`, "xy"},
+		{`xyz`, ""},
+	} {
+		want := regexp.MustCompile(test.pat).MatchString(test.text)
+		for _, r := range []io.Reader{strings.NewReader(test.text), iotest.OneByteReader(strings.NewReader(test.text))} {
+			matched, err := MustCompile(test.pat).ScanReader(r)
+			if err != nil || matched != want {
+				t.Errorf("pat: %s text of %d bytes got (%t, %v), want %t", test.pat, len(test.text), matched, err, want)
+			}
+		}
+	}
+
+	for _, pat := range []string{`^abc`, `abc$`, `abc\b`, `a+b+`, `^[a-z]+$`} {
+		if _, err := MustCompile(pat).ScanReader(strings.NewReader("abc")); err == nil {
+			t.Errorf("pat: %s should not have been scanned in chunks", pat)
+		}
+	}
+
+	readErr := errors.New("read error")
+	if _, err := MustCompile(`abc`).ScanReader(iotest.ErrReader(readErr)); err != readErr {
+		t.Errorf("got %v, want the read error", err)
+	}
+}
+
+func TestByPassPrefixTrie(t *testing.T) {
+	for _, test := range []struct {
+		pat  string
+		kind ByPassKind
+	}{
+		{`^(?:GET|POST|PUT|DELETE) `, ByPassPrefixTrie},
+		{`^(?:GET|POST|PUT|DELETE)$`, ByPassPrefixTrie},
+		{`^(GET|POST) (/|/index)$`, ByPassPrefixTrie},
+		{`^GET|^POST`, ByPassPrefixTrie},
+		{`^GET$|^POST$`, ByPassPrefixTrie},
+		{`^GET|^POST$`, ByPassAlternate},
+		{`^GET|POST`, ByPassAlternate},
+		{`^(?:GET|POST) .`, ByPassPrefixAlternate},
+		{`^(?i:GET|POST)`, ByPassPrefixAlternate},
+		{`^(?:a|\x{FFFD})`, ByPassAnchored},
+	} {
+		if kind := MustCompile(test.pat).ByPassKind(); kind != test.kind {
+			t.Errorf("pat: %s got %s, want %s", test.pat, kind, test.kind)
+		}
+	}
+
+	re := MustCompile(`^(?:GET|POST|PUT|DELETE) `)
+	if !re.MatchString("GET /") || re.MatchString("PATCH /") {
+		t.Errorf("pat: %s should match \"GET /\" and not \"PATCH /\"", re)
+	}
+	if literal, found := re.FindAlternateLiteral("POST /"); literal != "POST " || !found {
+		t.Errorf("pat: %s got (%q, %t), want (%q, true)", re, literal, found, "POST ")
+	}
+}
+
+func TestByPassFindLastStringIndex(t *testing.T) {
+	for _, test := range []struct {
+		pat  string
+		text string
+		want []int
+	}{
+		{`\.`, "a.b.c", []int{3, 4}},
+		{`\.`, "abc", nil},
+		{`aa`, "aaa", []int{1, 3}},
+		{`[0-9]`, "a1b22c", []int{4, 5}},
+		{`[^a-z]`, "ab☺cd", []int{2, 5}},
+		{`x.y`, "xay x☺y xy", []int{4, 9}},
+		{`^ab`, "abab", []int{0, 2}},
+		{`ab$`, "abab", []int{2, 4}},
+		{`a\b`, "ab a a", []int{5, 6}},
+		{`a+`, "aa baa", []int{5, 6}},
+		{`a*`, "aab", []int{3, 3}},
+		{`(?:ab|b)$`, "xab", []int{2, 3}},
+		{`ab\b`, "ab abc", []int{0, 2}},
+		{`colou?r`, "colour color", []int{7, 12}},
+		{`b|ab`, "xab", []int{2, 3}},
+	} {
+		re := MustCompile(test.pat)
+		if got := re.FindLastStringIndex(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("pat: %s text: %q got %v, want %v", test.pat, test.text, got, test.want)
+		}
+	}
+
+	texts := []string{"", "ab", "aab abb", "a.b.c", "x☺y colour color 123 4", "ab\nab\nb", "\xffa\xffb", "ABab"}
+	for _, pat := range []string{`\.`, `aa`, `[0-9]`, `x.y`, `^ab`, `ab$`, `a\b`, `ab\b`, `a+`, `a*`, `(?:ab|b)$`,
+		`colou?r`, `[0-9]{1,3}`, `a.?b`, `(?m)^a`, `(?m)b$`, `\bab`, `ab|b`, `b|ab`, `(?i)AB`, `☺.`, `.`, ``, `^`, `$`,
+		`[^a]{2}`, `x*y?`, `(a)(b)?`, `\xff`} {
+		for _, text := range texts {
+			if got, want := MustCompile(pat).FindLastStringIndex(text), findLastStringIndexLoop(MustCompile(pat), text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", pat, text, got, want)
+			}
+		}
+	}
+	for _, pat := range []string{`a|ab`, `(a|ab)(c|bcd)`, `a*`, `b+|ab`} {
+		for _, text := range append(texts, "abcd abcd") {
+			if got, want := MustCompilePOSIX(pat).FindLastStringIndex(text), findLastStringIndexLoop(MustCompilePOSIX(pat), text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v in POSIX mode, want %v", pat, text, got, want)
+			}
+		}
+	}
+}
+
+// findLastStringIndexLoop locates the last match of re without the bypass matcher, by searching
+// from the beginning of s and restarting one rune after the beginning of each match
+func findLastStringIndexLoop(re *Regexp, s string) (loc []int) {
+	re.setByPass(notByPass)
+	for pos := 0; pos <= len(s); {
+		a := re.doExecute(nil, nil, s, pos, 2, nil)
+		if a == nil {
+			break
+		}
+		loc = a[0:2]
+		if loc[0] == len(s) {
+			break
+		}
+		_, width := utf8.DecodeRuneInString(s[loc[0]:])
+		pos = loc[0] + width
+	}
+	return loc
+}
+
+func TestByPassFindLastStringIndexLinear(t *testing.T) {
+	// Each part of the alternation searches backwards once, instead of once for each match
+	text := strings.Repeat("12 ab 7 colour ", 3000)
+	for _, pat := range []string{`colou?r`, `[0-9]{1,3}`, `ab\b|x.y`} {
+		re := MustCompile(pat)
+		progalt, ok := re.bypass.(*byPassProgAlternate)
+		if !ok {
+			t.Errorf("pat: %s got %s, want Alternate", pat, re.ByPassKind())
+			continue
+		}
+		scanned := 0
+		parts := make([]byPassProg, len(progalt.progs))
+		for i, subprog := range progalt.progs {
+			parts[i] = &byPassProgScanned{prog: subprog, scanned: &scanned}
+		}
+		re.setByPass(&byPassProgAlternate{progs: parts})
+
+		if got, want := re.FindLastStringIndex(text), findLastStringIndexLoop(MustCompile(pat), text); !reflect.DeepEqual(got, want) {
+			t.Errorf("pat: %s got %v, want %v", pat, got, want)
+		}
+		if max := len(parts) * len(text); scanned > max {
+			t.Errorf("pat: %s scanned %d bytes, want at most %d", pat, scanned, max)
+		}
+	}
+
+	// Patterns without a bypass prog are matched once: restarting after the beginning
+	// of each match would take hours on this input
+	text = strings.Repeat("a", 1<<20)
+	for _, pat := range []string{`a+`, `(a)+b?`} {
+		if got, want := MustCompile(pat).FindLastStringIndex(text), []int{len(text) - 1, len(text)}; !reflect.DeepEqual(got, want) {
+			t.Errorf("pat: %s got %v, want %v", pat, got, want)
+		}
+	}
+}
+
+func TestByPassEmptyAfterEnd(t *testing.T) {
+	for _, pat := range []string{`abc(?:)?$`, `abc$(?:)?`, `abc$()`, `abc$a{0}`, `abc$(?:)*`, `abc$(?:|)`, `^abc$(?:$)?`, `abc$$`} {
+		re := MustCompile(pat)
+		switch re.ByPassKind() {
+		case ByPassNone, ByPassUnmatchable:
+			t.Errorf("pat: %s got %s, want a matchable bypass prog", pat, re.ByPassKind())
+			continue
+		}
+		std := regexp.MustCompile(pat)
+		for _, text := range []string{"abc", "xabc", "abcx", "ab", ""} {
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", pat, text, got, want)
+			}
+		}
+	}
+}
+
+func TestByPassPrefixDotStarWholeString(t *testing.T) {
+	for _, test := range []struct {
+		pat     string
+		matchNL bool
+	}{
+		{`\Aabc.*\z`, false},
+		{`^abc.*$`, false},
+		{`(?s)\Aabc.*\z`, true},
+		{`(?s)^abc.*$`, true},
+		{`\Aabc(?s:.*)\z`, true},
+	} {
+		re := MustCompile(test.pat)
+		prog, ok := re.bypass.(*byPassProgPrefixDotStar)
+		if !ok || !prog.anchoredEnd || prog.matchNL != test.matchNL {
+			t.Errorf("pat: %s got %s, want a byPassProgPrefixDotStar to the end with matchNL=%t", test.pat, re.DumpByPass(), test.matchNL)
+			continue
+		}
+		std := regexp.MustCompile(test.pat)
+		for _, text := range []string{"abc", "abcx", "abc\n", "abcx\ny", "xabc", "ab", "a\nbc", ""} {
+			if got, want := re.MatchString(text), std.MatchString(text); got != want {
+				t.Errorf("pat: %s text: %q got %t, want %t", test.pat, text, got, want)
+			}
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", test.pat, text, got, want)
+			}
+		}
+	}
+}
+
+// anchoredWordsAlternation returns an alternation of n words of various lengths anchored on both ends,
+// and of a class that keeps it from being compiled to a byPassProgPrefixTrie
+func anchoredWordsAlternation(n int) string {
+	parts := []string{`^[0-9]{4}$`}
+	for i := 0; i < n; i++ {
+		parts = append(parts, "^"+strings.Repeat("w", 1+i%10)+strconv.Itoa(i)+"$")
+	}
+	return strings.Join(parts, "|")
+}
+
+func TestByPassAlternateByWidth(t *testing.T) {
+	re := MustCompile(anchoredWordsAlternation(1000))
+	prog, ok := re.bypass.(*byPassProgAlternate)
+	if !ok || prog.byWidth == nil {
+		t.Fatalf("got %T, want a *byPassProgAlternate indexed by width", re.bypass)
+	}
+	// Most of the parts are skipped
+	if tried := len(prog.byWidth[len("www502")]) + len(prog.anyWidth); tried > 200 {
+		t.Errorf("got %d parts tried out of %d, want at most 200", tried, len(prog.progs))
+	}
+
+	std := regexp.MustCompile(re.String())
+	for _, text := range []string{"", "w0", "www502", "www503", "1234", "12345", "w999", "wwwwwwwwww999", "xw0"} {
+		if got, want := re.MatchString(text), std.MatchString(text); got != want {
+			t.Errorf("text: %q got %t, want %t", text, got, want)
+		}
+		if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("text: %q got %v, want %v", text, got, want)
+		}
+	}
+
+	// Parts that aren't anchored on both ends disable the index
+	for _, test := range []struct {
+		pat     string
+		indexed bool
+	}{
+		{`^a$|^b.$|^cc$|^d$|^ee$|^f$|^g$|^h$`, true},
+		{`^a$|^b.$|^cc$|^d$|^ee$|^f$|^g$|h`, false},
+		{`^a|^b.$|^cc$|^d$|^ee$|^f$|^g$|^h$`, false},
+	} {
+		prog, ok := MustCompile(test.pat).bypass.(*byPassProgAlternate)
+		if !ok || (prog.byWidth != nil) != test.indexed {
+			t.Errorf("pat: %s got %T, want a *byPassProgAlternate indexed=%t", test.pat, MustCompile(test.pat).bypass, test.indexed)
+		}
+	}
+}
+
+func TestByPassRequiredLiterals(t *testing.T) {
+	for _, test := range []struct {
+		pat      string
+		literals []string
+	}{
+		{`x.xy$`, []string{"x", "xy"}},
+		{`abc`, []string{"abc"}},
+		{`^abc.*xyz`, []string{"abc", "xyz"}},
+		{`.*foo$`, []string{"foo"}},
+		{`^/users/([^/]+)/edit$`, []string{"/users/", "/edit"}},
+		{`^foo(bar)+$`, []string{"foo", "bar"}},
+		{`^foo(bar)*$`, []string{"foo"}},
+		{`[a-z]+`, nil},
+		{`a|b`, nil},
+		{`a$a`, nil},
+		{`(foo)+`, nil},
+	} {
+		re := MustCompile(test.pat)
+		literals := re.RequiredLiterals()
+		if !reflect.DeepEqual(literals, test.literals) {
+			t.Errorf("pat: %s got %q, want %q", test.pat, literals, test.literals)
+		}
+		for _, text := range []string{"x1xy", "abcxyz", "foo", "/users/1/edit", "foobarbar", "az"} {
+			if !re.MatchString(text) {
+				continue
+			}
+			for _, literal := range literals {
+				if !strings.Contains(text, literal) {
+					t.Errorf("pat: %s text: %q matches without the required literal %q", test.pat, text, literal)
+				}
+			}
+		}
+	}
+}
+
+func TestByPassFoldCase(t *testing.T) {
+	for _, test := range []struct {
+		pat   string
+		text  string
+		match bool
+	}{
+		{`a(?i:b)c`, "abc", true},
+		{`a(?i:b)c`, "aBc", true},
+		{`a(?i:b)c`, "Abc", false},
+		{`a(?i:b)c`, "xxaBcxx", true},
+		{`^(?i:k)$`, "\u212a", true},  // KELVIN SIGN
+		{`(?i)^s1$`, "\u017f1", true}, // LATIN SMALL LETTER LONG S
+		{`(?i)^s1$`, "S1", true},
+		{`(?i)^s1$`, "t1", false},
+		{`(?i)^(a1)b$`, "A1B", true},
+		{`(?i)[a-z]`, "A", true},
+		{`(?i)[a-z]`, "1", false},
+		{`(?i)^[a-z]{3}$`, "\u212a\u017fA", true}, // folds wider than a byte
+		{`(?i)^[a-z]{3}$`, "ABCD", false},
+		{`(?i)[α-ω]`, "Ω", true},
+		{`(?i)^[α-ω]+$`, "ΑΒΓΔ", true},
+		{`(?i)^[α-ω]+$`, "ΑΒ1", false},
+		{`(?i)x[^a-z]y`, "x1y", true},
+		{`(?i)x[^a-z]y`, "x\u212ay", false},
+	} {
+		re := MustCompile(test.pat)
+		if re.ByPassKind() == ByPassNone {
+			t.Errorf("pat: %s should be bypassed", test.pat)
+		}
+		if got := re.MatchString(test.text); got != test.match {
+			t.Errorf("pat: %s text: %q got %t, want %t", test.pat, test.text, got, test.match)
+		}
+		std := regexp.MustCompile(test.pat)
+		if got, want := re.FindStringSubmatchIndex(test.text), std.FindStringSubmatchIndex(test.text); !reflect.DeepEqual(got, want) {
+			t.Errorf("pat: %s text: %q got %v, want %v", test.pat, test.text, got, want)
+		}
+	}
+}
+
+func TestByPassMatchStringDetail(t *testing.T) {
+	for _, test := range []struct {
+		pat     string
+		text    string
+		matched bool
+		atStart bool
+		atEnd   bool
+	}{
+		{`abc`, "abc", true, true, true},
+		{`abc`, "abcd", true, true, false},
+		{`abc`, "xabc", true, false, true},
+		{`abc`, "xabcx", true, false, false},
+		{`abc`, "ab", false, false, false},
+		{`^abc$`, "abc", true, true, true},
+		{`^abc$`, "abcd", false, false, false},
+		{`^abc`, "abcd", true, true, false},
+		{`a.c`, "xa☺c", true, false, true},
+		{`a+b+`, "xaab", true, false, true},
+	} {
+		matched, atStart, atEnd := MustCompile(test.pat).MatchStringDetail(test.text)
+		if matched != test.matched || atStart != test.atStart || atEnd != test.atEnd {
+			t.Errorf("pat: %s text: %q got %t %t %t, want %t %t %t", test.pat, test.text,
+				matched, atStart, atEnd, test.matched, test.atStart, test.atEnd)
+		}
+	}
+}
+
+func TestByPassDotStarSuffixIndex(t *testing.T) {
+	texts := []string{"", "yxx", "ayxx", "a\nyxx", "a\nbyxx", "a\nb\ncyxx", "yxx\n", "\nyxx", "a☺\n☺yxx", "a/b.txt", "a\n/b.txt", "/.txt", "a.b.txt", ".txt"}
+	for _, pat := range []string{`.*yxx$`, `(?s).*yxx$`, `^.*yxx$`, `.+yxx$`, `(?s).+yxx$`, `[^/]*\.txt$`, `[^.]+\.txt$`} {
+		re := MustCompile(pat)
+		if _, ok := re.bypass.(*byPassProgDotStarSuffix); !ok || re.bypassIndex == nil {
+			t.Errorf("pat: %s got %T, want *byPassProgDotStarSuffix with match locations", pat, re.bypass)
+			continue
+		}
+		std := regexp.MustCompile(pat)
+		for _, text := range texts {
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", pat, text, got, want)
+			}
+			if got, want := re.FindAllStringIndex(text, -1), std.FindAllStringIndex(text, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q FindAllStringIndex got %v, want %v", pat, text, got, want)
+			}
+		}
+	}
+
+	// Without `(?s)`, the match starts after the last `\n` before the suffix
+	if got := MustCompile(`.*yxx$`).FindStringIndex("a\nb\ncyxx"); !reflect.DeepEqual(got, []int{4, 8}) {
+		t.Errorf("pat: .*yxx$ got %v, want [4 8]", got)
+	}
+	if got := MustCompile(`(?s).*yxx$`).FindStringIndex("a\nb\ncyxx"); !reflect.DeepEqual(got, []int{0, 8}) {
+		t.Errorf("pat: (?s).*yxx$ got %v, want [0 8]", got)
+	}
+}
+
+func TestByPassContains(t *testing.T) {
+	for _, test := range []struct {
+		pat     string
+		text    string
+		matched bool
+	}{
+		{`.*foo.*`, "xfooy", true},
+		{`.*foo.*`, "bar", false},
+		{`.*foo.*`, "a\nfoo\nb", true},
+		{`(?s).*foo.*`, "a\nfoo\nb", true},
+		{`.*f.o.*`, "f\no", false},
+		{`(?s).*f.o.*`, "f\no", true},
+	} {
+		re := MustCompile(test.pat)
+		if re.ByPassKind() != ByPassContains {
+			t.Errorf("pat: %s got %s, want Contains", test.pat, re.ByPassKind())
+		}
+		if got := re.MatchString(test.text); got != test.matched {
+			t.Errorf("pat: %s text: %q got %t, want %t", test.pat, test.text, got, test.matched)
+		}
+	}
+
+	// Without `(?s)`, the match spans the lines of the occurrences; with it, the whole string
+	texts := []string{"", "foo", "xfooy", "a\nfoo\nb", "a\nxfooyfoo\nb\nfoo", "\n\nfoo", "foo\n", "fo\no", "a\nb", "f\nofoo", "☺foo☺\n☺"}
+	for _, pat := range []string{`.*foo.*`, `(?s).*foo.*`, `.*f.o.*`, `(?s).*f.o.*`, `.*(foo).*`, `.*o\n.*`, `.*[^a]o.*`, `.*o.*`} {
+		re := MustCompile(pat)
+		if re.ByPassKind() != ByPassContains {
+			t.Errorf("pat: %s got %s, want Contains", pat, re.ByPassKind())
+			continue
+		}
+		std := regexp.MustCompile(pat)
+		for _, text := range texts {
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", pat, text, got, want)
+			}
+			if got, want := re.FindAllStringIndex(text, -1), std.FindAllStringIndex(text, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q FindAllStringIndex got %v, want %v", pat, text, got, want)
+			}
+		}
+	}
+
+	for _, pat := range []string{`.*?foo.*`, `.*foo(?s:.*)`, `^.*foo.*`, `.*foo$.*`} {
+		if kind := MustCompile(pat).ByPassKind(); kind == ByPassContains {
+			t.Errorf("pat: %s should not have been compiled to Contains", pat)
+		}
+	}
+}
+
+func TestByPassInvalidUTF8(t *testing.T) {
+	// Invalid bytes are decoded as RuneError, one byte at a time, like the standard library does
+	texts := []string{"\xff", "\xffa", "a\xff", "a\xffb", "a\xff\xffb", "aa\xe2\x98b", "a\xe2\x98\xffb", "z\xffzb", "\xef\xbf\xbdb", "a\xef\xbf\xbdb", "☺\xff☺"}
+	for _, pat := range []string{`[^a]`, `.`, `[a-z]`, `[^a]b`, `[^ab]b`, `[^☺]b`, `a[^a]`, `[^a]..`, `.b`, `[a-z]b`, `^[^a]b$`, `[^a]+`, `\x{FFFD}`, `[\x{FFFD}]b`, `a\x{FFFD}b`, `^a\x{FFFD}`, `^\x{FFFD}(a)$`, `\x{FFFD}$`} {
+		re := MustCompile(pat)
+		if re.bypass == nil {
+			t.Errorf("pat: %s should have been bypassed", pat)
+			continue
+		}
+		std := regexp.MustCompile(pat)
+		for _, text := range texts {
+			if got, want := re.MatchString(text), std.MatchString(text); got != want {
+				t.Errorf("pat: %s text: %q got %t, want %t", pat, text, got, want)
+			}
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", pat, text, got, want)
+			}
+			if got, want := re.FindAllStringIndex(text, -1), std.FindAllStringIndex(text, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q FindAllStringIndex got %v, want %v", pat, text, got, want)
+			}
+		}
+	}
+}
+
+func TestByPassMaxExpansions(t *testing.T) {
+	for _, test := range []struct {
+		pat           string
+		maxExpansions int
+		isByPass      bool
+	}{
+		{`^a{1,16}$`, 16, true},
+		{`^a{1,17}$`, 16, true}, // byPassProgPlus checks the bounds instead
+		{`^(?:ab){1,17}$`, 16, false},
+		{`^(?:ab){1,17}$`, 17, true},
+		{`a?b?c?d?e?`, 31, false},
+		{`a?b?c?d?e?`, 32, true},
+		{`colou?r`, 2, true},
+		{`colou?r`, 1, false},
+		{`jpe?g|png?`, 2, true},
+	} {
+		re, err := CompileMaxExpansions(test.pat, test.maxExpansions)
+		if err != nil {
+			t.Errorf("pat: %s got error %v", test.pat, err)
+			continue
+		}
+		if (re.bypass != nil) != test.isByPass {
+			t.Errorf("pat: %s with %d expansions should have been bypassed=%t", test.pat, test.maxExpansions, test.isByPass)
+		}
+		std := regexp.MustCompile(test.pat)
+		for _, text := range []string{"", "a", "aaaaaaaaaaaaaaaa", "aaaaaaaaaaaaaaaaa", "ace", "abcde", "color", "colour", "jpg", "pn"} {
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", test.pat, text, got, want)
+			}
+		}
+	}
+
+	if _, err := CompileMaxExpansions(`a`, 0); err == nil {
+		t.Errorf("CompileMaxExpansions should need at least one expansion")
+	}
+}
+
+// byPassProgScanned counts the bytes searched by the IndexString and LastIndexString calls of prog
+type byPassProgScanned struct {
+	prog    byPassProg
+	scanned *int
+}
+
+func (p *byPassProgScanned) MatchString(s string) bool {
+	return p.prog.MatchString(s)
+}
+
+func (p *byPassProgScanned) LastIndexString(s string) (matchBegin int, matchEnd int) {
+	matchBegin, matchEnd = p.prog.(byPassLastIndexProg).LastIndexString(s)
+	if matchBegin == -1 {
+		*p.scanned += len(s)
+	} else {
+		*p.scanned += len(s) - matchBegin
+	}
+	return matchBegin, matchEnd
+}
+
+func (p *byPassProgScanned) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+	matchBegin, matchEnd = p.prog.(byPassIndexProg).IndexString(s, pos)
+	if matchBegin == -1 {
+		*p.scanned += len(s) - pos
+	} else {
+		*p.scanned += matchEnd - pos
+	}
+	return matchBegin, matchEnd
+}
+
+func TestByPassExpandedReplaceAllLinear(t *testing.T) {
+	// Parts of the alternation that rarely match (`[0-9]{3}` in `[0-9]{1,3}`) mustn't search
+	// the rest of the string again for each match
+	text := strings.Repeat("12 ab 7 ", 3000)
+	for _, pat := range []string{`colou?r`, `[0-9]{1,3}`, `\d??[^a]?\s{0,2}`} {
+		re := MustCompile(pat)
+		progalt, ok := re.bypass.(*byPassProgAlternate)
+		if !ok {
+			t.Errorf("pat: %s got %s, want Alternate", pat, re.ByPassKind())
+			continue
+		}
+		scanned := 0
+		parts := make([]byPassProg, len(progalt.progs))
+		for i, subprog := range progalt.progs {
+			parts[i] = &byPassProgScanned{prog: subprog, scanned: &scanned}
+		}
+		re.setByPass(&byPassProgAlternate{progs: parts})
+
+		if got, want := re.ReplaceAllString(text, "<$0>"), regexp.MustCompile(pat).ReplaceAllString(text, "<$0>"); got != want {
+			t.Errorf("pat: %s got %q, want %q", pat, got[:20], want[:20])
+		}
+		if max := 2 * len(parts) * len(text); scanned > max {
+			t.Errorf("pat: %s scanned %d bytes, want at most %d", pat, scanned, max)
+		}
+	}
+}
+
+func TestByPassEmpty(t *testing.T) {
+	for _, test := range []struct {
+		pat     string
+		text    string
+		matched bool
+	}{
+		{`^$`, "", true},
+		{`^$`, "x", false},
+		{``, "", true},
+		{``, "abc", true},
+		{`\A\z`, "", true},
+		{`\A\z`, "\n", false},
+	} {
+		re := MustCompile(test.pat)
+		if re.ByPassKind() != ByPassEmpty {
+			t.Errorf("pat: %s got %s, want Empty", test.pat, re.ByPassKind())
+			continue
+		}
+		if got := re.MatchString(test.text); got != test.matched {
+			t.Errorf("pat: %s text: %q got %t, want %t", test.pat, test.text, got, test.matched)
+		}
+	}
+
+	for _, pat := range []string{`^$`, ``, `(?:)`, `^`, `$`, `\A`, `\z`, `^()$`} {
+		re := MustCompile(pat)
+		if re.ByPassKind() != ByPassEmpty {
+			t.Errorf("pat: %s got %s, want Empty", pat, re.ByPassKind())
+			continue
+		}
+		std := regexp.MustCompile(pat)
+		for _, text := range []string{"", "x", "abc", "\n", "☺"} {
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", pat, text, got, want)
+			}
+			if got, want := re.FindAllStringIndex(text, -1), std.FindAllStringIndex(text, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q FindAllStringIndex got %v, want %v", pat, text, got, want)
+			}
+			if got, want := re.ReplaceAllString(text, "-"), std.ReplaceAllString(text, "-"); got != want {
+				t.Errorf("pat: %s text: %q ReplaceAllString got %q, want %q", pat, text, got, want)
+			}
+		}
+	}
+}
+
+func TestByPassSize(t *testing.T) {
+	if size := MustCompile(`a+b+`).ByPassSize(); size != 0 {
+		t.Errorf("pat: a+b+ got %d, want 0", size)
+	}
+
+	// Each step adds its own storage, and expansions multiply the steps
+	small := MustCompile(`^abc$`).ByPassSize()
+	steps := MustCompile(`^a[a-z]b[^x]c.[0-9]d$`).ByPassSize()
+	expanded := MustCompile(`^a?b?c?d?$`).ByPassSize()
+	if small <= 0 {
+		t.Fatalf("pat: ^abc$ got %d, want > 0", small)
+	}
+	if steps <= small {
+		t.Errorf("pattern with many steps got %d, want more than %d", steps, small)
+	}
+	if expanded <= 4*small {
+		t.Errorf("expanded pattern got %d, want more than %d", expanded, 4*small)
+	}
+
+	// Longer literals, classes and tries use more memory
+	for _, test := range []struct {
+		smaller, larger string
+	}{
+		{`abc`, `abcdefghijklmnopqrstuvwxyz`},
+		{`^[a-c]$`, `^[a-cf-hk-mp-r]$`},
+		{`^(?:GET|POST) `, `^(?:GET|POST|PUT|DELETE|HEAD|OPTIONS) `},
+		{`^x.*y$`, `^xxxx.*yyyy$`},
+	} {
+		smaller, larger := MustCompile(test.smaller).ByPassSize(), MustCompile(test.larger).ByPassSize()
+		if smaller <= 0 || larger <= smaller {
+			t.Errorf("pat: %s got %d, want more than pat: %s got %d", test.larger, larger, test.smaller, smaller)
+		}
+	}
+
+	for _, test := range compileByPassTests {
+		re := MustCompile(test.pat)
+		if got := re.ByPassSize() > 0; got != (re.bypass != nil) {
+			t.Errorf("pat: %s got size %d for kind %s", test.pat, re.ByPassSize(), re.ByPassKind())
+		}
+	}
+}
+
+func TestByPassASCIIPrefix(t *testing.T) {
+	texts := []string{"", "a", "ab", "abc", "abcd", "ab1", "aBc", "a☺c", "☺abc", "abc\n", "a1b", "a-b", "\xffbc", "ab\xff", "é", "xy9z"}
+	for _, pat := range []string{`^[a-z][a-z][a-z]`, `^[a-z]{3}`, `^[a-z]{3}$`, `^a[0-9]b`, `^a[-+]b$`, `^[a-c]b\d`, `^(?i)abc`, `^[a-z]y\d`, `^\w{2}`} {
+		re := MustCompile(pat)
+		if prog, ok := re.bypass.(*byPassProgAnchored); !ok || prog.asciiBytes == nil {
+			t.Errorf("pat: %s should have been compiled with asciiBytes", pat)
+			continue
+		}
+		std := regexp.MustCompile(pat)
+		for _, text := range texts {
+			if got, want := re.MatchString(text), std.MatchString(text); got != want {
+				t.Errorf("pat: %s text: %q got %t, want %t", pat, text, got, want)
+			}
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", pat, text, got, want)
+			}
+		}
+	}
+
+	// Classes and literals that can match multi-byte characters still decode runes
+	for _, pat := range []string{`^[a-z].`, `^a[^b]`, `^[a-zé]b`, `^é[a-z]`, `[a-z]{2}é$`, `^[a-z]\pL`} {
+		if prog, ok := MustCompile(pat).bypass.(*byPassProgAnchored); ok && prog.asciiBytes != nil {
+			t.Errorf("pat: %s should not have been compiled with asciiBytes", pat)
+		}
+	}
+}
+
+func TestByPassASCIISuffix(t *testing.T) {
+	texts := []string{"", "1", "123", "abc123", "abc12a", "1234", "12☺3", "☺123", "123\n", "a\xff12", "12\xff", "x-12", "٣٤٥", "abc1٣3"}
+	for _, pat := range []string{`[0-9]{3}$`, `\d{3}$`, `[0-9][0-9][0-9]$`, `-[0-9]{2}$`, `[a-c]\d\d$`, `(?i)x-\d{2}$`, `[0-9]{2}(?:)$`} {
+		re := MustCompile(pat)
+		if prog, ok := re.bypass.(*byPassProgAnchored); !ok || prog.asciiBytes == nil {
+			t.Errorf("pat: %s should have been compiled with asciiBytes", pat)
+			continue
+		}
+		std := regexp.MustCompile(pat)
+		for _, text := range texts {
+			if got, want := re.MatchString(text), std.MatchString(text); got != want {
+				t.Errorf("pat: %s text: %q got %t, want %t", pat, text, got, want)
+			}
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", pat, text, got, want)
+			}
+			if got, want := re.FindIndex([]byte(text)), std.FindIndex([]byte(text)); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", pat, text, got, want)
+			}
+		}
+	}
+
+	// Classes that can match multi-byte characters count the runes from the end with lastRunesWidth
+	for _, pat := range []string{`\pN{3}$`, `[^a]{3}$`, `.[0-9]$`} {
+		re := MustCompile(pat)
+		if prog, ok := re.bypass.(*byPassProgAnchored); !ok || prog.asciiBytes != nil {
+			t.Errorf("pat: %s should have been compiled without asciiBytes", pat)
+			continue
+		}
+		std := regexp.MustCompile(pat)
+		for _, text := range texts {
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", pat, text, got, want)
+			}
+		}
+	}
+}
+
+func TestByPassRepeatedClass(t *testing.T) {
+	// `[0-9]{4}` is a single step checking 4 bytes
+	prog, ok := MustCompile(`ID-[0-9]{4}-X`).bypass.(*byPassProgUnanchored)
+	if !ok || len(prog.steps) != 3 || prog.steps[1].length != 4 || prog.steps[1].maxWidth != 4 {
+		t.Fatalf("pat: ID-[0-9]{4}-X should have been compiled to 3 steps, got:\n%s", MustCompile(`ID-[0-9]{4}-X`).DumpByPass())
+	}
+
+	texts := []string{"", "ID-1234-X", "ID-123-X", "ID-12345-X", "xxID-12ID-1234-Xyy", "ID-12a4-X", "ID-12☺4-X", "1234", "a123", "12\n34", "☺1234☺", "ID-1234-XID-5678-X"}
+	for _, pat := range []string{`ID-[0-9]{4}-X`, `[0-9]{4}`, `^[0-9]{4}`, `[0-9]{4}$`, `^[0-9]{2}[0-9]{2}$`, `\d{2}[a-z]\d`, `[0-9]{3}-X`, `(?i)id-\d{4}`, `.[0-9]{2}`} {
+		re := MustCompile(pat)
+		if re.bypass == nil {
+			t.Errorf("pat: %s should have been bypassed", pat)
+			continue
+		}
+		std := regexp.MustCompile(pat)
+		for _, text := range texts {
+			if got, want := re.MatchString(text), std.MatchString(text); got != want {
+				t.Errorf("pat: %s text: %q got %t, want %t", pat, text, got, want)
+			}
+			if got, want := re.FindAllStringIndex(text, -1), std.FindAllStringIndex(text, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q FindAllStringIndex got %v, want %v", pat, text, got, want)
+			}
+			if got, want := re.FindReaderIndex(strings.NewReader(text)), std.FindReaderIndex(strings.NewReader(text)); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q FindReaderIndex got %v, want %v", pat, text, got, want)
+			}
+		}
+	}
+
+	re, err := CompileByteMode(`ID-[0-9]{4}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := re.FindStringIndex("xID-1234"); !reflect.DeepEqual(got, []int{1, 8}) {
+		t.Errorf("pat: ID-[0-9]{4} in byte mode got %v, want [1 8]", got)
+	}
+}
+
+// byPassProgNot is a broken prog, always returning the opposite of prog
+type byPassProgNot struct {
+	prog byPassProg
+}
+
+func (p *byPassProgNot) MatchString(s string) bool {
+	return !p.prog.MatchString(s)
+}
+
+func TestByPassCompileVerified(t *testing.T) {
+	re, err := CompileVerified(`^a.c$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"", "abc", "a☺c", "abd"} {
+		if got, want := re.MatchString(text), regexp.MustCompile(`^a.c$`).MatchString(text); got != want {
+			t.Errorf("pat: ^a.c$ text: %q got %t, want %t", text, got, want)
+		}
+	}
+
+	// Patterns executed by the other matchers are only executed once
+	if fallback, err := CompileVerified(`a+b+`); err != nil || !fallback.MatchString("aabb") {
+		t.Errorf("pat: a+b+ should have matched, got error %v", err)
+	}
+
+	re.bypass = &byPassProgNot{re.bypass}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("a broken bypass prog should have panicked")
+		} else if msg, _ := r.(string); !strings.Contains(msg, "returned false on \"abc\"") {
+			t.Errorf("unexpected panic: %v", r)
+		}
+	}()
+	re.MatchString("abc")
+}
+
+func TestByPassMatchLines(t *testing.T) {
+	log := "INFO start\nERROR disk full\n\nWARN ERROR later\nERROR\n"
+	for _, test := range []struct {
+		pat      string
+		text     string
+		matched  []bool
+		isByPass bool
+	}{
+		{`(?m)^ERROR`, log, []bool{false, true, false, false, true}, true},
+		{`(?m)ERROR$`, log, []bool{false, false, false, false, true}, true},
+		{`(?m)^$`, log, []bool{false, false, true, false, false}, true},
+		{`(?m)^ERROR .*full$`, log, []bool{false, true, false, false, false}, true},
+		{`ERROR`, log, []bool{false, true, false, true, true}, true},
+		{`(?m)^ERROR`, "ERROR", []bool{true}, true},
+		{`(?m)^ERROR`, "x\nERROR", []bool{false, true}, true},
+		{`(?m)^ERROR`, "\n", []bool{false}, true},
+		{`(?m)^ERROR`, "", []bool{}, true},
+		{`(?m)^E+R`, "EER\nxER", []bool{true, false}, false},
+	} {
+		re := MustCompile(test.pat)
+		if got := re.MatchLines(test.text); !reflect.DeepEqual(got, test.matched) {
+			t.Errorf("pat: %s text: %q got %v, want %v", test.pat, test.text, got, test.matched)
+		}
+		if isByPass := re.bypass != nil || re.bypassLines != nil; isByPass != test.isByPass {
+			t.Errorf("pat: %s got bypass %t, want %t", test.pat, isByPass, test.isByPass)
+		}
+	}
+
+	// Each line gives the same result as the standard library on the line alone
+	for _, pat := range []string{`(?m)^a.c`, `(?m)^a.c$`, `(?m)b$`, `(?m)^(?:ab|cd)`, `(?m)^$`, `(?m)^[^a]`, `(?m)^a|c$`} {
+		re, std := MustCompile(pat), regexp.MustCompile(pat)
+		if re.bypassLines == nil {
+			t.Errorf("pat: %s should have been bypassed for MatchLines", pat)
+		}
+		text := "abc\n\nab\ncd\nxbc\na☺c\nb"
+		for i, matched := range re.MatchLines(text) {
+			line := strings.Split(text, "\n")[i]
+			if want := std.MatchString(line); matched != want {
+				t.Errorf("pat: %s line: %q got %t, want %t", pat, line, matched, want)
+			}
+		}
+	}
+}
+
+func TestByPassSharedPrefix(t *testing.T) {
+	texts := []string{"", "GET /", "GEX /a", "GET /b", "GE /a", "abc-1", "abc-1234567890123456", "abc-12345678901234567", "abc-", "ab1-2", "abc-12a", "abcde", "abcde7", "abcde77", "/u/ab", "/u/a", "/u/a☺", "/u/a\n", "abc9x", "abc55", "abc666", "abc9"}
+	for _, test := range []struct {
+		pat     string
+		byWidth bool
+	}{
+		{`^GE[TX] /a?$`, false},
+		{`^abcde[0-9]?$`, false},
+		{`^[a-z]{3}-[0-9]{1,16}$`, true},
+		{`^/u/[a-z].?$`, false},
+		{`^abc(?:1|22|333|4|55|666|7|88|9.)$`, true},
+	} {
+		re := MustCompile(test.pat)
+		prog, ok := re.bypass.(*byPassProgPrefixAlternate)
+		if !ok {
+			t.Errorf("pat: %s got %s, want PrefixAlternate", test.pat, re.ByPassKind())
+			continue
+		}
+		if (prog.byWidth != nil) != test.byWidth {
+			t.Errorf("pat: %s got byWidth %t, want %t", test.pat, prog.byWidth != nil, test.byWidth)
+		}
+		std := regexp.MustCompile(test.pat)
+		for _, text := range texts {
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", test.pat, text, got, want)
+			}
+		}
+	}
+
+	// The prefix is only stored once, instead of once for each expansion
+	shared := MustCompile(`^[a-z]{3}-[0-9]{1,16}$`).ByPassSize()
+	expanded := 0
+	for n := 1; n <= 16; n++ {
+		expanded += MustCompile(`^[a-z]{3}-[0-9]{` + strconv.Itoa(n) + `}$`).ByPassSize()
+	}
+	if shared >= expanded {
+		t.Errorf("pat: ^[a-z]{3}-[0-9]{1,16}$ got size %d, want less than %d", shared, expanded)
+	}
+}
+
+func TestByPassExactLiteral(t *testing.T) {
+	for _, test := range []struct {
+		pat     string
+		text    string
+		matched bool
+	}{
+		{`^xxxxy$`, "xxxxy", true},
+		{`^xxxxy$`, "xxxxyy", false},
+		{`^xxxxy$`, "xxxx", false},
+		{`^☺é$`, "☺é", true},
+		{`^☺é$`, "☺e\u0301", false}, // same character, decomposed
+		{`^☺é$`, "☺é☺", false},
+		{`^日本語$`, "日本語", true},
+		{`^日本語$`, "日本", false},
+		{`\A日本語\z`, "日本語", true},
+	} {
+		re := MustCompile(test.pat)
+		if re.ByPassKind() != ByPassLiteral {
+			t.Errorf("pat: %s got %s, want Literal", test.pat, re.ByPassKind())
+		}
+		if got := re.MatchString(test.text); got != test.matched {
+			t.Errorf("pat: %s text: %q got %t, want %t", test.pat, test.text, got, test.matched)
+		}
+		if got, want := re.FindStringIndex(test.text), regexp.MustCompile(test.pat).FindStringIndex(test.text); !reflect.DeepEqual(got, want) {
+			t.Errorf("pat: %s text: %q got %v, want %v", test.pat, test.text, got, want)
+		}
+	}
+}
+
+func TestByPassBoundedRepeat(t *testing.T) {
+	for _, test := range []struct {
+		pat  string
+		text string
+		kind ByPassKind
+	}{
+		{`^abc[0-9]{1,4}$`, "abc42", ByPassPrefixAlternate},
+		{`^abc[0-9]{1,4}$`, "abc12345", ByPassPrefixAlternate},
+		{`^abc[0-9]{1,4}$`, "abc", ByPassPrefixAlternate},
+		{`^abc[0-9]{1,40}$`, "abc42", ByPassPlus},
+		{`^abc[0-9]{1,40}$`, "abc", ByPassPlus},
+		{`^abc[0-9]{1,40}$`, "abc" + strings.Repeat("1", 40), ByPassPlus},
+		{`^abc[0-9]{1,40}$`, "abc" + strings.Repeat("1", 41), ByPassPlus},
+		{`^abc[0-9]{1,40}$`, "abc12x", ByPassPlus},
+		{`^abc[0-9]{2,}$`, "abc1", ByPassPlus},
+		{`^abc[0-9]{2,}$`, "abc12", ByPassPlus},
+		{`^abc[0-9]{2,}$`, "abc" + strings.Repeat("1", 100), ByPassPlus},
+		{`^abc[0-9]{3,}$`, "abc12", ByPassPlus},
+		{`^[0-9]{0,20}$`, "", ByPassPlus},
+		{`^[0-9]{0,20}$`, strings.Repeat("1", 21), ByPassPlus},
+		{`^x([^/]{2,20})y$`, "x☺☺y", ByPassPlus},
+		{`^x([^/]{2,20})y$`, "x☺y", ByPassPlus},
+		{`^x([^/]{2,20})y$`, "x" + strings.Repeat("☺", 20) + "y", ByPassPlus},
+		{`^x([^/]{2,20})y$`, "x" + strings.Repeat("☺", 21) + "y", ByPassPlus},
+		{`^x([^/]{2,20})y$`, "x\xffay", ByPassPlus},
+		{`^x[a-z]{2,20}?y$`, "xaay", ByPassPlus},
+	} {
+		re := MustCompile(test.pat)
+		if re.ByPassKind() != test.kind {
+			t.Errorf("pat: %s got %s, want %s", test.pat, re.ByPassKind(), test.kind)
+		}
+		std := regexp.MustCompile(test.pat)
+		if got, want := re.MatchString(test.text), std.MatchString(test.text); got != want {
+			t.Errorf("pat: %s text: %q got %t, want %t", test.pat, test.text, got, want)
+		}
+		if got, want := re.FindStringSubmatchIndex(test.text), std.FindStringSubmatchIndex(test.text); !reflect.DeepEqual(got, want) {
+			t.Errorf("pat: %s text: %q got %v, want %v", test.pat, test.text, got, want)
+		}
+	}
+}
+
+func TestByPassFixedCaptures(t *testing.T) {
+	for _, test := range []struct {
+		pat  string
+		text string
+	}{
+		{`^(\d{4})-(\d{2})-(\d{2})$`, "2024-02-29"},
+		{`^(\d{4})-(\d{2})-(\d{2})$`, "2024-02-2x"},
+		{`^(\d{4})-(\d{2})-(\d{2})$`, "2024-02-290"},
+		{`^(\d{4})-(\d{2})-(\d{2})`, "2024-02-29T12:00"},
+		{`(\d{2}):(\d{2})$`, "2024-02-29T12:00"},
+		{`^(é)(☺.)x$`, "é☺éx"},
+		{`^(?:(a)|b)c$`, "bc"},
+		{`^(a){3}(b)$`, "aaab"},
+		{`^x()y$`, "xy"},
+		{`^([0-9])([0-9])(?:-)?$`, "12-"},
+	} {
+		re := MustCompile(test.pat)
+		std := regexp.MustCompile(test.pat)
+		if got, want := re.FindStringSubmatchIndex(test.text), std.FindStringSubmatchIndex(test.text); !reflect.DeepEqual(got, want) {
+			t.Errorf("pat: %s text: %q got %v, want %v", test.pat, test.text, got, want)
+		}
+		if got, want := re.FindStringSubmatch(test.text), std.FindStringSubmatch(test.text); !reflect.DeepEqual(got, want) {
+			t.Errorf("pat: %s text: %q got %q, want %q", test.pat, test.text, got, want)
+		}
+	}
+
+	re := MustCompile(`^(\d{4})-(\d{2})-(\d{2})$`)
+	if re.ByPassKind() != ByPassAnchored || re.bypassSubmatch == nil {
+		t.Errorf("pat: %s got %s, want Anchored reporting submatches", re, re.ByPassKind())
+	}
+	if got, want := re.FindStringSubmatch("2024-02-29"), []string{"2024-02-29", "2024", "02", "29"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pat: %s got %q, want %q", re, got, want)
+	}
+}
+
+func TestByPassNumericClasses(t *testing.T) {
+	for _, test := range []struct {
+		pat  string
+		text string
+		kind ByPassKind
+	}{
+		{`^\p{Nd}+$`, "2024", ByPassPlus},
+		{`^\p{Nd}+$`, "١٢٣", ByPassPlus}, // Arabic-Indic digits
+		{`^\p{Nd}+$`, "１２３", ByPassPlus}, // fullwidth digits
+		{`^\p{Nd}+$`, "१२3", ByPassPlus}, // Devanagari digits and an ASCII one
+		{`^\p{Nd}+$`, "12a", ByPassPlus},
+		{`^\p{Nd}+$`, "Ⅻ", ByPassPlus},
+		{`^\p{Nd}+$`, "²", ByPassPlus},
+		{`^\pN+$`, "Ⅻ²½", ByPassPlus},
+		{`^\pN+$`, "1a", ByPassPlus},
+		{`^\pN{3}$`, "١２3", ByPassAnchored},
+		{`^\pN{3}$`, "١２", ByPassAnchored},
+		{`\p{Nd}`, "abc٣", ByPassUnanchored},
+		{`\p{Nd}`, "abc\xff", ByPassUnanchored},
+		{`x\PNy`, "x٣yxay", ByPassUnanchored},
+	} {
+		re := MustCompile(test.pat)
+		if re.ByPassKind() != test.kind {
+			t.Errorf("pat: %s got %s, want %s", test.pat, re.ByPassKind(), test.kind)
+		}
+		std := regexp.MustCompile(test.pat)
+		if got, want := re.MatchString(test.text), std.MatchString(test.text); got != want {
+			t.Errorf("pat: %s text: %q got %t, want %t", test.pat, test.text, got, want)
+		}
+		if got, want := re.FindStringIndex(test.text), std.FindStringIndex(test.text); !reflect.DeepEqual(got, want) {
+			t.Errorf("pat: %s text: %q got %v, want %v", test.pat, test.text, got, want)
+		}
+	}
+
+	// Large classes are binary searched, and must match the same runes as a linear scan
+	for _, pat := range []string{`\pN`, `\p{Nd}`, `\pL`, `[^\pN]`} {
+		prog := MustCompile(pat).bypass.(*byPassProgUnanchored)
+		step := prog.steps[0]
+		classes := step.classes
+		if len(classes) <= 2*byPassMaxLinearRanges {
+			t.Errorf("pat: %s got %d ranges, want more than %d", pat, len(classes)/2, byPassMaxLinearRanges)
+		}
+		for char := rune(0); char <= 0x20000; char++ {
+			linear := false
+			for i := 0; i < len(classes); i += 2 {
+				linear = linear || classes[i] <= char && char <= classes[i+1]
+			}
+			if got := matchCharInClasses(char, step); got != linear {
+				t.Errorf("pat: %s char: %q got %t, want %t", pat, char, got, linear)
+			}
+		}
+	}
+}
+
+func TestByPassUnanchoredRuneRewind(t *testing.T) {
+	// Every text of up to 4 of these pieces, mixing multi-byte runes, ASCII and invalid UTF-8,
+	// so that rewinding the window by bytes instead of runes would start in the middle of a rune
+	pieces := []string{"☺", "a", "é", "\xe2\x98", "\xff", "日", "\n", "1"}
+	texts := []string{""}
+	for i := 0; i < len(texts); i++ {
+		if len(texts[i]) >= 4*utf8.UTFMax {
+			continue
+		}
+		for _, piece := range pieces {
+			if text := texts[i] + piece; utf8.RuneCountInString(text) <= 4 {
+				texts = append(texts, text)
+			}
+		}
+	}
+
+	for _, pat := range []string{`☺.☺`, `..☺`, `☺..a`, `[^a]☺`, `☺[^☺]☺`, `é.é`, `\x{FFFD}☺`, `aa[0-9]`, `☺☺.`, `é☺[^a]`, `a☺.a`, `(?s).☺.`} {
+		re := MustCompile(pat)
+		if re.ByPassKind() != ByPassUnanchored {
+			t.Errorf("pat: %s got %s, want Unanchored", pat, re.ByPassKind())
+		}
+		std := regexp.MustCompile(pat)
+		for _, text := range texts {
+			if got, want := re.MatchString(text), std.MatchString(text); got != want {
+				t.Errorf("pat: %s text: %q got %t, want %t", pat, text, got, want)
+			}
+			if got, want := re.FindAllStringIndex(text, -1), std.FindAllStringIndex(text, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", pat, text, got, want)
+			}
+		}
+	}
+}
+
+func TestByPassOpenPlus(t *testing.T) {
+	for _, test := range []struct {
+		pat  string
+		text string
+	}{
+		{`^[A-Z][a-z]+`, "Hello"},
+		{`^[A-Z][a-z]+`, "hello"},
+		{`^[A-Z][a-z]+`, "H1"},
+		{`^[A-Z][a-z]+`, "H"},
+		{`^[A-Z][a-z]+`, "Hello World"},
+		{`^[A-Z][a-z]*`, "H1"},
+		{`^[A-Z]([a-z]+)`, "Hello World"},
+		{`^[a-z]+`, "abc1def"},
+		{`^[^/]+`, "☺é/x"},
+		{`^[^/]+`, "/x"},
+		{`^a+`, "aaab"},
+		{`^x[☺é]*`, "x☺é☺\xffé"},
+	} {
+		re := MustCompile(test.pat)
+		if re.ByPassKind() != ByPassPlus {
+			t.Errorf("pat: %s got %s, want Plus", test.pat, re.ByPassKind())
+		}
+		std := regexp.MustCompile(test.pat)
+		if got, want := re.MatchString(test.text), std.MatchString(test.text); got != want {
+			t.Errorf("pat: %s text: %q got %t, want %t", test.pat, test.text, got, want)
+		}
+		if got, want := re.FindAllStringIndex(test.text, -1), std.FindAllStringIndex(test.text, -1); !reflect.DeepEqual(got, want) {
+			t.Errorf("pat: %s text: %q got %v, want %v", test.pat, test.text, got, want)
+		}
+		if got, want := re.FindStringSubmatchIndex(test.text), std.FindStringSubmatchIndex(test.text); !reflect.DeepEqual(got, want) {
+			t.Errorf("pat: %s text: %q got %v, want %v", test.pat, test.text, got, want)
+		}
+	}
+
+	// A non-greedy `class+?` only matches a single rune, and is left to the other matchers
+	if kind := MustCompile(`^[A-Z][a-z]+?`).ByPassKind(); kind == ByPassPlus {
+		t.Errorf("pat: ^[A-Z][a-z]+? got %s", kind)
+	}
+}
+
+func TestByPassMatchStringFrom(t *testing.T) {
+	for _, test := range []struct {
+		pat     string
+		s       string
+		start   int
+		matched bool
+	}{
+		{`^abc`, "xxabc", 2, true},
+		{`^abc`, "xxabc", 0, false},
+		{`^abc`, "xxabc", 1, false},
+		{`^abc`, "xxabcd", 2, true},
+		{`^abc$`, "xxabcd", 2, false},
+		{`^abc$`, "xxabc", 2, true},
+		{`^a.c`, "a☺abc", 4, true},
+		{`^a+$`, "baaa", 1, true},
+		{`^`, "abc", 3, true},
+		{`^(a|b)\b`, "aab", 2, true},
+	} {
+		if got := MustCompile(test.pat).MatchStringFrom(test.s, test.start); got != test.matched {
+			t.Errorf("pat: %s text: %q start: %d got %v, want %v", test.pat, test.s, test.start, got, test.matched)
+		}
+	}
+}
+
+func TestByPassMatchStringFromOutOfRange(t *testing.T) {
+	re := MustCompile(`^abc`)
+	for _, start := range []int{-1, 4, 100} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("pat: ^abc text: \"abc\" start: %d should have panicked", start)
+				} else if msg, _ := r.(string); !strings.Contains(msg, "MatchStringFrom: start "+strconv.Itoa(start)+" out of range [0:3]") {
+					t.Errorf("pat: ^abc text: \"abc\" start: %d unexpected panic: %v", start, r)
+				}
+			}()
+			re.MatchStringFrom("abc", start)
+		}()
+	}
+}
+
+func TestByPassRepeatedGroups(t *testing.T) {
+	for _, test := range []struct {
+		pat     string
+		literal string
+	}{
+		{`(ab){3}`, "ababab"},
+		{`(?:ab){3}`, "ababab"},
+		{`((?:a)(b)){3}`, "ababab"},
+		{`(?:ab){2}c`, "ababc"},
+		{`(ab){2}`, "abab"},
+		{`(?:(?:xy){2}){2}`, "xyxyxyxy"},
+		{`x(?:(a)(?:bc){2}){2}`, "xabcbcabcbc"},
+	} {
+		prog, ok := MustCompile(test.pat).bypass.(*byPassProgLiteral)
+		if !ok {
+			t.Errorf("pat: %s should have been bypassed with byPassProgLiteral", test.pat)
+			continue
+		}
+		if prog.literal != test.literal {
+			t.Errorf("pat: %s got literal %q, want %q", test.pat, prog.literal, test.literal)
+		}
+	}
+}
+
+func TestByPassMatchPrefix(t *testing.T) {
+	for _, test := range []struct {
+		pat     string
+		s       string
+		matched bool
+		n       int
+	}{
+		{`^\d{3}`, "123abc", true, 3},
+		{`^\d{3}`, "12abc", false, 0},
+		{`^\d{3}`, "a123", false, 0},
+		{`^.☺`, "a☺b", true, 4},
+		{`^\d{3}$`, "123", true, 3},
+		{`^\d{3}$`, "1234", false, 0},
+		{`\d{3}$`, "123", true, 3},
+		{`\d{3}$`, "0123", false, 0},
+		{`[a-z]+`, "abc123", true, 3},
+		{`[a-z]+`, "1abc", false, 0},
+		{`^`, "abc", true, 0},
+	} {
+		re := MustCompile(test.pat)
+		matched, n := re.MatchPrefix(test.s)
+		if matched != test.matched || n != test.n {
+			t.Errorf("pat: %s text: %q got (%t, %d), want (%t, %d)", test.pat, test.s, matched, n, test.matched, test.n)
+		}
+	}
+	if _, ok := MustCompile(`^\d{3}`).bypass.(*byPassProgAnchored); !ok {
+		t.Errorf("pat: ^\\d{3} should have been bypassed with byPassProgAnchored")
+	}
+}
+
+func TestByPassASCIIClasses(t *testing.T) {
+	for _, test := range []struct {
+		pat       string
+		asciiOnly bool
+		byteSet   bool
+	}{
+		{`[[:digit:]]`, true, true},
+		{`[[:space:]]`, true, true},
+		{`[[:word:]]`, true, false},
+		{`[^[:alpha:]]`, true, false},
+		{`\d`, true, true},
+		{`[aeiou]`, true, true},
+		{`(?i)[aeiou]`, true, true},
+		{`[a☺]`, false, false},
+		{`[^a☺]`, false, false},
+		{`\pL`, false, false},
+	} {
+		re := MustCompile(test.pat)
+		prog, ok := re.bypass.(*byPassProgUnanchored)
+		if !ok {
+			t.Errorf("pat: %s got %T, want *byPassProgUnanchored", test.pat, re.bypass)
+			continue
+		}
+		step := prog.steps[0]
+		if step.asciiOnly != test.asciiOnly {
+			t.Errorf("pat: %s got asciiOnly=%t, want %t", test.pat, step.asciiOnly, test.asciiOnly)
+		}
+		if (step.byteSet != nil) != test.byteSet {
+			t.Errorf("pat: %s got byteSet=%t, want %t", test.pat, step.byteSet != nil, test.byteSet)
+		}
+		generic := *step
+		generic.asciiOnly = false
+		generic.byteSet = nil
+		for char := rune(0); char < 0x400; char++ {
+			if matchCharInClasses(char, step) != matchCharInClasses(char, &generic) {
+				t.Errorf("pat: %s char: %q got %t with the ASCII bitmap", test.pat, char, matchCharInClasses(char, step))
+			}
+			text := "☺\xff" + string(char)
+			gotIndex, gotChar := findCharClass(text, step)
+			wantIndex, wantChar := findCharClass(text, &generic)
+			if gotIndex != wantIndex || gotChar != wantChar {
+				t.Errorf("pat: %s text: %q got (%d, %q), want (%d, %q)", test.pat, text, gotIndex, gotChar, wantIndex, wantChar)
+			}
+		}
+	}
+}
+
+func TestByPassFindAllCapacity(t *testing.T) {
+	for _, test := range []struct {
+		pat      string
+		size     int
+		n        int
+		capacity int
+	}{
+		{`x`, 40, 41, 40},
+		{`x`, 100, 3, 3},
+		{`x`, 100, 101, maxFindAllCapacity},
+		{`x.y`, 100, 101, 33},
+		{`colou?r`, 100, 101, 20},
+		{`x`, 1 << 20, 1<<20 + 1, maxFindAllCapacity},
+		{`x+`, 100, 101, startSize},
+	} {
+		if got := MustCompile(test.pat).findAllCapacity(test.size, test.n); got != test.capacity {
+			t.Errorf("pat: %s size: %d n: %d got capacity %d, want %d", test.pat, test.size, test.n, got, test.capacity)
+		}
+	}
+}
+
+var compileLiteralsTests = []struct {
+	literals []string
+	anchored bool
+	pat      string
+}{
+	{[]string{"jpg", "png", "gif"}, false, `jpg|png|gif`},
+	{[]string{"jpg", "png", "gif"}, true, `^(?:jpg|png|gif)$`},
+	{[]string{"a.b", "(c)", "☺"}, false, `a\.b|\(c\)|☺`},
+	{[]string{"a.b", "(c)", "☺"}, true, `^(?:a\.b|\(c\)|☺)$`},
+	{[]string{"abc", "ab"}, false, `abc|ab`},
+	{[]string{"", "x"}, true, `^(?:|x)$`},
+	{[]string{"x"}, true, `^(?:x)$`},
+	{nil, false, `[^\x00-\x{10FFFF}]`},
+}
+
+func TestByPassCompileLiterals(t *testing.T) {
+	texts := []string{"", "x", "jpg", "a.png", "pngx", "gi", "axb", "a.b", "(c)", "c", "☺☺", "ab", "abc", "xabcx"}
+	for _, test := range compileLiteralsTests {
+		re := CompileLiterals(test.literals, test.anchored)
+		std := regexp.MustCompile(test.pat)
+		if re.String() != test.pat {
+			t.Errorf("literals: %q String() got %s, want %s", test.literals, re.String(), test.pat)
+		}
+		for _, text := range texts {
+			if got, want := re.MatchString(text), std.MatchString(text); got != want {
+				t.Errorf("literals: %q text: %q got %t, want %t", test.literals, text, got, want)
+			}
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("literals: %q text: %q FindStringIndex got %v, want %v", test.literals, text, got, want)
+			}
+		}
+	}
+}
+
+func TestByPassCompileLiteralsInvalidUTF8(t *testing.T) {
+	// Compile(QuoteMeta(literal)) rejects these too, instead of matching U+FFFD
+	for _, literal := range []string{"\xff", "a\xe2\x98", "\xef\xbf\xbd\xff"} {
+		if _, err := Compile(QuoteMeta(literal)); err == nil {
+			t.Errorf("literal: %q Compile(QuoteMeta()) should have failed", literal)
+		}
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("literal: %q CompileLiterals should have panicked", literal)
+				} else if msg, _ := r.(string); !strings.Contains(msg, "invalid UTF-8") {
+					t.Errorf("literal: %q unexpected panic: %v", literal, r)
+				}
+			}()
+			CompileLiterals([]string{"ok", literal}, true)
+		}()
+	}
+}
+
+var byPassReaderTests = []struct {
+	pat   string
+	texts []string
+}{
+	{`xy`, []string{"", "x", "xy", "xxxy", "x☺xy", "yx"}},
+	{`^x☺`, []string{"", "x", "x☺", "x☺x☺", "xx☺"}},
+	{`x☺$`, []string{"", "x☺", "x☺x☺", "x☺x", "xx☺"}},
+	{`^x☺$`, []string{"", "x☺", "x☺x☺", "x"}},
+	{`x.y`, []string{"xy", "x☺y", "☺☺x☺y☺", "x\ny", "xxxxy"}},
+	{`x(?:☺a|bb)y`, []string{"x☺ay", "xbby", "xxbby", "x☺by", "xbb"}},
+	{`[^abc][0-9]`, []string{"a1", "ab1c2d3", "☺5", "abc"}},
+	{`a[bc]d.`, []string{"abd", "xacdx", "abcd☺abd☺", "\xffacd\xff"}},
+	{`foo\b`, []string{"foo", "foobar", "foobar foo!", "foo_foo☺", "foofoo"}},
+	{`^foo\b`, []string{"foo", "foobar", "foo bar", " foo"}},
+}
+
+func TestByPassFindReaderIndex(t *testing.T) {
+	for _, test := range byPassReaderTests {
+		re := MustCompile(test.pat)
+		if _, ok := re.bypass.(byPassReaderProg); !ok {
+			t.Errorf("pat: %s should have been bypassed for readers", test.pat)
+			continue
+		}
+		for _, text := range test.texts {
+			got := re.FindReaderIndex(strings.NewReader(text))
+			if want := re.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", test.pat, text, got, want)
+			}
+			if want := regexp.MustCompile(test.pat).FindReaderIndex(strings.NewReader(text)); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v from the standard library", test.pat, text, got, want)
+			}
+		}
+	}
+}
+
+var fixedLengthsTests = []struct {
+	pat     string
+	lengths []int
+}{
+	{`a.b`, []int{3}},
+	{`colou?r`, []int{5, 6}},
+	{`^a{1,3}$`, []int{1, 2, 3}},
+	{`jpg|png|jpeg`, []int{3, 4}},
+	{`^abc(?:1|22|333)$`, []int{4, 5, 6}},
+	{`^.{3,5}$`, []int{3, 4, 5}},
+	{`a+`, nil},
+	{`^(a)`, []int{1}},
+	{`^(a)+`, nil},
+}
+
+func TestByPassFixedLengths(t *testing.T) {
+	for _, test := range fixedLengthsTests {
+		if got := MustCompile(test.pat).FixedLengths(); !reflect.DeepEqual(got, test.lengths) {
+			t.Errorf("pat: %s got %v, want %v", test.pat, got, test.lengths)
+		}
+	}
+}
+
+func TestByPassLiteral(t *testing.T) {
+	for _, pat := range []string{`^abc$`, `abc`, `abc$`, `^abc`, `\Aabc`, `a(?:b)c`, `^a(b)c`, `a\^b$`, `^a\$b`} {
+		if _, ok := MustCompile(pat).bypass.(*byPassProgLiteral); !ok {
+			t.Errorf("pat: %s should have been compiled to a byPassProgLiteral", pat)
+		}
+	}
+	for _, pat := range []string{`a.c`, `^a[bc]$`} {
+		if _, ok := MustCompile(pat).bypass.(*byPassProgLiteral); ok {
+			t.Errorf("pat: %s should not have been compiled to a byPassProgLiteral", pat)
+		}
+	}
+}
+
+func TestByPassDump(t *testing.T) {
+	dump := MustCompile(`x.xy$`).DumpByPass()
+	for _, want := range []string{
+		"Anchored begin=false end=true length=4 minWidth=4 maxWidth=-1\n",
+		`  Literal "x" length=1 previousLength=0 minWidth=1 maxWidth=1 minNextWidth=4 anchorIndex=-4` + "\n",
+		`  NegativeCharClass '\n' length=1 previousLength=1 minWidth=1 maxWidth=-1 minNextWidth=3 anchorIndex=-3` + "\n",
+		`  Literal "xy" length=2 previousLength=2 minWidth=2 maxWidth=2 minNextWidth=2 anchorIndex=-2` + "\n",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump of `x.xy$` should contain %q, got:\n%s", want, dump)
+		}
+	}
+	if dump := MustCompile(`a+b+`).DumpByPass(); dump != "" {
+		t.Errorf("dump of `a+b+` should be empty, got:\n%s", dump)
+	}
+}
+
+var byteModeTests = []struct {
+	pat     string
+	text    string
+	loc     []int // location of the match in byte mode, nil if none
+	utf8Loc []int // location of the match in the default UTF-8 mode
+}{
+	{`^\x7fELF`, "\x7fELF\x02\x01", []int{0, 4}, []int{0, 4}},
+	{`^\xff$`, "\xff", []int{0, 1}, nil},
+	{`^\xff$`, "ÿ", nil, []int{0, 2}},
+	{`a.b`, "a☺b", nil, []int{0, 5}},
+	{`a...b`, "xa☺b", []int{1, 6}, nil},
+	{`[\x80-\xff]{2}$`, "caf\xc3\xa9", []int{3, 5}, nil},
+	{`[^a]`, "a☺", []int{1, 2}, []int{1, 4}},
+	{`a$a`, "aa", nil, nil},
+	{`a.`, "a☺a\xffa", []int{0, 2}, []int{0, 4}},
+	{`.`, "☺x", []int{0, 1}, []int{0, 3}},
+	{`[\x80-\xff]`, "a☺x", []int{1, 2}, nil},
+	{``, "☺x", []int{0, 0}, []int{0, 0}},
+}
+
+// testByPassMatcherMethods checks that all the methods of m follow its mode, given loc,
+// the location of the leftmost match in text
+func testByPassMatcherMethods(t *testing.T, m *ByPassMatcher, text string, loc []int) {
+	if got := m.FindStringIndex(text); !reflect.DeepEqual(got, loc) {
+		t.Errorf("pat: %s text: %q FindStringIndex got %v, want %v", m, text, got, loc)
+	}
+	if got := m.FindIndex([]byte(text)); !reflect.DeepEqual(got, loc) {
+		t.Errorf("pat: %s text: %q FindIndex got %v, want %v", m, text, got, loc)
+	}
+	matched := loc != nil
+	if m.MatchString(text) != matched || m.Match([]byte(text)) != matched || m.MatchStrings([]string{text})[0] != matched {
+		t.Errorf("pat: %s text: %q should have matched=%t", m, text, matched)
+	}
+
+	all := m.FindAllStringIndex(text, -1)
+	if got := m.FindAllIndex([]byte(text), -1); !reflect.DeepEqual(got, all) {
+		t.Errorf("pat: %s text: %q FindAllIndex got %v, want %v", m, text, got, all)
+	}
+	if (len(all) > 0) != matched || matched && !reflect.DeepEqual(all[0], loc) {
+		t.Errorf("pat: %s text: %q FindAllStringIndex got %v, want %v first", m, text, all, loc)
+	}
+
+	// ReplaceAll* replace the matches of FindAll*
+	var want, wantLiteral string
+	last := 0
+	for _, match := range all {
+		want += text[last:match[0]] + "<" + text[match[0]:match[1]] + ">"
+		wantLiteral += text[last:match[0]] + "<>"
+		last = match[1]
+	}
+	want += text[last:]
+	wantLiteral += text[last:]
+	if got := m.ReplaceAllLiteralString(text, "<>"); got != wantLiteral {
+		t.Errorf("pat: %s text: %q ReplaceAllLiteralString got %q, want %q", m, text, got, wantLiteral)
+	}
+	if got := m.ReplaceAllLiteral([]byte(text), []byte("<>")); string(got) != wantLiteral {
+		t.Errorf("pat: %s text: %q ReplaceAllLiteral got %q, want %q", m, text, got, wantLiteral)
+	}
+	if got := m.ReplaceAllStringFunc(text, func(s string) string { return "<" + s + ">" }); got != want {
+		t.Errorf("pat: %s text: %q ReplaceAllStringFunc got %q, want %q", m, text, got, want)
+	}
+	if got := m.ReplaceAllFunc([]byte(text), func(b []byte) []byte { return []byte("<" + string(b) + ">") }); string(got) != want {
+		t.Errorf("pat: %s text: %q ReplaceAllFunc got %q, want %q", m, text, got, want)
+	}
+}
+
+func TestByPassByteMode(t *testing.T) {
+	for _, test := range byteModeTests {
+		re, err := CompileByteMode(test.pat)
+		if err != nil {
+			t.Errorf("pat: %s should have compiled in byte mode, got %v", test.pat, err)
+			continue
+		}
+		testByPassMatcherMethods(t, re, test.text, test.loc)
+		if got := MustCompile(test.pat).FindIndex([]byte(test.text)); !reflect.DeepEqual(got, test.utf8Loc) {
+			t.Errorf("pat: %s text: %q got %v in UTF-8 mode, want %v", test.pat, test.text, got, test.utf8Loc)
+		}
+	}
+	// Matches can begin and end inside a rune
+	for _, test := range []struct {
+		pat  string
+		text string
+		all  [][]int
+	}{
+		{`.`, "☺x", [][]int{{0, 1}, {1, 2}, {2, 3}, {3, 4}}},
+		{``, "☺x", [][]int{{0, 0}, {1, 1}, {2, 2}, {3, 3}, {4, 4}}},
+		{`\x98`, "☺☺", [][]int{{1, 2}, {4, 5}}},
+	} {
+		m, err := CompileByteMode(test.pat)
+		if err != nil {
+			t.Fatalf("pat: %s should have compiled in byte mode, got %v", test.pat, err)
+		}
+		if got := m.FindAllStringIndex(test.text, -1); !reflect.DeepEqual(got, test.all) {
+			t.Errorf("pat: %s text: %q got %v, want %v", test.pat, test.text, got, test.all)
+		}
+	}
+	for _, pat := range []string{`a+`, `☺`, `(?:ab|cd)`} {
+		if _, err := CompileByteMode(pat); err == nil {
+			t.Errorf("pat: %s should not have compiled in byte mode", pat)
+		}
+	}
+}
+
+func TestByPassStrictUTF8(t *testing.T) {
+	for _, test := range []struct {
+		pat     string
+		text    string
+		loc     []int // in strict UTF-8 mode
+		utf8Loc []int // by default
+	}{
+		{`a.b`, "a\xffb", nil, []int{0, 3}},
+		{`a.b`, "a\xffbaéb", []int{3, 7}, []int{0, 3}},
+		{`a.b`, "a\xef\xbf\xbdb", []int{0, 5}, []int{0, 5}}, // a valid U+FFFD
+		{`a[^x]b`, "a\xe2\x98b", nil, nil},                  // a truncated rune is two invalid bytes
+		{`a[^x]b`, "a\xe2b", nil, []int{0, 3}},
+		{`a[^x]{2}`, "a\xffa☺☺", []int{2, 9}, []int{0, 3}},
+		{`..`, "\xff☺a", []int{1, 5}, []int{0, 4}},
+		{`^id=.{3}$`, "id=ab\xff", nil, []int{0, 6}},
+		{`^id=.{3}$`, "id=ab☺", []int{0, 8}, []int{0, 8}},
+		{`x\pLy$`, "x\xffy", nil, nil},
+		{`^abc`, "abc\xff", []int{0, 3}, []int{0, 3}},
+		{`a.`, "a\xffa☺a", []int{2, 6}, []int{0, 2}},
+		{`a+b`, "a\xffaab", []int{2, 5}, []int{2, 5}},
+		{`^.{8}$`, "abc☺defg", []int{0, 10}, []int{0, 10}},
+		{`^.{8}$`, "abc\xffdefg", nil, []int{0, 8}},
+		{`^.{3,5}$`, "a☺b", []int{0, 5}, []int{0, 5}},
+		{`^.{3,5}$`, "a☺b\xff", nil, []int{0, 6}},
+		{`a.|b.`, "ab☺", []int{0, 2}, []int{0, 2}},
+		{`a.|b.`, "a\xffb☺", []int{2, 6}, []int{0, 2}},
+		{`.b|a`, "\xffba", []int{2, 3}, []int{0, 2}},
+		{`a.?b`, "a\xffbab", []int{3, 5}, []int{0, 3}},
+	} {
+		re, err := CompileStrictUTF8(test.pat)
+		if err != nil {
+			t.Errorf("pat: %s should have compiled in strict UTF-8 mode, got %v", test.pat, err)
+			continue
+		}
+		testByPassMatcherMethods(t, re, test.text, test.loc)
+		if got := MustCompile(test.pat).FindStringIndex(test.text); !reflect.DeepEqual(got, test.utf8Loc) {
+			t.Errorf("pat: %s text: %q got %v by default, want %v", test.pat, test.text, got, test.utf8Loc)
+		}
+	}
+
+	// Valid UTF-8 gives the same matches as the default mode
+	re := MustCompile(`a.[^b]`)
+	strict, _ := CompileStrictUTF8(`a.[^b]`)
+	if strict.ByPassKind() != ByPassValidUTF8 {
+		t.Errorf("pat: a.[^b] got %s in strict UTF-8 mode, want ValidUTF8", strict.ByPassKind())
+	}
+	for _, text := range []string{"", "aaa", "abb", "a☺é ab", "日本aéa☺"} {
+		if got, want := strict.FindIndex([]byte(text)), re.FindIndex([]byte(text)); !reflect.DeepEqual(got, want) {
+			t.Errorf("pat: a.[^b] text: %q got %v in strict UTF-8 mode, want %v", text, got, want)
+		}
+	}
+
+	for _, pat := range []string{`a.+`, `^a.{2,3}$`, `^[^/]+$`, `a.|b+`} {
+		if _, err := CompileStrictUTF8(pat); err == nil {
+			t.Errorf("pat: %s should not have compiled in strict UTF-8 mode", pat)
+		}
+	}
+}
+
+func TestByPassNonGreedy(t *testing.T) {
+	texts := []string{"", "xy", "xay", "xaay", "x\ny", "yx", "xyxy"}
+	for _, pats := range [][2]string{
+		{`x.+y`, `x.+?y`},
+		{`^x.+y$`, `^x.+?y$`},
+		{`^x[^/]+$`, `^x[^/]+?$`},
+		{`x.*y$`, `x.*?y$`},
+		{`^x.*y`, `^x.*?y`},
+		{`xa?y`, `xa??y`},
+		{`x.+y`, `(?U)x.+y`},
+		{`^x.*y`, `(?U)^x.*y`},
+	} {
+		greedy, nonGreedy := MustCompile(pats[0]), MustCompile(pats[1])
+		for _, text := range texts {
+			if greedy.MatchString(text) != nonGreedy.MatchString(text) {
+				t.Errorf("pats: %s and %s should give the same result on %q", pats[0], pats[1], text)
+			}
+		}
+	}
+}
+
+func TestByPassUngreedyFlag(t *testing.T) {
+	// `(?U)` only swaps greedy and non-greedy repetitions, it must not prevent the bypass
+	texts := []string{"", "abc", "aac", "a\nc", "aaa", "colour color", "/u/abc", "/u/a/b", "xaybyy", "abab"}
+	for _, pat := range []string{`(?U)abc`, `(?U)^a.c$`, `(?U)^a+$`, `(?U)^[a-z]*$`, `(?U)^x.*y`, `(?U)^ab.*`, `(?U)colou?r`, `(?U)a{2,3}`, `(?U)^/u/([^/]+)$`} {
+		re := MustCompile(pat)
+		if re.bypass == nil {
+			t.Errorf("pat: %s should have been bypassed", pat)
+			continue
+		}
+		std := regexp.MustCompile(pat)
+		for _, text := range texts {
+			if got, want := re.MatchString(text), std.MatchString(text); got != want {
+				t.Errorf("pat: %s text: %q got %t, want %t", pat, text, got, want)
+			}
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %v, want %v", pat, text, got, want)
+			}
+		}
+	}
+}
+
+func TestByPassVerboseFlag(t *testing.T) {
+	// `(?x)` isn't part of the RE2 syntax, so it never reaches the bypass matcher
+	_, err := Compile(`(?x)a b c`)
+	_, stderr := regexp.Compile(`(?x)a b c`)
+	if err == nil || stderr == nil || err.Error() != stderr.Error() {
+		t.Errorf("`(?x)a b c` should be rejected like in the standard library, got %v, want %v", err, stderr)
+	}
+}
+
+var overlappingTests = []struct {
+	pat     string
+	text    string
+	matches [][]int
+}{
+	{`aa`, "aaaa", [][]int{{0, 2}, {1, 3}, {2, 4}}},
+	{`a.a`, "a☺a☺a", [][]int{{0, 5}, {4, 9}}},
+	{`ACG|CGT`, "ACGT", [][]int{{0, 3}, {1, 4}}},
+	{`^aa`, "aaaa", [][]int{{0, 2}}},
+	{`aa$`, "aaaa", [][]int{{2, 4}}},
+	{`x`, "aaaa", nil},
+	{`a+`, "aaaa", nil},
+	{`^`, "aaaa", nil},
+}
+
+func TestByPassFindAllStringIndexOverlapping(t *testing.T) {
+	for _, test := range overlappingTests {
+		if got := MustCompile(test.pat).FindAllStringIndexOverlapping(test.text); !reflect.DeepEqual(got, test.matches) {
+			t.Errorf("pat: %s text: %q got %v, want %v", test.pat, test.text, got, test.matches)
+		}
+	}
+}
+
+func TestByPassCaptureFallback(t *testing.T) {
+	// Capturing groups are bypassed for matching, submatches still come from the other matchers
+	for _, pat := range []string{`(abc)(def)`, `x(.)y`, `^(a)b$`} {
+		re := MustCompile(pat)
+		if re.bypass == nil || re.bypassSubmatch != nil {
+			t.Errorf("pat: %s should have been bypassed without submatches", pat)
+			continue
+		}
+		if re.bypassIndex == nil {
+			t.Errorf("pat: %s should have been bypassed with match locations", pat)
+		}
+		std := regexp.MustCompile(pat)
+		for _, text := range []string{"abcdef", "xxayy", "x☺y", "ab", "abc"} {
+			// The same Regexp alternates between both paths
+			if got, want := re.MatchString(text), std.MatchString(text); got != want {
+				t.Errorf("pat: %s text: %q MatchString got %t, want %t", pat, text, got, want)
+			}
+			if got, want := re.FindStringSubmatch(text), std.FindStringSubmatch(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q got %q, want %q", pat, text, got, want)
+			}
+			if got, want := re.FindStringIndex(text), std.FindStringIndex(text); !reflect.DeepEqual(got, want) {
+				t.Errorf("pat: %s text: %q FindStringIndex got %v, want %v", pat, text, got, want)
+			}
+			if got, want := re.ReplaceAllString(text, "<$1>"), std.ReplaceAllString(text, "<$1>"); got != want {
+				t.Errorf("pat: %s text: %q ReplaceAllString got %q, want %q", pat, text, got, want)
+			}
+		}
+	}
+}
+
+var compileStrictTests = []struct {
+	pat string
+	err string
+}{
+	{`x.y$`, ""},
+	{`^/users/([^/]+)$`, ""},
+	{`(a+)(b+)`, "regexp: `(a+)(b+)` can't be bypassed: unsupported Plus in `a+`"},
+	{`a|b*`, "regexp: `a|b*` can't be bypassed: unsupported Star in `b*`"},
+	{`a\b.`, "regexp: `a\\b.` can't be bypassed: unsupported WordBoundary in `\\b`"},
+	{`(?m)ab`, "regexp: `(?m)ab` can't be bypassed: unsupported flags in `ab`"},
+	{`a?b?c?d?e?`, "regexp: `a?b?c?d?e?` can't be bypassed: more than 16 expansions of `?` and bounded repeats in `a?b?c?d?e?`"},
+	{`a(`, "error parsing regexp: missing closing ): `a(`"},
+}
+
+func TestByPassCompileStrict(t *testing.T) {
+	for _, test := range compileStrictTests {
+		re, err := CompileStrict(test.pat)
+		if test.err == "" {
+			if err != nil || re == nil {
+				t.Errorf("pat: %s should have compiled, got %v", test.pat, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.err {
+			t.Errorf("pat: %s got error %v, want %s", test.pat, err, test.err)
+		}
+	}
+}
+
+func TestByPassError(t *testing.T) {
+	for _, test := range []struct {
+		pat    string
+		op     syntax.Op
+		node   string
+		offset int
+	}{
+		{`(a+)b`, syntax.OpPlus, `a+`, 1}, // a standalone `(a+)` is bypassed
+		{`x(a+)(b*)`, syntax.OpPlus, `a+`, 2},
+		{`\d+x\d+`, syntax.OpPlus, `[0-9]+`, -1},
+		{`a\b.`, syntax.OpWordBoundary, `\b`, 1},
+		{`a?b?c?d?e?`, 0, `a?b?c?d?e?`, -1},
+	} {
+		_, err := CompileStrict(test.pat)
+		var bypassErr *ByPassError
+		if !errors.As(err, &bypassErr) {
+			t.Errorf("pat: %s got error %v, want a *ByPassError", test.pat, err)
+			continue
+		}
+		if bypassErr.Expr != test.pat || bypassErr.Op != test.op || bypassErr.Node != test.node || bypassErr.Offset != test.offset {
+			t.Errorf("pat: %s got %+v, want Op=%s Node=%s Offset=%d", test.pat, *bypassErr, test.op, test.node, test.offset)
+		}
+		if bypassErr.Error() != "regexp: "+quote(test.pat)+" can't be bypassed: "+bypassErr.Reason {
+			t.Errorf("pat: %s got message %q", test.pat, bypassErr.Error())
+		}
+	}
+
+	// Parse errors aren't ByPassErrors
+	var bypassErr *ByPassError
+	if _, err := CompileStrict(`a(`); errors.As(err, &bypassErr) {
+		t.Errorf("pat: a( got a *ByPassError for a parse error")
+	}
+}
+
+func TestByPassCompileAll(t *testing.T) {
+	patterns := []string{`^abc`, `a.b`, `^a.b$`, `jpg|png|gif?`, `^/users/([^/]+)$`, `a+b+`, `a(`, `a^b`}
+	want := []ByPassKind{ByPassLiteral, ByPassUnanchored, ByPassAnchored, ByPassAlternate, ByPassPlus, ByPassNone, ByPassNone, ByPassUnmatchable}
+
+	regexps, kinds, errs := CompileAll(patterns)
+	if len(regexps) != len(patterns) || len(kinds) != len(patterns) || len(errs) != len(patterns) {
+		t.Fatalf("got %d regexps, %d kinds, %d errors for %d patterns", len(regexps), len(kinds), len(errs), len(patterns))
+	}
+	for i, pat := range patterns {
+		if kinds[i] != want[i] {
+			t.Errorf("pat: %s got kind %s, want %s", pat, kinds[i], want[i])
+		}
+		if (errs[i] != nil) != (regexps[i] == nil) {
+			t.Errorf("pat: %s got regexp %v and error %v", pat, regexps[i], errs[i])
+		}
+	}
+	if errs[6] == nil {
+		t.Errorf("pat: %s should not have compiled", patterns[6])
+	}
+}
+
 func TestByPassGithubRegexps(t *testing.T) {
 
-	var total, linearUnanchored, linearAnchored, alt, firstpass, supported, unsupported, invalid, unmatchable int
+	var total, linearUnanchored, linearAnchored, literal, lengthrange, alt, prefixalt, firstpass, plus, dotstar, prefixdotstar, supported, unsupported, invalid, unmatchable int
 
 	f, _ := os.Open("testdata/github-regexp.csv")
 	defer f.Close()
@@ -76,16 +2654,28 @@ func TestByPassGithubRegexps(t *testing.T) {
 
 		supported += n
 
-		switch reflect.TypeOf(re.bypass).String() {
-		case "*regexp.byPassProgAnchored":
+		switch re.ByPassKind() {
+		case ByPassAnchored:
 			linearAnchored += n
-		case "*regexp.byPassProgUnanchored":
+		case ByPassUnanchored:
 			linearUnanchored += n
-		case "*regexp.byPassProgAlternate":
+		case ByPassLiteral:
+			literal += n
+		case ByPassLengthRange:
+			lengthrange += n
+		case ByPassAlternate:
 			alt += n
-		case "*regexp.byPassProgFirstPass":
+		case ByPassPrefixAlternate:
+			prefixalt += n
+		case ByPassFirstPass:
 			firstpass += n
-		case "*regexp.byPassProgUnmatchable":
+		case ByPassPlus:
+			plus += n
+		case ByPassPrefixDotStar:
+			prefixdotstar += n
+		case ByPassDotStarSuffix:
+			dotstar += n
+		case ByPassUnmatchable:
 			unmatchable += n
 		}
 
@@ -98,8 +2688,14 @@ func TestByPassGithubRegexps(t *testing.T) {
 	t.Logf("Supported total                                 %d (%0.2f%%)", supported, float64(supported*100)/float64(total))
 	t.Logf(" Supported with byPassProgAnchored              %d (%0.2f%%)", linearAnchored, float64(linearAnchored*100)/float64(total))
 	t.Logf(" Supported with byPassProgUnanchored            %d (%0.2f%%)", linearUnanchored, float64(linearUnanchored*100)/float64(total))
+	t.Logf(" Supported with byPassProgLiteral               %d (%0.2f%%)", literal, float64(literal*100)/float64(total))
+	t.Logf(" Supported with byPassProgLengthRange           %d (%0.2f%%)", lengthrange, float64(lengthrange*100)/float64(total))
 	t.Logf(" Supported with byPassProgAlternate             %d (%0.2f%%)", alt, float64(alt*100)/float64(total))
+	t.Logf(" Supported with byPassProgPrefixAlternate       %d (%0.2f%%)", prefixalt, float64(prefixalt*100)/float64(total))
 	t.Logf(" Supported with byPassProgFirstPass             %d (%0.2f%%)", firstpass, float64(firstpass*100)/float64(total))
+	t.Logf(" Supported with byPassProgPlus                  %d (%0.2f%%)", plus, float64(plus*100)/float64(total))
+	t.Logf(" Supported with byPassProgDotStarSuffix         %d (%0.2f%%)", dotstar, float64(dotstar*100)/float64(total))
+	t.Logf(" Supported with byPassProgPrefixDotStar         %d (%0.2f%%)", prefixdotstar, float64(prefixdotstar*100)/float64(total))
 	t.Logf(" Supported with byPassProgUnmatchable           %d (%0.2f%%)", unmatchable, float64(unmatchable*100)/float64(total))
 
 }
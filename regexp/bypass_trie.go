@@ -0,0 +1,227 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+// byPassTrieNode is a node of the tries of literals used by byPassProgSuffixes and byPassProgPrefixTrie
+type byPassTrieNode struct {
+	labels   []byte            // next bytes in the literals
+	children []*byPassTrieNode // next node for each of the labels
+	terminal bool              // if true, the bytes from the root to this node are a whole literal
+	priority int               // index of the first alternative ending at this node, if terminal
+}
+
+// byPassMaxTrieLiterals is the maximum number of literals an alternation can be expanded to in a byPassProgPrefixTrie
+const byPassMaxTrieLiterals = 256
+
+// byPassProgPrefixTrie can match alternations of literals anchored at the beginning (e.g. `^(?:GET|POST|PUT) `).
+// The literals are stored in a trie, so the string is only read once from its beginning, whatever their number.
+type byPassProgPrefixTrie struct {
+	root        *byPassTrieNode
+	count       int   // number of distinct literals
+	lengths     []int // length of each distinct literal, in runes
+	maxWidth    int   // length of the longest literal
+	anchoredEnd bool  // if true, the literal must span the whole string (e.g. `^(?:GET|POST)$`)
+}
+
+// child returns the next node for a byte, or nil if there is none.
+// Nodes only have a few children, so a linear scan is faster than a map.
+func (node *byPassTrieNode) child(char byte) *byPassTrieNode {
+	for i, label := range node.labels {
+		if label == char {
+			return node.children[i]
+		}
+	}
+	return nil
+}
+
+// insert returns the next node for a byte, adding it if needed
+func (node *byPassTrieNode) insert(char byte) *byPassTrieNode {
+	child := node.child(char)
+	if child == nil {
+		child = &byPassTrieNode{}
+		node.labels = append(node.labels, char)
+		node.children = append(node.children, child)
+	}
+	return child
+}
+
+// compileByPassPrefixTrie finds out if the tree is an alternation of literals anchored at the beginning,
+// maybe followed by other literals and `$` (`^(?:GET|POST|PUT) `)
+func compileByPassPrefixTrie(tree *syntax.Regexp) byPassProg {
+
+	if tree.Op != syntax.OpConcat || len(tree.Sub) < 2 || tree.Sub[0].Op != syntax.OpBeginText {
+		return notByPass
+	}
+	subs := tree.Sub[1:]
+	anchoredEnd := false
+	if last := len(subs) - 1; subs[last].Op == syntax.OpEndText {
+		anchoredEnd = true
+		subs = subs[:last]
+	}
+
+	literals := []string{""}
+	for _, sub := range subs {
+		literals = appendLiterals(literals, sub)
+		if literals == nil {
+			return notByPass
+		}
+	}
+	return newByPassProgPrefixTrie(literals, anchoredEnd)
+}
+
+// compileByPassAlternateTrie returns a trie for top-level alternations of literals anchored at the
+// beginning (`^GET|^POST`), or nil if some parts of the alternation are something else
+func compileByPassAlternateTrie(progalt *byPassProgAlternate) *byPassProgPrefixTrie {
+	literals := make([]string, 0, len(progalt.progs))
+	anchoredEnd := false
+	for i, subprog := range progalt.progs {
+		literalprog, ok := subprog.(*byPassProgLiteral)
+		if !ok || !literalprog.anchoredBegin || literalprog.wordBoundaryEnd || i > 0 && literalprog.anchoredEnd != anchoredEnd {
+			return nil
+		}
+		anchoredEnd = literalprog.anchoredEnd
+		literals = append(literals, literalprog.literal)
+	}
+	return newByPassProgPrefixTrie(literals, anchoredEnd)
+}
+
+// newByPassProgPrefixTrie builds the trie of the literals, which are in the order of the alternation
+func newByPassProgPrefixTrie(literals []string, anchoredEnd bool) *byPassProgPrefixTrie {
+	prog := &byPassProgPrefixTrie{root: &byPassTrieNode{}, anchoredEnd: anchoredEnd}
+	for priority, literal := range literals {
+		node := prog.root
+		for i := 0; i < len(literal); i++ {
+			node = node.insert(literal[i])
+		}
+		// Duplicates keep the priority of their first occurrence
+		if !node.terminal {
+			node.terminal = true
+			node.priority = priority
+			prog.count++
+			prog.lengths = append(prog.lengths, utf8.RuneCountInString(literal))
+		}
+		if len(literal) > prog.maxWidth {
+			prog.maxWidth = len(literal)
+		}
+	}
+	return prog
+}
+
+// appendLiterals returns the literals followed by each string the tree can match, in the order
+// a backtracking matcher would try them, or nil if the tree isn't made only of literals and
+// alternations or if there would be more than byPassMaxTrieLiterals.
+func appendLiterals(literals []string, tree *syntax.Regexp) []string {
+
+	var alternatives []string
+
+	switch tree.Op {
+	case syntax.OpEmptyMatch:
+		return literals
+
+	case syntax.OpLiteral:
+		// `(?i)k` also matches the Kelvin sign
+		if tree.Flags&syntax.FoldCase != 0 {
+			return nil
+		}
+		// U+FFFD also matches invalid UTF-8, which the trie can't see
+		if hasRuneError(tree.Rune) {
+			return nil
+		}
+		alternatives = []string{string(tree.Rune)}
+
+	case syntax.OpCharClass:
+		// Small classes come from factored alternations (`^(?:a|b)c` => `^[a-b]c`). As UTF-8 is
+		// prefix-free, their order doesn't change which one matches first.
+		for i := 0; i < len(tree.Rune); i += 2 {
+			for char := tree.Rune[i]; char <= tree.Rune[i+1]; char++ {
+				if len(alternatives) >= byPassMaxTrieLiterals || !utf8.ValidRune(char) || char == utf8.RuneError {
+					return nil
+				}
+				alternatives = append(alternatives, string(char))
+			}
+		}
+
+	case syntax.OpCapture:
+		return appendLiterals(literals, tree.Sub[0])
+
+	case syntax.OpConcat:
+		for _, sub := range tree.Sub {
+			if literals = appendLiterals(literals, sub); literals == nil {
+				return nil
+			}
+		}
+		return literals
+
+	case syntax.OpAlternate:
+		for _, sub := range tree.Sub {
+			subliterals := appendLiterals([]string{""}, sub)
+			if subliterals == nil {
+				return nil
+			}
+			alternatives = append(alternatives, subliterals...)
+		}
+
+	default:
+		return nil
+	}
+
+	if len(literals)*len(alternatives) > byPassMaxTrieLiterals {
+		return nil
+	}
+	product := make([]string, 0, len(literals)*len(alternatives))
+	for _, literal := range literals {
+		for _, alternative := range alternatives {
+			product = append(product, literal+alternative)
+		}
+	}
+	return product
+}
+
+func (prog *byPassProgPrefixTrie) MatchString(s string) (matched bool) {
+	node := prog.root
+	for i := 0; ; i++ {
+		if node.terminal && (!prog.anchoredEnd || i == len(s)) {
+			return true
+		}
+		if i == len(s) {
+			return false
+		}
+		if node = node.child(s[i]); node == nil {
+			return false
+		}
+	}
+}
+
+func (prog *byPassProgPrefixTrie) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+
+	if pos > 0 {
+		return -1, -1
+	}
+
+	// All the literals start at the beginning of the string: the first alternative matching wins, not the longest one
+	matchEnd = -1
+	priority := 0
+	node := prog.root
+	for i := 0; ; i++ {
+		if node.terminal && (!prog.anchoredEnd || i == len(s)) && (matchEnd == -1 || node.priority < priority) {
+			matchEnd, priority = i, node.priority
+		}
+		if i == len(s) {
+			break
+		}
+		if node = node.child(s[i]); node == nil {
+			break
+		}
+	}
+	if matchEnd == -1 {
+		return -1, -1
+	}
+	return 0, matchEnd
+}
@@ -0,0 +1,116 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"errors"
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+// byPassProgValidUTF8 wraps a fixed-length byPassProg compiled by CompileStrictUTF8,
+// and rejects its matches containing invalid UTF-8
+type byPassProgValidUTF8 struct {
+	prog byPassProg // *byPassProgAnchored, *byPassProgUnanchored or *byPassProgLengthRange
+}
+
+// CompileStrictUTF8 is like Compile, but in the bypass matcher `.` and character
+// classes don't match invalid UTF-8 bytes, only well-formed runes. By default, they
+// match each invalid byte as U+FFFD, like the other matchers. It can be used to check
+// that the parts of the input matched by `.` (`^id=.{8}$`) are valid UTF-8.
+//
+// Only fixed-length patterns supported by the bypass matcher, `^.{n,m}$` and alternations
+// of those can be compiled in strict UTF-8 mode, unless they can't match invalid UTF-8
+// anyway (`^abc`). The other matchers
+// still match invalid bytes as U+FFFD, so the returned ByPassMatcher only has the methods
+// that the bypass matcher executes.
+func CompileStrictUTF8(expr string) (*ByPassMatcher, error) {
+	re, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if prog := strictUTF8Prog(re.bypass); prog != nil {
+		re.setByPass(prog)
+		return &ByPassMatcher{re: re}, nil
+	}
+	tree, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	if matchesInvalidUTF8(tree.Simplify()) {
+		return nil, errors.New("regexp: " + quote(expr) + " can't be compiled in strict UTF-8 mode: `.` and classes are only supported in fixed-length patterns, `^.{n,m}$` and alternations of those")
+	}
+	return &ByPassMatcher{re: re}, nil
+}
+
+// strictUTF8Prog returns prog with its parts that can match invalid UTF-8 wrapped in a
+// byPassProgValidUTF8, or nil if some of them can't be wrapped
+func strictUTF8Prog(prog byPassProg) byPassProg {
+	switch p := prog.(type) {
+	case *byPassProgAnchored, *byPassProgUnanchored, *byPassProgLengthRange:
+		return &byPassProgValidUTF8{prog: prog}
+	case *byPassProgLiteral, *byPassProgEmpty, *byPassProgUnmatchable:
+		return prog
+	case *byPassProgAlternate:
+		// Each part skips its own invalid matches, so the leftmost-first choice between them
+		// is unchanged. byWidth would only know the unwrapped parts, so it isn't set.
+		strict := &byPassProgAlternate{progs: make([]byPassProg, len(p.progs))}
+		changed := false
+		for i, subprog := range p.progs {
+			if strict.progs[i] = strictUTF8Prog(subprog); strict.progs[i] == nil {
+				return nil
+			}
+			changed = changed || strict.progs[i] != subprog
+		}
+		if !changed {
+			return prog
+		}
+		return strict
+	}
+	return nil
+}
+
+// matchesInvalidUTF8 returns true if the tree has nodes that can match invalid UTF-8 bytes as U+FFFD
+func matchesInvalidUTF8(tree *syntax.Regexp) bool {
+	switch tree.Op {
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return true
+	case syntax.OpLiteral:
+		return hasRuneError(tree.Rune)
+	case syntax.OpCharClass:
+		for i := 0; i < len(tree.Rune); i += 2 {
+			if tree.Rune[i] <= utf8.RuneError && utf8.RuneError <= tree.Rune[i+1] {
+				return true
+			}
+		}
+	}
+	for _, sub := range tree.Sub {
+		if matchesInvalidUTF8(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func (prog *byPassProgValidUTF8) MatchString(s string) (matched bool) {
+	matchBegin, _ := prog.IndexString(s, 0)
+	return matchBegin != -1
+}
+
+// IndexString skips the matches containing invalid UTF-8. Literals never match invalid
+// bytes, so they can only have been matched by `.` or a class. Fixed-length patterns have
+// a single match at each position, so the next one can only start at the next rune.
+func (prog *byPassProgValidUTF8) IndexString(s string, pos int) (matchBegin int, matchEnd int) {
+	indexProg := prog.prog.(byPassIndexProg)
+	for pos <= len(s) {
+		matchBegin, matchEnd = indexProg.IndexString(s, pos)
+		if matchBegin == -1 || utf8.ValidString(s[matchBegin:matchEnd]) {
+			return matchBegin, matchEnd
+		}
+		_, width := utf8.DecodeRuneInString(s[matchBegin:])
+		pos = matchBegin + width
+	}
+	return -1, -1
+}
@@ -0,0 +1,47 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"errors"
+	"regexp/syntax"
+)
+
+// CompileWordChars is like Compile, but in the bypass matcher `\b` uses isWordChar
+// to tell word characters apart, instead of the ASCII ones of RE2 (`[0-9A-Za-z_]`).
+// For instance, with unicode.IsLetter, `café\b` doesn't match "cafés" but
+// matches "café!".
+//
+// Only literals followed by `\b` (`foo\b`, `^foo\b`) can be compiled with custom
+// word characters. Patterns without `\b` are compiled as usual. The other matchers
+// still use the ASCII word characters, so the returned ByPassMatcher only has the
+// methods that the bypass matcher executes.
+func CompileWordChars(expr string, isWordChar func(rune) bool) (*ByPassMatcher, error) {
+	re, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	tree = tree.Simplify()
+	if !hasOps([]*syntax.Regexp{tree}, []syntax.Op{syntax.OpWordBoundary, syntax.OpNoWordBoundary}) {
+		return &ByPassMatcher{re: re}, nil
+	}
+
+	if tree.Op == syntax.OpConcat && len(tree.Sub) > 1 {
+		switch prog := compileByPassWordBoundary(tree, isWordChar).(type) {
+		case *byPassProgLiteral:
+			prog.isWordChar = isWordChar
+			re.setByPass(prog)
+			return &ByPassMatcher{re: re}, nil
+		case *byPassProgUnmatchable:
+			re.setByPass(prog)
+			return &ByPassMatcher{re: re}, nil
+		}
+	}
+	return nil, errors.New("regexp: " + quote(expr) + " can't be compiled with custom word characters: only literals followed by `\\b` are supported")
+}
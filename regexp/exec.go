@@ -418,6 +418,14 @@ func (re *Regexp) doMatch(r io.RuneReader, b []byte, s string) bool {
 // nil is returned if no matches are found and non-nil if matches are found.
 func (re *Regexp) doExecute(r io.RuneReader, b []byte, s string, pos int, ncap int, dstCap []int) []int {
 	m := re.get()
+	dstCap = m.execute(r, b, s, pos, ncap, dstCap)
+	re.put(m)
+	return dstCap
+}
+
+// execute is like doExecute, but runs on a machine the caller already got
+// from the cache, so that it can be reused for several inputs.
+func (m *machine) execute(r io.RuneReader, b []byte, s string, pos int, ncap int, dstCap []int) []int {
 	var i input
 	var size int
 	if r != nil {
@@ -431,7 +439,6 @@ func (re *Regexp) doExecute(r io.RuneReader, b []byte, s string, pos int, ncap i
 	}
 	if m.op != notOnePass {
 		if !m.onepass(i, pos, ncap) {
-			re.put(m)
 			return nil
 		}
 	} else if size < m.maxBitStateLen && r == nil {
@@ -439,13 +446,11 @@ func (re *Regexp) doExecute(r io.RuneReader, b []byte, s string, pos int, ncap i
 			m.b = newBitState(m.p)
 		}
 		if !m.backtrack(i, pos, size, ncap) {
-			re.put(m)
 			return nil
 		}
 	} else {
 		m.init(ncap)
 		if !m.match(i, pos) {
-			re.put(m)
 			return nil
 		}
 	}
@@ -454,7 +459,6 @@ func (re *Regexp) doExecute(r io.RuneReader, b []byte, s string, pos int, ncap i
 		// Keep the promise of returning non-nil value on match.
 		dstCap = arrayNoInts[:0]
 	}
-	re.put(m)
 	return dstCap
 }
 
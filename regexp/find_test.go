@@ -364,6 +364,66 @@ func TestFindAllStringIndex(t *testing.T) {
 	}
 }
 
+func TestAppendStringIndex(t *testing.T) {
+	dst := []int{-1}
+	for _, test := range findTests {
+		dst = MustCompile(test.pat).AppendStringIndex(dst[:1], test.text)
+		if dst[0] != -1 || len(dst)%2 != 1 {
+			t.Errorf("expected the pairs appended after -1 got %v: %s", dst, test)
+			continue
+		}
+		var result [][]int
+		for i := 1; i < len(dst); i += 2 {
+			result = append(result, dst[i:i+2])
+		}
+		testFindAllIndex(&test, result, t)
+	}
+}
+
+func TestAppendStringIndexManyMatches(t *testing.T) {
+	text := strings.Repeat("12 ab 7 ☺", 3000)
+	var dst []int
+	for _, pat := range []string{`[0-9]{1,3}`, `\d??[^a]?\s{0,2}`, `colou?r|a`, `\d`, `a*`, `b\b`} {
+		re := MustCompile(pat)
+		dst = re.AppendStringIndex(dst[:0], text)
+		want := re.FindAllStringIndex(text, -1)
+		if len(dst) != 2*len(want) {
+			t.Errorf("pat: %s got %d matches, want %d", pat, len(dst)/2, len(want))
+			continue
+		}
+		for i, loc := range want {
+			if dst[2*i] != loc[0] || dst[2*i+1] != loc[1] {
+				t.Errorf("pat: %s match %d got [%d %d], want %v", pat, i, dst[2*i], dst[2*i+1], loc)
+				break
+			}
+		}
+	}
+}
+
+func TestAllStringIndex(t *testing.T) {
+	for _, test := range findTests {
+		var result [][]int
+		for loc := range MustCompile(test.pat).AllStringIndex(test.text) {
+			result = append(result, loc)
+		}
+		testFindAllIndex(&test, result, t)
+	}
+
+	// Breaking out of the loop stops the search
+	re := MustCompile(`a`)
+	count := 0
+	for loc := range re.AllStringIndex("banana") {
+		count++
+		if loc[0] != 1 || loc[1] != 2 {
+			t.Errorf("expected [1 2] got %v", loc)
+		}
+		break
+	}
+	if count != 1 {
+		t.Errorf("expected 1 iteration got %d", count)
+	}
+}
+
 // Now come the Submatch cases.
 
 func testSubmatchBytes(test *FindTest, n int, submatches []int, result [][]byte, t *testing.T) {
@@ -66,18 +66,22 @@ package regexp
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"regexp/syntax"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"unicode"
 	"unicode/utf8"
 )
 
 // Regexp is the representation of a compiled regular expression.
 // A Regexp is safe for concurrent use by multiple goroutines,
-// except for configuration methods, such as Longest.
+// except for configuration methods, such as Longest. The bypass
+// matchers don't need the cache of machines, so they don't lock.
 type Regexp struct {
 	// read-only after Compile
 	regexpRO
@@ -85,22 +89,31 @@ type Regexp struct {
 	// cache of machines for running regexp
 	mu      sync.Mutex
 	machine []*machine
+	last    *Regexp // compiled by lastStart on first use, guarded by mu
 }
 
 type regexpRO struct {
-	expr           string         // as passed to Compile
-	prog           *syntax.Prog   // compiled program
-	onepass        *onePassProg   // onepass program or nil
-	bypass         byPassProg     // bypass program or nil
-	prefix         string         // required prefix in unanchored matches
-	prefixBytes    []byte         // prefix, as a []byte
-	prefixComplete bool           // prefix is the entire regexp
-	prefixRune     rune           // first rune in prefix
-	prefixEnd      uint32         // pc for last rune in prefix
-	cond           syntax.EmptyOp // empty-width conditions required at start of match
-	numSubexp      int
-	subexpNames    []string
-	longest        bool
+	expr            string              // as passed to Compile
+	mode            syntax.Flags        // flags expr was parsed with
+	prog            *syntax.Prog        // compiled program
+	onepass         *onePassProg        // onepass program or nil
+	bypass          byPassProg          // bypass program or nil
+	bypassIndex     byPassIndexProg     // bypass program able to locate matches or nil
+	bypassLastIndex byPassLastIndexProg // bypass program able to locate the last match or nil
+	bypassSubmatch  byPassSubmatchProg  // bypass program able to report submatches or nil
+	bypassLines     byPassProg          // bypass program matching single lines of a multi-line pattern, for MatchLines, or nil
+	stats           *byPassStats        // match statistics, nil unless byPassStatsEnabled
+	minLength       int                 // minimum number of runes in a match of bypass, 0 if unknown
+	verify          bool                // if true, MatchString checks bypass against the other matchers
+	prefix          string              // required prefix in unanchored matches
+	prefixBytes     []byte              // prefix, as a []byte
+	prefixComplete  bool                // prefix is the entire regexp
+	prefixRune      rune                // first rune in prefix
+	prefixEnd       uint32              // pc for last rune in prefix
+	cond            syntax.EmptyOp      // empty-width conditions required at start of match
+	numSubexp       int
+	subexpNames     []string
+	longest         bool
 }
 
 // String returns the source text used to compile the regular expression.
@@ -165,6 +178,28 @@ func CompilePOSIX(expr string) (*Regexp, error) {
 // with any other methods.
 func (re *Regexp) Longest() {
 	re.longest = true
+	// The bypass matchers only locate leftmost-first matches
+	re.bypassIndex = nil
+	re.bypassLastIndex = nil
+}
+
+// Reset recompiles re in place with expr, like Compile, so that pools of
+// matchers can reuse the same Regexp when their patterns change. All the
+// compiled state, including the bypass program and the cached machines, is
+// replaced. If expr doesn't compile, re is left unchanged.
+// This method modifies the Regexp and may not be called concurrently
+// with any other methods.
+func (re *Regexp) Reset(expr string) error {
+	compiled, err := Compile(expr)
+	if err != nil {
+		return err
+	}
+	re.mu.Lock()
+	re.regexpRO = compiled.regexpRO
+	re.machine = nil
+	re.last = nil
+	re.mu.Unlock()
+	return nil
 }
 
 func compile(expr string, mode syntax.Flags, longest bool) (*Regexp, error) {
@@ -183,15 +218,19 @@ func compile(expr string, mode syntax.Flags, longest bool) (*Regexp, error) {
 	regexp := &Regexp{
 		regexpRO: regexpRO{
 			expr:        expr,
+			mode:        mode,
 			prog:        prog,
 			onepass:     compileOnePass(prog),
-			bypass:      compileByPass(re),
 			numSubexp:   maxCap,
 			subexpNames: capNames,
 			cond:        prog.StartCond(),
 			longest:     longest,
 		},
 	}
+	regexp.setByPass(compileByPass(re))
+	if regexp.bypass == notByPass {
+		regexp.bypassLines = compileByPassLines(re)
+	}
 	if regexp.onepass == notOnePass {
 		regexp.prefix, regexp.prefixComplete = prog.Prefix()
 	} else {
@@ -436,13 +475,299 @@ func (re *Regexp) MatchReader(r io.RuneReader) bool {
 func (re *Regexp) MatchString(s string) bool {
 
 	if re.bypass != notByPass {
+		if byPassStatsEnabled && re.stats != nil {
+			atomic.AddUint64(&re.stats.byPass, 1)
+		}
+		if re.verify {
+			return re.matchStringVerified(s)
+		}
 		return re.bypass.MatchString(s)
 	}
+	if byPassStatsEnabled && re.stats != nil {
+		atomic.AddUint64(&re.stats.fallback, 1)
+	}
 	return re.doMatch(nil, nil, s)
 }
 
+// MatchStringLimit is like MatchString but reports no match, without scanning
+// s, when s is longer than maxBytes. It can be used to reject huge inputs
+// early. A negative maxBytes means no limit.
+func (re *Regexp) MatchStringLimit(s string, maxBytes int) bool {
+	if maxBytes >= 0 && len(s) > maxBytes {
+		return false
+	}
+	return re.MatchString(s)
+}
+
+// MatchStringFrom reports whether the Regexp matches s[start:], as if the input
+// began at byte offset start: `^` and `\A` match at start, and the bytes before it
+// aren't looked at, even by `\b`. It can be used to scan a buffer with a moving
+// window; no copy of s is made. It panics if start is negative or greater than
+// len(s).
+func (re *Regexp) MatchStringFrom(s string, start int) bool {
+	if start < 0 || start > len(s) {
+		panic("regexp: MatchStringFrom: start " + strconv.Itoa(start) + " out of range [0:" + strconv.Itoa(len(s)) + "]")
+	}
+	return re.MatchString(s[start:])
+}
+
+// MatchPrefix reports whether the Regexp matches at the beginning of s and, if
+// so, the number of bytes of s consumed by the match, which is the one
+// FindStringIndex would return. It lets parsers consume a token, like `^\d{3}`,
+// and continue after it.
+func (re *Regexp) MatchPrefix(s string) (matched bool, n int) {
+
+	if prog, ok := re.bypass.(*byPassProgAnchored); ok {
+		if byPassStatsEnabled && re.stats != nil {
+			atomic.AddUint64(&re.stats.byPass, 1)
+		}
+		return prog.MatchPrefix(s)
+	}
+	loc := re.FindStringIndex(s)
+	if loc == nil || loc[0] != 0 {
+		return false, 0
+	}
+	return true, loc[1]
+}
+
+// MatchStringContext is like MatchString but gives up with ctx.Err() when ctx is
+// done. The context is checked every 64KB of s, so that scans of huge inputs can
+// be cancelled: unanchored fixed-length patterns are matched in chunks by the
+// bypass matcher, and the other matchers read s through a RuneReader, which is
+// slower. Patterns only looking at both ends of s (`^abc`) are matched at once.
+func (re *Regexp) MatchStringContext(ctx context.Context, s string) (matched bool, err error) {
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if len(s) <= byPassContextChunk || re.bypass != notByPass && isByPassBounded(re.bypass) {
+		return re.MatchString(s), nil
+	}
+	if re.bypass != notByPass && !re.verify && isByPassChunkable(re.bypass) {
+		if byPassStatsEnabled && re.stats != nil {
+			atomic.AddUint64(&re.stats.byPass, 1)
+		}
+		// Chunks overlap by the maximum width of a match, so that matches across their boundaries are found
+		return matchStringChunks(ctx, re.bypass, byPassMaxWidth(re.bypass), s)
+	}
+
+	if byPassStatsEnabled && re.stats != nil {
+		atomic.AddUint64(&re.stats.fallback, 1)
+	}
+	r := &contextReader{ctx: ctx, s: s}
+	matched = re.doMatch(r, nil, "")
+	if r.err != nil {
+		return false, r.err
+	}
+	return matched, nil
+}
+
+// ScanReader reports whether the stream read from r contains any match of re,
+// reading it in chunks of 64KB instead of loading it whole, so that huge files can
+// be searched. Consecutive chunks overlap by the maximum width of a match, minus
+// one byte, so that matches across their boundaries are found.
+// Only unanchored literals and fixed-length patterns executed by the bypass
+// matcher can be scanned: for the other ones, and on read errors, an error is
+// returned.
+func (re *Regexp) ScanReader(r io.Reader) (matched bool, err error) {
+
+	width := byPassMaxWidth(re.bypass)
+	switch prog := re.bypass.(type) {
+	case *byPassProgLiteral:
+		// The end of a chunk isn't the end of the stream for `$` and `\b`
+		if prog.anchoredBegin || prog.anchoredEnd || prog.wordBoundaryEnd {
+			width = -1
+		}
+	case *byPassProgUnanchored:
+	default:
+		width = -1
+	}
+	if width == -1 {
+		return false, errors.New("regexp: " + quote(re.expr) + " can't be scanned in chunks: only unanchored literals and fixed-length patterns are supported")
+	}
+
+	if byPassStatsEnabled && re.stats != nil {
+		atomic.AddUint64(&re.stats.byPass, 1)
+	}
+	return scanReader(re.bypass, width-1, r)
+}
+
+// FindAlternateLiteral returns which literal of an alternation of literals, like
+// `cat|dog|bird`, is found by the leftmost match in s, without a second pass to
+// extract it. found is false if there is no match, or if re isn't an alternation
+// of literals executed by the bypass matcher.
+func (re *Regexp) FindAlternateLiteral(s string) (literal string, found bool) {
+
+	if re.bypassIndex == nil {
+		return "", false
+	}
+	if prog, ok := re.bypass.(*byPassProgAlternate); ok {
+		return prog.FindAlternateLiteral(s)
+	}
+	if !isLiteralAlternation(re.bypass) {
+		return "", false
+	}
+	// The match is one of the literals
+	matchBegin, matchEnd := re.bypassIndex.IndexString(s, 0)
+	if matchBegin == -1 {
+		return "", false
+	}
+	return s[matchBegin:matchEnd], true
+}
+
+// MatchStrings reports, for each string in inputs, whether it contains any match of
+// the regular expression. It is equivalent to calling MatchString on each of them,
+// but a single machine is used for the whole batch.
+func (re *Regexp) MatchStrings(inputs []string) []bool {
+	matched := make([]bool, len(inputs))
+
+	if re.bypass != notByPass {
+		if byPassStatsEnabled && re.stats != nil {
+			atomic.AddUint64(&re.stats.byPass, uint64(len(inputs)))
+		}
+		for i, s := range inputs {
+			matched[i] = re.bypass.MatchString(s)
+		}
+		return matched
+	}
+	if byPassStatsEnabled && re.stats != nil {
+		atomic.AddUint64(&re.stats.fallback, uint64(len(inputs)))
+	}
+
+	m := re.get()
+	for i, s := range inputs {
+		matched[i] = m.execute(nil, nil, s, 0, 0, nil) != nil
+	}
+	re.put(m)
+	return matched
+}
+
+// MatchEachBytes reports, for each byte slice in lines, whether it contains any
+// match of the regular expression. It is equivalent to calling Match on each of
+// them, without joining them, and a single machine is used for the whole batch.
+func (re *Regexp) MatchEachBytes(lines [][]byte) []bool {
+	matched := make([]bool, len(lines))
+
+	if re.bypass != notByPass {
+		if byPassStatsEnabled && re.stats != nil {
+			atomic.AddUint64(&re.stats.byPass, uint64(len(lines)))
+		}
+		for i, b := range lines {
+			matched[i] = re.bypass.MatchString(bytesToString(b))
+		}
+		return matched
+	}
+	if byPassStatsEnabled && re.stats != nil {
+		atomic.AddUint64(&re.stats.fallback, uint64(len(lines)))
+	}
+
+	m := re.get()
+	for i, b := range lines {
+		matched[i] = m.execute(nil, b, "", 0, 0, nil) != nil
+	}
+	re.put(m)
+	return matched
+}
+
+// MatchRecords splits s into records separated by sep, like strings.Split, and
+// reports for each of them whether it contains any match of the regular
+// expression. Each record is matched as a whole string: `^` and `$` match at
+// its boundaries. It can be used on NUL-delimited data, where multi-line mode
+// would only know about `\n`. A single machine is used for all the records.
+func (re *Regexp) MatchRecords(s string, sep byte) []bool {
+	matched := make([]bool, 0, strings.Count(s, string([]byte{sep}))+1)
+
+	var m *machine
+	if re.bypass != notByPass {
+		if byPassStatsEnabled && re.stats != nil {
+			atomic.AddUint64(&re.stats.byPass, uint64(cap(matched)))
+		}
+	} else {
+		if byPassStatsEnabled && re.stats != nil {
+			atomic.AddUint64(&re.stats.fallback, uint64(cap(matched)))
+		}
+		m = re.get()
+	}
+
+	for {
+		end := strings.IndexByte(s, sep)
+		if end == -1 {
+			end = len(s)
+		}
+		if m == nil {
+			matched = append(matched, re.bypass.MatchString(s[:end]))
+		} else {
+			matched = append(matched, m.execute(nil, nil, s[:end], 0, 0, nil) != nil)
+		}
+		if end == len(s) {
+			break
+		}
+		s = s[end+1:]
+	}
+
+	if m != nil {
+		re.put(m)
+	}
+	return matched
+}
+
+// MatchLines splits s into lines separated by '\n', and reports for each of them
+// whether it contains any match of the regular expression. Each line is matched
+// on its own: with `(?m)`, `^` and `$` only match at its boundaries. A final
+// '\n' doesn't start another line, and empty lines are reported too.
+//
+// Multi-line patterns anchored on lines (`(?m)^ERROR`) are executed by the bypass
+// matcher on each line, which is much faster than running the other matchers on s.
+func (re *Regexp) MatchLines(s string) []bool {
+	lines := strings.Count(s, "\n")
+	if s != "" && s[len(s)-1] != '\n' {
+		lines++
+	}
+	matched := make([]bool, 0, lines)
+
+	prog := re.bypass
+	if prog == notByPass {
+		prog = re.bypassLines
+	}
+	var m *machine
+	if prog != notByPass {
+		if byPassStatsEnabled && re.stats != nil {
+			atomic.AddUint64(&re.stats.byPass, uint64(lines))
+		}
+	} else {
+		if byPassStatsEnabled && re.stats != nil {
+			atomic.AddUint64(&re.stats.fallback, uint64(lines))
+		}
+		m = re.get()
+	}
+
+	for s != "" {
+		end := strings.IndexByte(s, '\n')
+		if end == -1 {
+			end = len(s)
+		}
+		if m == nil {
+			matched = append(matched, prog.MatchString(s[:end]))
+		} else {
+			matched = append(matched, m.execute(nil, nil, s[:end], 0, 0, nil) != nil)
+		}
+		if end == len(s) {
+			break
+		}
+		s = s[end+1:]
+	}
+
+	if m != nil {
+		re.put(m)
+	}
+	return matched
+}
+
 // Match reports whether the Regexp matches the byte slice b.
 func (re *Regexp) Match(b []byte) bool {
+	if re.bypass != notByPass {
+		return re.MatchString(bytesToString(b))
+	}
 	return re.doMatch(nil, b, "")
 }
 
@@ -497,6 +822,10 @@ func (re *Regexp) ReplaceAllString(src, repl string) string {
 // with the replacement string repl. The replacement repl is substituted directly,
 // without using Expand.
 func (re *Regexp) ReplaceAllLiteralString(src, repl string) string {
+	// Unanchored literal-only patterns are replaced exactly like strings.ReplaceAll would
+	if prog, ok := re.bypass.(*byPassProgLiteral); ok && !prog.anchoredBegin && !prog.anchoredEnd && !prog.wordBoundaryEnd && prog.literal != "" {
+		return strings.ReplaceAll(src, prog.literal, repl)
+	}
 	return string(re.replaceAll(nil, src, 2, func(dst []byte, match []int) []byte {
 		return append(dst, repl...)
 	}))
@@ -528,8 +857,22 @@ func (re *Regexp) replaceAll(bsrc []byte, src string, nmatch int, repl func(dst
 	}
 
 	var dstCap [2]int
+	var cursor byPassCursor
+	if re.bypassIndex != nil && nmatch == 2 {
+		cursor = newByPassCursor(re.bypassIndex)
+		if bsrc != nil {
+			src = bytesToString(bsrc)
+		}
+	}
 	for searchPos <= endPos {
-		a := re.doExecute(nil, bsrc, src, searchPos, nmatch, dstCap[:0])
+		var a []int
+		if cursor.prog != nil {
+			if matchBegin, matchEnd := cursor.IndexString(src, searchPos); matchBegin != -1 {
+				a = append(dstCap[:0], matchBegin, matchEnd)
+			}
+		} else {
+			a = re.doExecute(nil, bsrc, src, searchPos, nmatch, dstCap[:0])
+		}
 		if len(a) == 0 {
 			break // no more matches
 		}
@@ -678,49 +1021,102 @@ func (re *Regexp) pad(a []int) []int {
 
 // Find matches in slice b if b is non-nil, otherwise find matches in string s.
 func (re *Regexp) allMatches(s string, b []byte, n int, deliver func([]int)) {
-	var end int
-	if b == nil {
-		end = len(s)
-	} else {
-		end = len(b)
+	re.eachMatch(s, b, n, func(match []int) bool {
+		deliver(match)
+		return true
+	})
+}
+
+// eachMatch is like allMatches, but stops as soon as yield returns false.
+func (re *Regexp) eachMatch(s string, b []byte, n int, yield func([]int) bool) {
+	it := re.newMatchIterator(s, b, re.prog.NumCap)
+	for i := 0; i < n; i++ {
+		matches := it.next(nil)
+		if matches == nil || !yield(re.pad(matches)) {
+			return
+		}
+	}
+}
+
+// matchIterator finds the successive matches of the 'All' routines, in slice b
+// if b is non-nil, otherwise in string s.
+type matchIterator struct {
+	re           *Regexp
+	s            string
+	b            []byte
+	end          int
+	ncap         int          // number of submatch indices computed
+	cursor       byPassCursor // locates the matches with the bypass matcher if its prog isn't nil
+	byteStep     bool         // steps over a byte instead of a rune after an empty match, in byte mode
+	pos          int
+	prevMatchEnd int
+}
+
+// newMatchIterator returns an iterator computing ncap submatch indices for each
+// match. With ncap 2, the matches are located by the bypass matcher if possible.
+func (re *Regexp) newMatchIterator(s string, b []byte, ncap int) matchIterator {
+	it := matchIterator{re: re, s: s, b: b, end: len(s), ncap: ncap, prevMatchEnd: -1}
+	if b != nil {
+		it.end = len(b)
+	}
+	if re.bypassIndex != nil && ncap == 2 {
+		it.cursor = newByPassCursor(re.bypassIndex)
+		if b != nil {
+			it.s = bytesToString(b)
+		}
+		_, it.byteStep = re.bypassIndex.(*byPassProgBytes)
 	}
+	return it
+}
 
-	for pos, i, prevMatchEnd := 0, 0, -1; i < n && pos <= end; {
-		matches := re.doExecute(nil, b, s, pos, re.prog.NumCap, nil)
+// next appends the indices of the next match to dstCap and returns them,
+// or returns nil if there is none.
+func (it *matchIterator) next(dstCap []int) []int {
+	for it.pos <= it.end {
+		var matches []int
+		if it.cursor.prog != nil {
+			if matchBegin, matchEnd := it.cursor.IndexString(it.s, it.pos); matchBegin != -1 {
+				matches = append(dstCap, matchBegin, matchEnd)
+			}
+		} else {
+			matches = it.re.doExecute(nil, it.b, it.s, it.pos, it.ncap, dstCap)
+		}
 		if len(matches) == 0 {
 			break
 		}
 
 		accept := true
-		if matches[1] == pos {
+		if matches[1] == it.pos {
 			// We've found an empty match.
-			if matches[0] == prevMatchEnd {
+			if matches[0] == it.prevMatchEnd {
 				// We don't allow an empty match right
 				// after a previous match, so ignore it.
 				accept = false
 			}
 			var width int
 			// TODO: use step()
-			if b == nil {
-				_, width = utf8.DecodeRuneInString(s[pos:end])
+			if it.byteStep {
+				width = 1
+			} else if it.b == nil {
+				_, width = utf8.DecodeRuneInString(it.s[it.pos:it.end])
 			} else {
-				_, width = utf8.DecodeRune(b[pos:end])
+				_, width = utf8.DecodeRune(it.b[it.pos:it.end])
 			}
 			if width > 0 {
-				pos += width
+				it.pos += width
 			} else {
-				pos = end + 1
+				it.pos = it.end + 1
 			}
 		} else {
-			pos = matches[1]
+			it.pos = matches[1]
 		}
-		prevMatchEnd = matches[1]
+		it.prevMatchEnd = matches[1]
 
 		if accept {
-			deliver(re.pad(matches))
-			i++
+			return matches
 		}
 	}
+	return nil
 }
 
 // Find returns a slice holding the text of the leftmost match in b of the regular expression.
@@ -739,7 +1135,12 @@ func (re *Regexp) Find(b []byte) []byte {
 // b[loc[0]:loc[1]].
 // A return value of nil indicates no match.
 func (re *Regexp) FindIndex(b []byte) (loc []int) {
-	a := re.doExecute(nil, b, "", 0, 2, nil)
+	var a []int
+	if re.bypassIndex != nil {
+		a = re.doExecuteByPass(b, "", 0, nil)
+	} else {
+		a = re.doExecute(nil, b, "", 0, 2, nil)
+	}
 	if a == nil {
 		return nil
 	}
@@ -778,6 +1179,13 @@ func (re *Regexp) FindStringIndex(s string) (loc []int) {
 // byte offset loc[0] through loc[1]-1.
 // A return value of nil indicates no match.
 func (re *Regexp) FindReaderIndex(r io.RuneReader) (loc []int) {
+	if rp, ok := re.bypass.(byPassReaderProg); ok {
+		matchBegin, matchEnd := rp.ReaderIndex(r)
+		if matchBegin == -1 {
+			return nil
+		}
+		return []int{matchBegin, matchEnd}
+	}
 	a := re.doExecute(r, nil, "", 0, 2, nil)
 	if a == nil {
 		return nil
@@ -949,7 +1357,12 @@ func (re *Regexp) FindSubmatchIndex(b []byte) []int {
 // A return value of nil indicates no match.
 func (re *Regexp) FindStringSubmatch(s string) []string {
 	var dstCap [4]int
-	a := re.doExecute(nil, nil, s, 0, re.prog.NumCap, dstCap[:0])
+	var a []int
+	if re.bypassSubmatch != nil {
+		a = re.bypassSubmatch.FindStringSubmatchIndex(s)
+	} else {
+		a = re.doExecute(nil, nil, s, 0, re.prog.NumCap, dstCap[:0])
+	}
 	if a == nil {
 		return nil
 	}
@@ -968,6 +1381,9 @@ func (re *Regexp) FindStringSubmatch(s string) []string {
 // 'Index' descriptions in the package comment.
 // A return value of nil indicates no match.
 func (re *Regexp) FindStringSubmatchIndex(s string) []int {
+	if re.bypassSubmatch != nil {
+		return re.pad(re.bypassSubmatch.FindStringSubmatchIndex(s))
+	}
 	return re.pad(re.doExecute(nil, nil, s, 0, re.prog.NumCap, nil))
 }
 
@@ -990,7 +1406,7 @@ func (re *Regexp) FindAll(b []byte, n int) [][]byte {
 	if n < 0 {
 		n = len(b) + 1
 	}
-	result := make([][]byte, 0, startSize)
+	result := make([][]byte, 0, re.findAllCapacity(len(b), n))
 	re.allMatches("", b, n, func(match []int) {
 		result = append(result, b[match[0]:match[1]])
 	})
@@ -1008,7 +1424,7 @@ func (re *Regexp) FindAllIndex(b []byte, n int) [][]int {
 	if n < 0 {
 		n = len(b) + 1
 	}
-	result := make([][]int, 0, startSize)
+	result := make([][]int, 0, re.findAllCapacity(len(b), n))
 	re.allMatches("", b, n, func(match []int) {
 		result = append(result, match[0:2])
 	})
@@ -1026,7 +1442,7 @@ func (re *Regexp) FindAllString(s string, n int) []string {
 	if n < 0 {
 		n = len(s) + 1
 	}
-	result := make([]string, 0, startSize)
+	result := make([]string, 0, re.findAllCapacity(len(s), n))
 	re.allMatches(s, nil, n, func(match []int) {
 		result = append(result, s[match[0]:match[1]])
 	})
@@ -1044,7 +1460,7 @@ func (re *Regexp) FindAllStringIndex(s string, n int) [][]int {
 	if n < 0 {
 		n = len(s) + 1
 	}
-	result := make([][]int, 0, startSize)
+	result := make([][]int, 0, re.findAllCapacity(len(s), n))
 	re.allMatches(s, nil, n, func(match []int) {
 		result = append(result, match[0:2])
 	})
@@ -1054,6 +1470,37 @@ func (re *Regexp) FindAllStringIndex(s string, n int) [][]int {
 	return result
 }
 
+// AllStringIndex returns an iterator over the successive matches of the
+// expression in s, like FindAllStringIndex with n < 0, but without collecting
+// them in a slice first. Each match is a pair of indices, as returned by
+// FindStringIndex. It can be used in a range loop:
+//
+//	for loc := range re.AllStringIndex(s) {
+//		...
+//	}
+func (re *Regexp) AllStringIndex(s string) func(yield func([]int) bool) {
+	return func(yield func([]int) bool) {
+		re.eachMatch(s, nil, len(s)+1, func(match []int) bool {
+			return yield(match[0:2])
+		})
+	}
+}
+
+// AppendStringIndex appends the index pairs of all the successive matches of
+// the expression in s to dst, flattened: the i-th match is at
+// s[dst[2*i]:dst[2*i+1]], counting from the original length of dst. It
+// returns the extended slice. The matches are the ones FindAllStringIndex
+// returns with n < 0, but no slice is allocated for each of them, so that
+// callers can reuse the storage of dst across calls.
+func (re *Regexp) AppendStringIndex(dst []int, s string) []int {
+	var dstCap [2]int
+	it := re.newMatchIterator(s, nil, 2)
+	for match := it.next(dstCap[:0]); match != nil; match = it.next(dstCap[:0]) {
+		dst = append(dst, match[0], match[1])
+	}
+	return dst
+}
+
 // FindAllSubmatch is the 'All' version of FindSubmatch; it returns a slice
 // of all successive matches of the expression, as defined by the 'All'
 // description in the package comment.
@@ -1062,7 +1509,7 @@ func (re *Regexp) FindAllSubmatch(b []byte, n int) [][][]byte {
 	if n < 0 {
 		n = len(b) + 1
 	}
-	result := make([][][]byte, 0, startSize)
+	result := make([][][]byte, 0, re.findAllCapacity(len(b), n))
 	re.allMatches("", b, n, func(match []int) {
 		slice := make([][]byte, len(match)/2)
 		for j := range slice {
@@ -1086,7 +1533,7 @@ func (re *Regexp) FindAllSubmatchIndex(b []byte, n int) [][]int {
 	if n < 0 {
 		n = len(b) + 1
 	}
-	result := make([][]int, 0, startSize)
+	result := make([][]int, 0, re.findAllCapacity(len(b), n))
 	re.allMatches("", b, n, func(match []int) {
 		result = append(result, match)
 	})
@@ -1104,7 +1551,7 @@ func (re *Regexp) FindAllStringSubmatch(s string, n int) [][]string {
 	if n < 0 {
 		n = len(s) + 1
 	}
-	result := make([][]string, 0, startSize)
+	result := make([][]string, 0, re.findAllCapacity(len(s), n))
 	re.allMatches(s, nil, n, func(match []int) {
 		slice := make([]string, len(match)/2)
 		for j := range slice {
@@ -1129,7 +1576,7 @@ func (re *Regexp) FindAllStringSubmatchIndex(s string, n int) [][]int {
 	if n < 0 {
 		n = len(s) + 1
 	}
-	result := make([][]int, 0, startSize)
+	result := make([][]int, 0, re.findAllCapacity(len(s), n))
 	re.allMatches(s, nil, n, func(match []int) {
 		result = append(result, match)
 	})